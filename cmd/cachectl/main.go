@@ -0,0 +1,190 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// cachectl exports, imports and inspects the Triage Party cache.
+//
+// ** Seeding a new deployment from an existing one:
+//
+// cachectl --persist-backend=disk dump cache.tar.gz
+// cachectl --persist-backend=sqlite --persist-path=/var/lib/tp/cache.db load cache.tar.gz
+//
+// ** Showing what is cached for a repository:
+//
+// cachectl --repos=kubernetes/minikube stats
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/triage-party/pkg/persist"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	persistBackend = flag.String("persist-backend", "", "Cache persistence backend (disk, mysql, postgres, cloudsql, sqlite, redis, s3)")
+	persistPath    = flag.String("persist-path", "", "Where the cache is persisted to (automatic)")
+	persistFormat  = flag.String("persist-format", "", "How cached entries are stored (gob, json, gob+zstd, json+zstd), also settable via PERSIST_FORMAT")
+	persistKeyFile = flag.String("persist-key-file", "", "Keys the cache is encrypted with, also settable via PERSIST_KEY_FILE")
+
+	repos    = flag.String("repos", "", "Only include keys for these repositories (comma separated, for example: kubernetes/minikube)")
+	prefixes = flag.String("prefix", "", "Only include keys with these prefixes (comma separated)")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] (dump <archive> | load <archive> | stats)\n\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	switch {
+	case len(args) == 2 && (args[0] == "dump" || args[0] == "load"):
+	case len(args) == 1 && args[0] == "stats":
+	default:
+		usage()
+	}
+
+	f := persist.KeyFilter{}
+	for _, r := range strings.Split(*repos, ",") {
+		if r != "" {
+			f.Repos = append(f.Repos, r)
+		}
+	}
+	for _, p := range strings.Split(*prefixes, ",") {
+		if p != "" {
+			f.Prefixes = append(f.Prefixes, p)
+		}
+	}
+
+	c, err := persist.FromEnv("triage-party", *persistBackend, *persistPath, *persistFormat, *persistKeyFile)
+	if err != nil {
+		klog.Exitf("unable to create persistence layer: %v", err)
+	}
+
+	if err := c.Initialize(); err != nil {
+		klog.Exitf("persist initialize for %s: %v", c, err)
+	}
+
+	switch args[0] {
+	case "dump":
+		err = dump(c, args[1], f)
+	case "load":
+		err = load(c, args[1], f)
+	case "stats":
+		err = stats(c, f)
+	}
+
+	if err != nil {
+		klog.Exitf("%s: %v", args[0], err)
+	}
+
+	// Some backends write asynchronously, and must be closed before exiting
+	if cl, ok := c.(io.Closer); ok {
+		if err := cl.Close(); err != nil {
+			klog.Exitf("close %s: %v", c, err)
+		}
+	}
+}
+
+// dump writes a cache to an archive, compressed if the path ends with .gz
+func dump(c persist.Cacher, path string, f persist.KeyFilter) error {
+	fh, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	defer fh.Close()
+
+	var w io.Writer = fh
+	var gw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gw = gzip.NewWriter(fh)
+		w = gw
+	}
+
+	n, err := persist.Dump(c, w, f)
+	if err != nil {
+		return err
+	}
+
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+	}
+
+	fmt.Printf("dumped %d entries from %s to %s\n", n, c, path)
+	return nil
+}
+
+// load stores the contents of an archive into a cache
+func load(c persist.Cacher, path string, f persist.KeyFilter) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer fh.Close()
+
+	var r io.Reader = fh
+	if strings.HasSuffix(path, ".gz") {
+		gr, err := gzip.NewReader(fh)
+		if err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	n, err := persist.Load(c, r, f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("loaded %d entries from %s into %s\n", n, path, c)
+	return nil
+}
+
+// stats shows key counts and ages per repository
+func stats(c persist.Cacher, f persist.KeyFilter) error {
+	st, err := persist.Stats(c, f)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tKEYS\tBYTES\tOLDEST\tNEWEST")
+	for _, rs := range st {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", rs.Repo, rs.Keys, rs.Bytes, age(rs.Oldest), age(rs.Newest))
+	}
+	return tw.Flush()
+}
+
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return time.Since(t).Round(time.Minute).String()
+}
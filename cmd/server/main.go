@@ -27,17 +27,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/slowjam/pkg/stacklog"
 	"github.com/google/triage-party/pkg/constants"
+	"github.com/google/triage-party/pkg/hubbub"
 	"github.com/google/triage-party/pkg/provider"
 
 	"k8s.io/klog/v2"
@@ -54,8 +59,15 @@ var (
 
 	// shared with tester
 	configPath     = flag.String("config", "", "configuration path (defaults to searching for config.yaml)")
-	persistBackend = flag.String("persist-backend", "", "Cache persistence backend (disk, mysql, cloudsql)")
+	persistBackend = flag.String("persist-backend", "", "Cache persistence backend (disk, memory, mysql, postgres, cloudsql, sqlite, redis, s3)")
 	persistPath    = flag.String("persist-path", "", "Where to persist cache to (automatic)")
+	persistFormat  = flag.String("persist-format", "", "How cached entries are stored (gob, json, gob+zstd, json+zstd), also settable via PERSIST_FORMAT")
+	persistKeyFile = flag.String("persist-key-file", "", "Encrypt the persistent cache with keys from this file, also settable via PERSIST_KEY_FILE")
+
+	// cache compaction
+	persistMaxUnread       = flag.Duration("persist-max-unread", 30*24*time.Hour, "Remove cached entries which have not been read for this long (0 to disable)")
+	persistMaxBytes        = flag.Int64("persist-max-bytes", 0, "Maximum size of the persistent cache, least recently read entries are removed first (0 for unlimited)")
+	persistCompactInterval = flag.Duration("persist-compact-interval", time.Hour, "How often to compact the persistent cache")
 
 	reposOverride   = flag.String("repos", "", "Override configured repos with this repository (comma separated)")
 	gitHubTokenFile = flag.String("github-token-file", "", "github token secret file, also settable via "+constants.GitHubTokenEnvVar)
@@ -69,9 +81,13 @@ var (
 	siteName      = flag.String("name", "", "override site name from config file")
 	numbers       = flag.String("nums", "", "only display results for these comma-delimited issue/PR numbers (debug)")
 
-	maxRefresh = flag.Duration("max-refresh", 60*time.Minute, "Maximum time between collection runs")
-	minRefresh = flag.Duration("min-refresh", 60*time.Second, "Minimum time between collection runs")
-	warnAge    = flag.Duration("warn-age", 90*time.Minute, "Warn when the results are older than this")
+	maxRefresh         = flag.Duration("max-refresh", 60*time.Minute, "Maximum time between collection runs")
+	minRefresh         = flag.Duration("min-refresh", 60*time.Second, "Minimum time between collection runs")
+	refreshConcurrency = flag.Int("refresh-concurrency", updater.DefaultConcurrency, "Maximum number of collections to refresh at once")
+	analyzerWorkers    = flag.Int("analyzer-workers", hubbub.DefaultAnalyzerWorkers, "Maximum number of items to analyze at once, shared by all collection refreshes")
+	warnAge            = flag.Duration("warn-age", 90*time.Minute, "Warn when the results are older than this")
+	elect              = flag.Bool("elect", false, "Elect one replica to refresh collections, sharing its results through the persistent cache (mysql, postgres, cloudsql, sqlite)")
+	shutdownTimeout    = flag.Duration("shutdown-timeout", 20*time.Second, "How long to wait for requests and refreshes to finish when shutting down")
 )
 
 func main() {
@@ -90,14 +106,16 @@ func main() {
 		klog.Warningf("--config and CONFIG_PATH were empty, falling back to %s", cp)
 	}
 
-	ctx := context.Background()
+	// SIGTERM or SIGINT cancel the context, which stops background work and in-flight API calls
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
 	f, err := os.Open(findPath(cp))
 	if err != nil {
 		klog.Exitf("open %s: %v", cp, err)
 	}
 
-	c, err := persist.FromEnv("triage-party", *persistBackend, *persistPath)
+	c, err := persist.FromEnv("triage-party", *persistBackend, *persistPath, *persistFormat, *persistKeyFile)
 	if err != nil {
 		klog.Exitf("unable to create persistence layer: %v", err)
 	}
@@ -106,6 +124,21 @@ func main() {
 		klog.Exitf("persist initialize for %s: %v", c, err)
 	}
 
+	if _, ok := c.(persist.Leaser); *elect && !ok {
+		klog.Exitf("--elect requires a persistence backend which replicas can share (mysql, postgres, cloudsql, sqlite), not %s", c)
+	}
+
+	compactor, err := persist.NewCompactor(c, persist.CompactConfig{
+		MaxUnread: *persistMaxUnread,
+		MaxBytes:  *persistMaxBytes,
+		Interval:  *persistCompactInterval,
+	})
+	if err != nil {
+		klog.Warningf("cache compaction disabled: %v", err)
+	} else if *persistMaxUnread > 0 || *persistMaxBytes > 0 {
+		go compactor.Loop(ctx)
+	}
+
 	var debugNums []int
 	for _, n := range strings.Split(*numbers, ",") {
 		i, err := strconv.Atoi(n)
@@ -120,6 +153,8 @@ func main() {
 		GitHubAPIURL: *gitHubAPIURL,
 		GitHubToken:  provider.ReadToken(*gitHubTokenFile, "GITHUB_TOKEN"),
 		GitLabToken:  provider.ReadToken(*gitLabTokenFile, "GITLAB_TOKEN"),
+
+		AnalyzerWorkers: *analyzerWorkers,
 	}
 
 	if *reposOverride != "" {
@@ -153,24 +188,35 @@ func main() {
 	}
 
 	u := updater.New(updater.Config{
-		Party:      tp,
-		MinRefresh: *minRefresh,
-		MaxRefresh: *maxRefresh,
+		Party:       tp,
+		MinRefresh:  *minRefresh,
+		MaxRefresh:  *maxRefresh,
+		Concurrency: *refreshConcurrency,
+		Cache:       c,
+		Elect:       *elect,
 	})
 
 	if *dryRun {
 		klog.Infof("Updating ...")
 		if _, err := u.RunOnce(ctx, true); err != nil {
+			closeCache(c)
 			klog.Exitf("run failed: %v", err)
 		}
+		closeCache(c)
 		os.Exit(0)
 	}
 
+	if _, err := u.Restore(); err != nil {
+		klog.Errorf("restore: %v", err)
+	}
+
 	klog.Infof("Starting update loop: %+v", u)
 
+	loopDone := make(chan struct{})
 	go func() {
-		if err := u.Loop(ctx); err == nil {
-			klog.Exitf("loop failed: %v", err)
+		defer close(loopDone)
+		if err := u.Loop(ctx); err != nil {
+			klog.Errorf("loop failed: %v", err)
 		}
 	}()
 
@@ -178,6 +224,7 @@ func main() {
 		BaseDirectory: findPath(*siteDir),
 		Updater:       u,
 		Party:         tp,
+		Cache:         c,
 		WarnAge:       *warnAge,
 		Name:          sn,
 	})
@@ -186,6 +233,9 @@ func main() {
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(filepath.Join(findPath(*siteDir), "static")))))
 	http.HandleFunc("/s/", s.Collection())
 	http.HandleFunc("/k/", s.Kanban())
+	http.HandleFunc("/clusters", s.Clusters())
+	http.HandleFunc("/debug/cache", s.DebugCache())
+	http.HandleFunc("/debug/analyzer", s.DebugAnalyzer())
 	http.HandleFunc("/healthz", s.Healthz())
 	http.HandleFunc("/threadz", s.Threadz())
 
@@ -200,10 +250,55 @@ func main() {
 		listenAddr = fmt.Sprintf(":%d", *port)
 	}
 
-	fmt.Printf("\n\n*** teaparty is listening at %s ... ***\n\n", listenAddr)
-	err = http.ListenAndServe(listenAddr, nil)
-	if err != nil {
-		panic(err)
+	srv := &http.Server{Addr: listenAddr}
+	srvErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("\n\n*** teaparty is listening at %s ... ***\n\n", listenAddr)
+		srvErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-srvErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	case <-ctx.Done():
+	}
+
+	// A second signal exits immediately
+	stop()
+	shutdown(srv, loopDone, c)
+}
+
+// shutdown drains HTTP requests and waits for the update loop to stop, then flushes the cache
+func shutdown(srv *http.Server, loopDone <-chan struct{}, c persist.Cacher) {
+	klog.Infof("shutting down: waiting up to %s for requests and refreshes to finish", *shutdownTimeout)
+	sctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(sctx); err != nil {
+		klog.Errorf("http shutdown: %v", err)
+	}
+
+	select {
+	case <-loopDone:
+	case <-sctx.Done():
+		klog.Errorf("update loop did not stop within %s", *shutdownTimeout)
+	}
+
+	closeCache(c)
+	klog.Infof("shutdown complete")
+}
+
+// closeCache flushes pending writes for backends which write asynchronously
+func closeCache(c persist.Cacher) {
+	cl, ok := c.(io.Closer)
+	if !ok {
+		return
+	}
+
+	if err := cl.Close(); err != nil {
+		klog.Errorf("close %s: %v", c, err)
 	}
 }
 
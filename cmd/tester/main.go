@@ -69,7 +69,7 @@ func main() {
 		klog.Exitf("open %s: %v", *configPath, err)
 	}
 
-	c, err := persist.FromEnv("triage-party", *persistBackend, *persistPath)
+	c, err := persist.FromEnv("triage-party", *persistBackend, *persistPath, "", "")
 	if err != nil {
 		klog.Exitf("unable to create persistence layer: %v", err)
 	}
@@ -130,9 +130,16 @@ func executeCollection(ctx context.Context, tp *triage.Party) {
 	fmt.Printf("// Average delay: %s\n", toDays(r.AvgAccumulatedHold))
 	fmt.Printf("// Average hold: %s\n", toDays(r.AvgCurrentHold))
 
+	for _, w := range r.Warnings {
+		fmt.Printf("// WARNING: %s\n", w)
+	}
+
 	for _, o := range r.RuleResults {
 		fmt.Printf("## %s\n", o.Rule.Name)
 		fmt.Printf(" #  %d items\n", len(o.Items))
+		for _, w := range o.Warnings {
+			fmt.Printf(" #  WARNING: %s\n", w)
+		}
 		for _, i := range o.Items {
 			s, err := json.MarshalIndent(i, "", "  ")
 			if err != nil {
@@ -165,6 +172,9 @@ func executeRule(ctx context.Context, tp *triage.Party) {
 	fmt.Printf("// Average age: %s\n", toDays(rr.AvgAge))
 	fmt.Printf("// Average current hold: %s\n", toDays(rr.AvgCurrentHold))
 	fmt.Printf("// Average accumulated hold: %s\n", toDays(rr.AvgAccumulatedHold))
+	for _, w := range rr.Warnings {
+		fmt.Printf("// WARNING: %s\n", w)
+	}
 
 	for _, i := range rr.Items {
 		s, err := json.MarshalIndent(i, "", "  ")
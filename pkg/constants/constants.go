@@ -18,6 +18,7 @@ const (
 	OpenState   = "open"
 	OpenedState = "opened"
 	ClosedState = "closed"
+	AllState    = "all"
 
 	UpdatedSortOption   = "updated"
 	UpdatedAtSortOption = "updated_at"
@@ -24,8 +24,6 @@ import (
 	"k8s.io/klog/v2"
 )
 
-const analyzerWorkerCount = 6
-
 func (h *Engine) analyzeIssueMatches(ctx context.Context, is []*provider.Issue, sp provider.SearchParams, age time.Time, latestIssueUpdate time.Time) []*Conversation {
 	if len(is) == 0 {
 		klog.Warningf("asked to analyze 0 issues")
@@ -33,23 +31,20 @@ func (h *Engine) analyzeIssueMatches(ctx context.Context, is []*provider.Issue,
 	}
 
 	start := time.Now()
-
-	numWorkers := analyzerWorkerCount
-	if len(is) < numWorkers {
-		numWorkers = len(is)
-	}
-
-	jobs := make(chan *provider.Issue, len(is))
 	results := make(chan *Conversation, len(is))
-
-	for w := 1; w <= numWorkers; w++ {
-		go h.analyzeIssueWorker(ctx, jobs, results, sp, age, latestIssueUpdate)
-	}
+	urgent := onDemand(ctx)
 
 	for _, i := range is {
-		jobs <- i
+		i := i
+		h.analyzers.submit(func() {
+			// Skip analysis once cancelled, so that the pool drains quickly
+			if ctx.Err() != nil {
+				results <- nil
+				return
+			}
+			results <- h.analyzeIssue(ctx, i, sp, age, latestIssueUpdate)
+		}, urgent)
 	}
-	close(jobs)
 
 	cs := []*Conversation{}
 	for range is {
@@ -61,17 +56,11 @@ func (h *Engine) analyzeIssueMatches(ctx context.Context, is []*provider.Issue,
 	}
 
 	close(results)
-	klog.Infof("found %d matches for %d issues in %s (%d workers)", len(cs), len(is), time.Since(start), numWorkers)
+	m := h.analyzers.metrics()
+	klog.Infof("found %d matches for %d issues in %s (%d workers, %d queued)", len(cs), len(is), time.Since(start), m.Workers, m.Queued)
 	return cs
 }
 
-func (h *Engine) analyzeIssueWorker(ctx context.Context, jobs chan *provider.Issue, results chan *Conversation, sp provider.SearchParams, age time.Time, latestIssueUpdate time.Time) {
-	for j := range jobs {
-		co := h.analyzeIssue(ctx, j, sp, age, latestIssueUpdate)
-		results <- co
-	}
-}
-
 func (h *Engine) analyzeIssue(ctx context.Context, i *provider.Issue, sp provider.SearchParams, age time.Time, latestIssueUpdate time.Time) *Conversation {
 	// Workaround API inconsistency: issues use a list of labels, prs a list of label pointers
 	labels := []*provider.Label{}
@@ -110,7 +99,7 @@ func (h *Engine) analyzeIssue(ctx context.Context, i *provider.Issue, sp provide
 		co.Tags[tag.Similar] = true
 	}
 
-	if !postFetchMatch(co, sp.Filters) {
+	if !postFetchMatch(co, sp.Filters) || !h.matchClusterSize(co, sp.Filters) {
 		klog.V(1).Infof("#%d - %q did not match post-fetch filter: %s", i.GetNumber(), i.GetTitle(), sp.Filters)
 		return nil
 	}
@@ -119,10 +108,15 @@ func (h *Engine) analyzeIssue(ctx context.Context, i *provider.Issue, sp provide
 	updatedAt := h.mtime(i)
 	var timeline []*provider.Timeline
 	fetchTimeline := false
-	if needTimeline(i, sp.Filters, false, sp.Hidden) {
+	if needTimeline(i, sp.Filters, false, sp.Hidden) || needEventsForUpdated(co, sp.Filters) {
 		fetchTimeline = !sp.NewerThan.IsZero()
 	}
 
+	if fetchTimeline && sp.Hidden && h.conserving() {
+		klog.V(1).Infof("#%d - skipping timeline for hidden collection to conserve API quota", i.GetNumber())
+		fetchTimeline = false
+	}
+
 	sp.IssueNumber = i.GetNumber()
 	sp.Fetch = fetchTimeline
 	sp.UpdateAt = updatedAt
@@ -142,6 +136,7 @@ func (h *Engine) analyzeIssue(ctx context.Context, i *provider.Issue, sp provide
 	sp.NewerThan = latestIssueUpdate
 	sp.Fetch = fetchReviews
 	co.PullRequestRefs = h.updateLinkedPRs(ctx, sp, co)
+	addLinkedTags(co)
 
 	if !postEventsMatch(co, sp.Filters) {
 		klog.V(1).Infof("#%d - %q did not match post-events filter: %s", i.GetNumber(), i.GetTitle(), sp.Filters)
@@ -159,23 +154,20 @@ func (h *Engine) analyzePRMatches(ctx context.Context, prs []*provider.PullReque
 	}
 
 	start := time.Now()
-
-	numWorkers := analyzerWorkerCount
-	if len(prs) < numWorkers {
-		numWorkers = len(prs)
-	}
-
-	jobs := make(chan *provider.PullRequest, len(prs))
 	results := make(chan *Conversation, len(prs))
-
-	for w := 1; w <= numWorkers; w++ {
-		go h.analyzePRWorker(ctx, jobs, results, sp, age)
-	}
+	urgent := onDemand(ctx)
 
 	for _, pr := range prs {
-		jobs <- pr
+		pr := pr
+		h.analyzers.submit(func() {
+			// Skip analysis once cancelled, so that the pool drains quickly
+			if ctx.Err() != nil {
+				results <- nil
+				return
+			}
+			results <- h.analyzePR(ctx, pr, sp, age)
+		}, urgent)
 	}
-	close(jobs)
 
 	cs := []*Conversation{}
 	for range prs {
@@ -187,17 +179,11 @@ func (h *Engine) analyzePRMatches(ctx context.Context, prs []*provider.PullReque
 	}
 
 	close(results)
-	klog.Infof("found %d matches for %d PRs in %s (%d workers)", len(cs), len(prs), time.Since(start), numWorkers)
+	m := h.analyzers.metrics()
+	klog.Infof("found %d matches for %d PRs in %s (%d workers, %d queued)", len(cs), len(prs), time.Since(start), m.Workers, m.Queued)
 	return cs
 }
 
-func (h *Engine) analyzePRWorker(ctx context.Context, jobs chan *provider.PullRequest, results chan *Conversation, sp provider.SearchParams, age time.Time) {
-	for j := range jobs {
-		co := h.analyzePR(ctx, j, sp, age)
-		results <- co
-	}
-}
-
 func (h *Engine) analyzePR(ctx context.Context, pr *provider.PullRequest, sp provider.SearchParams, age time.Time) *Conversation {
 	if !preFetchMatch(pr, pr.Labels, sp.Filters) {
 		return nil
@@ -226,6 +212,11 @@ func (h *Engine) analyzePR(ctx context.Context, pr *provider.PullRequest, sp pro
 		fetchTimeline = !sp.NewerThan.IsZero()
 	}
 
+	if fetchTimeline && sp.Hidden && h.conserving() {
+		klog.V(1).Infof("#%d - skipping timeline for hidden collection to conserve API quota", pr.GetNumber())
+		fetchTimeline = false
+	}
+
 	sp.IssueNumber = pr.GetNumber()
 	sp.NewerThan = h.mtime(pr)
 	sp.Fetch = fetchTimeline
@@ -263,7 +254,7 @@ func (h *Engine) analyzePR(ctx context.Context, pr *provider.PullRequest, sp pro
 		co.Tags[tag.Similar] = true
 	}
 
-	if !postFetchMatch(co, sp.Filters) {
+	if !postFetchMatch(co, sp.Filters) || !h.matchClusterSize(co, sp.Filters) {
 		klog.V(4).Infof("PR #%d did not pass postFetchMatch with filter: %v", pr.GetNumber(), sp.Filters)
 		return nil
 	}
@@ -328,6 +319,21 @@ func needComments(i provider.IItem, fs []provider.Filter) bool {
 	return (i.GetState() == constants.OpenState) || (i.GetState() == constants.OpenedState)
 }
 
+// needEventsForUpdated returns whether the timeline is needed to tell when people last updated a conversation:
+// only if bot activity was the latest seen, as otherwise the item's own update time is used.
+func needEventsForUpdated(co *Conversation, fs []provider.Filter) bool {
+	if co.LastBotUpdate.IsZero() || co.LastBotUpdate.Before(co.LastHumanUpdate) {
+		return false
+	}
+
+	for _, f := range fs {
+		if f.Updated != "" {
+			return true
+		}
+	}
+	return false
+}
+
 func needTimeline(i provider.IItem, fs []provider.Filter, pr bool, hidden bool) bool {
 	if i.GetMilestone() != nil {
 		return true
@@ -353,7 +359,7 @@ func needTimeline(i provider.IItem, fs []provider.Filter, pr bool, hidden bool)
 				}
 			}
 		}
-		if f.Prioritized != "" {
+		if f.Prioritized != "" || f.LinkedPRState != "" || f.LinkedPRCount != "" || f.LinkedIssueCount != "" {
 			return true
 		}
 	}
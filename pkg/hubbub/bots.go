@@ -0,0 +1,124 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"k8s.io/klog/v2"
+)
+
+// humanEvents are timeline events which update an item, beyond its comments
+var humanEvents = map[string]bool{
+	"assigned":              true,
+	"closed":                true,
+	"committed":             true,
+	"convert_to_draft":      true,
+	"demilestoned":          true,
+	"head_ref_force_pushed": true,
+	"labeled":               true,
+	"milestoned":            true,
+	"ready_for_review":      true,
+	"renamed":               true,
+	"reopened":              true,
+	"review_requested":      true,
+	"reviewed":              true,
+	"unassigned":            true,
+	"unlabeled":             true,
+}
+
+// isBot returns whether a user should be considered a bot
+func (h *Engine) isBot(u *provider.User) bool {
+	login := u.GetLogin()
+
+	if h.bots[strings.ToLower(login)] {
+		klog.V(3).Infof("%s is a configured bot", login)
+		return true
+	}
+
+	for _, re := range h.botPatterns {
+		if re.MatchString(login) {
+			klog.V(3).Infof("%s matches bot pattern %s", login, re)
+			return true
+		}
+	}
+
+	if u.GetType() == "bot" || u.GetType() == "Bot" {
+		klog.V(3).Infof("%s type=bot", login)
+		return true
+	}
+
+	if strings.Contains(u.GetBio(), "stale issues") {
+		klog.V(3).Infof("%s bio=stale", login)
+		return true
+	}
+
+	if strings.HasSuffix(login, "[bot]") {
+		return true
+	}
+
+	if strings.HasSuffix(login, "-bot") || strings.HasSuffix(login, "-robot") || strings.HasSuffix(login, "_bot") || strings.HasSuffix(login, "_robot") {
+		return true
+	}
+
+	return false
+}
+
+// addActivity records an update to a conversation made by u at t, such as a push, label or review
+func (h *Engine) addActivity(co *Conversation, u *provider.User, t time.Time) {
+	if u == nil || t.IsZero() {
+		return
+	}
+
+	if h.isBot(u) {
+		if t.After(co.LastBotUpdate) {
+			co.LastBotUpdate = t
+		}
+		return
+	}
+
+	if t.After(co.LastHumanUpdate) {
+		co.LastHumanUpdate = t
+	}
+}
+
+// discountBots keeps bot activity from making a conversation appear to be recently updated
+func (h *Engine) discountBots(co *Conversation) {
+	// Human activity may be newer than what the item reports, if a previous call discounted a bot
+	if co.LastHumanUpdate.After(co.Updated) {
+		co.Updated = co.LastHumanUpdate
+	}
+
+	if co.LastBotUpdate.IsZero() || co.LastHumanUpdate.After(co.LastBotUpdate) {
+		return
+	}
+
+	// Something other than a bot updated the item afterwards
+	if co.LastBotUpdate.Add(time.Minute).Before(co.Updated) {
+		return
+	}
+
+	// Unseen comments may be from people
+	if co.CommentsSeen < co.CommentsTotal {
+		return
+	}
+
+	if co.Updated.After(co.LastHumanUpdate) {
+		klog.V(1).Infof("#%d: last update at %s was by a bot, using %s", co.ID, co.Updated, co.LastHumanUpdate)
+		co.Updated = co.LastHumanUpdate
+	}
+}
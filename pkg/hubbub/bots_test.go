@@ -0,0 +1,218 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBot(t *testing.T) {
+	h := New(Config{
+		Bots:        []string{"Greeter"},
+		BotPatterns: []*regexp.Regexp{regexp.MustCompile(`^ci-`)},
+	})
+
+	tests := []struct {
+		login string
+		typ   string
+		bio   string
+		want  bool
+	}{
+		{login: "person"},
+		{login: "greeter", want: true},
+		{login: "ci-runner", want: true},
+		{login: "app", typ: "Bot", want: true},
+		{login: "closer", bio: "I close stale issues", want: true},
+		{login: "dependabot[bot]", want: true},
+		{login: "k8s-ci-robot", want: true},
+		{login: "my_bot", want: true},
+		{login: "robotics"},
+		{login: "abbot"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.login, func(t *testing.T) {
+			u := &provider.User{Login: strPtr(tc.login), Type: strPtr(tc.typ), Bio: strPtr(tc.bio)}
+			assert.Equal(t, tc.want, h.isBot(u))
+		})
+	}
+}
+
+func testIssue(created time.Time, updated time.Time, comments int) *provider.Issue {
+	num := 1
+	url := "https://github.com/org/project/issues/1"
+	return &provider.Issue{
+		Number:    &num,
+		HTMLURL:   &url,
+		State:     strPtr("open"),
+		User:      &provider.User{Login: strPtr("author")},
+		CreatedAt: &created,
+		UpdatedAt: &updated,
+		Comments:  &comments,
+	}
+}
+
+func testComment(login string, t time.Time) *provider.Comment {
+	return &provider.Comment{User: &provider.User{Login: strPtr(login)}, Created: t, Updated: t}
+}
+
+func testEvent(event string, login string, t time.Time) *provider.Timeline {
+	return &provider.Timeline{Event: strPtr(event), Actor: &provider.User{Login: strPtr(login)}, CreatedAt: &t}
+}
+
+func TestUpdatedIgnoresBots(t *testing.T) {
+	now := time.Now()
+	created := now.Add(-30 * 24 * time.Hour)
+	human := now.Add(-10 * 24 * time.Hour)
+	event := now.Add(-5 * 24 * time.Hour)
+	bot := now.Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		comments []*provider.Comment
+		total    int
+		timeline []*provider.Timeline
+		updated  time.Time
+		want     time.Time
+	}{
+		{
+			name:     "human comment",
+			comments: []*provider.Comment{testComment("person", human)},
+			updated:  human,
+			want:     human,
+		},
+		{
+			name:     "bot comment",
+			comments: []*provider.Comment{testComment("person", human), testComment("stale-bot", bot)},
+			updated:  bot,
+			want:     human,
+		},
+		{
+			name:     "bot comment with unseen comments",
+			comments: []*provider.Comment{testComment("stale-bot", bot)},
+			total:    3,
+			updated:  bot,
+			want:     bot,
+		},
+		{
+			name:     "bot comment before a later update",
+			comments: []*provider.Comment{testComment("stale-bot", human)},
+			updated:  bot,
+			want:     bot,
+		},
+		{
+			name:     "human label then bot comment",
+			comments: []*provider.Comment{testComment("person", human), testComment("stale-bot", bot)},
+			timeline: []*provider.Timeline{testEvent("labeled", "person", event)},
+			updated:  bot,
+			want:     event,
+		},
+		{
+			name:     "bot label",
+			comments: []*provider.Comment{testComment("person", human)},
+			timeline: []*provider.Timeline{testEvent("labeled", "k8s-ci-robot", bot)},
+			updated:  bot,
+			want:     human,
+		},
+		{
+			name:     "human push after bot label",
+			timeline: []*provider.Timeline{testEvent("labeled", "k8s-ci-robot", human), testEvent("head_ref_force_pushed", "person", bot)},
+			updated:  bot,
+			want:     bot,
+		},
+		{
+			name:     "mention is not an update",
+			timeline: []*provider.Timeline{testEvent("mentioned", "person", event), testEvent("labeled", "k8s-ci-robot", bot)},
+			updated:  bot,
+			want:     created,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := New(Config{})
+			total := tc.total
+			if total == 0 {
+				total = len(tc.comments)
+			}
+
+			co := h.createConversation(testIssue(created, tc.updated, total), tc.comments, now)
+			h.addEvents(context.Background(), provider.SearchParams{}, co, tc.timeline)
+			assert.Equal(t, tc.want, co.Updated)
+		})
+	}
+}
+
+func TestUpdatedCountsReviews(t *testing.T) {
+	now := time.Now()
+	created := now.Add(-30 * 24 * time.Hour)
+	review := now.Add(-2 * 24 * time.Hour)
+	bot := now.Add(-time.Hour)
+
+	h := New(Config{})
+	pr := testPR(1, "author", created, time.Time{})
+	pr.UpdatedAt = &bot
+	pr.State = strPtr("open")
+
+	state := "APPROVED"
+	reviews := []*provider.PullRequestReview{{User: &provider.User{Login: strPtr("reviewer")}, SubmittedAt: &review, State: &state}}
+	timeline := []*provider.Timeline{testEvent("labeled", "k8s-ci-robot", bot)}
+
+	co := h.createPRSummary(context.Background(), provider.SearchParams{}, pr, nil, timeline, reviews)
+	assert.Equal(t, review, co.Updated)
+}
+
+func TestPreFetchUpdated(t *testing.T) {
+	now := time.Now()
+	// A bot touched this item recently, but nobody else has for a month
+	i := testIssue(now.Add(-60*24*time.Hour), now.Add(-time.Hour), 0)
+
+	// Only filters which require a recent update may be ruled out before bot activity is known
+	assert.True(t, preFetchMatch(i, nil, []provider.Filter{{Updated: "+30d"}}))
+	assert.True(t, preFetchMatch(i, nil, []provider.Filter{{Updated: "-7d"}}))
+	assert.False(t, preFetchMatch(i, nil, []provider.Filter{{Updated: "-30m"}}))
+}
+
+func TestHiddenUpdatedTimeline(t *testing.T) {
+	now := time.Now()
+	f := &fakeLister{}
+	h := newSyncEngine(t, f)
+
+	sp := provider.SearchParams{
+		Repo:      provider.Repo{Organization: "org", Project: "project"},
+		Hidden:    true,
+		Filters:   []provider.Filter{{Updated: "+1d"}},
+		NewerThan: now,
+	}
+
+	// The item's update time is used, unless a bot was the last to comment
+	i := testIssue(now.Add(-30*24*time.Hour), now.Add(-2*24*time.Hour), 0)
+	assert.NotNil(t, h.analyzeIssue(context.Background(), i, sp, now, now))
+	assert.Equal(t, 0, f.timelines)
+
+	bot := now.Add(-2 * 24 * time.Hour)
+	f.comments = []*provider.IssueComment{{User: &provider.User{Login: strPtr("stale-bot")}, Body: strPtr("stale"), CreatedAt: &bot, UpdatedAt: &bot}}
+	i = testIssue(now.Add(-30*24*time.Hour), bot, 1)
+	num := 2
+	i.Number = &num
+	h.analyzeIssue(context.Background(), i, sp, now, now)
+	assert.Equal(t, 1, f.timelines)
+}
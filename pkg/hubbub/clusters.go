@@ -0,0 +1,259 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"k8s.io/klog/v2"
+)
+
+// Cluster is a group of conversations which are transitively similar to one another
+type Cluster struct {
+	ID string `json:"id"`
+
+	// Canonical is the most reacted-to item, or the oldest on a tie
+	Canonical *RelatedConversation `json:"canonical"`
+
+	Items          []*RelatedConversation `json:"items"`
+	ReactionsTotal int                    `json:"reactions_total"`
+}
+
+// clusterIndex groups similar conversation URL's. It is rebuilt from the similarity tables whenever they change,
+// so that clusters shrink as items diverge, and do not depend on the order in which items were analyzed.
+type clusterIndex struct {
+	mu    sync.Mutex
+	stale bool
+
+	// members maps each clustered URL to every URL within its cluster
+	members map[string][]string
+}
+
+func newClusterIndex() *clusterIndex {
+	return &clusterIndex{stale: true, members: map[string][]string{}}
+}
+
+// invalidate marks the clusters for rebuilding, as the similarity tables have changed
+func (c *clusterIndex) invalidate() {
+	c.mu.Lock()
+	c.stale = true
+	c.mu.Unlock()
+}
+
+// kindFromURL returns whether a URL is for an issue or a pull request
+func kindFromURL(url string) string {
+	if strings.Contains(url, "/pull/") || strings.Contains(url, "/merge_requests/") {
+		return PullRequest
+	}
+	return Issue
+}
+
+// similarPairs calls fn for each pair of similar URL's within the similarity tables in use
+func (h *Engine) similarPairs(fn func(a string, b string)) {
+	link := func(a string, b string) {
+		if a == b || kindFromURL(a) != kindFromURL(b) {
+			return
+		}
+		if h.similarityScope == RepoScope && repoFromURL(a) != repoFromURL(b) {
+			return
+		}
+		fn(a, b)
+	}
+
+	if h.similarityMethod == TFIDFSimilarity {
+		for _, url := range h.similarityIndex.urls() {
+			for _, su := range h.similarityIndex.similar(url, h.similarityScope, h.MinSimilarity) {
+				link(url, su.URL)
+			}
+		}
+		return
+	}
+
+	h.titleToURLs.Range(func(k, v interface{}) bool {
+		urls := v.([]string)
+		if len(urls) == 0 {
+			return true
+		}
+
+		// Items with the same normalized title are duplicates of one another
+		for _, u := range urls[1:] {
+			link(urls[0], u)
+		}
+
+		others, ok := h.similarTitles.Load(k)
+		if !ok {
+			return true
+		}
+		for _, ot := range others.([]string) {
+			ous, ok := h.titleToURLs.Load(ot)
+			if !ok {
+				continue
+			}
+			for _, u := range urls {
+				for _, o := range ous.([]string) {
+					link(u, o)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// buildClusters joins transitively similar URL's, returning the members of each cluster by URL
+func (h *Engine) buildClusters() map[string][]string {
+	parent := map[string]string{}
+	var find func(url string) string
+	find = func(url string) string {
+		p, ok := parent[url]
+		if !ok {
+			parent[url] = url
+			return url
+		}
+		if p == url {
+			return url
+		}
+		root := find(p)
+		parent[url] = root
+		return root
+	}
+
+	h.similarPairs(func(a string, b string) {
+		ra := find(a)
+		rb := find(b)
+		if ra != rb {
+			parent[rb] = ra
+		}
+	})
+
+	groups := map[string][]string{}
+	for url := range parent {
+		root := find(url)
+		groups[root] = append(groups[root], url)
+	}
+
+	members := map[string][]string{}
+	for _, urls := range groups {
+		sort.Strings(urls)
+		for _, url := range urls {
+			members[url] = urls
+		}
+	}
+	return members
+}
+
+// clusterMembers returns the members of every cluster by URL, rebuilding them if the similarity tables have changed
+func (h *Engine) clusterMembers() map[string][]string {
+	c := h.clusters
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stale {
+		start := time.Now()
+		c.members = h.buildClusters()
+		c.stale = false
+		klog.V(1).Infof("rebuilt clusters for %d similar items in %s", len(c.members), time.Since(start))
+	}
+	return c.members
+}
+
+// clusterSize returns the number of items in the cluster a URL belongs to, or 0 if it is not similar to any
+func (h *Engine) clusterSize(url string) int {
+	return len(h.clusterMembers()[url])
+}
+
+// matchClusterSize checks a conversation against cluster-size filters, which are evaluated as of now
+func (h *Engine) matchClusterSize(co *Conversation, fs []provider.Filter) bool {
+	for _, f := range fs {
+		if f.ClusterSize == "" {
+			continue
+		}
+
+		size := h.clusterSize(co.URL)
+		if ok := matchRange(float64(size), f.ClusterSize); !ok {
+			klog.V(2).Infof("#%d did not pass cluster-size matchRange: %d vs %s", co.ID, size, f.ClusterSize)
+			return false
+		}
+	}
+	return true
+}
+
+// clusterID returns a stable identifier for a cluster, based on its oldest member
+func clusterID(oldest *RelatedConversation) string {
+	return fmt.Sprintf("%s-%s-%d", oldest.Organization, oldest.Project, oldest.ID)
+}
+
+// Clusters returns groups of similar conversations, highest total demand first
+func (h *Engine) Clusters() []*Cluster {
+	cls := []*Cluster{}
+
+	seen := map[string]bool{}
+	for url, urls := range h.clusterMembers() {
+		if seen[url] {
+			continue
+		}
+		for _, u := range urls {
+			seen[u] = true
+		}
+
+		cl := &Cluster{}
+		for _, url := range urls {
+			co := h.cachedConversation(url)
+			if co == nil {
+				continue
+			}
+			rel := makeRelated(co)
+			cl.Items = append(cl.Items, rel)
+			cl.ReactionsTotal += co.ReactionsTotal
+		}
+
+		if len(cl.Items) < 2 {
+			continue
+		}
+
+		sort.Slice(cl.Items, func(i, j int) bool {
+			if cl.Items[i].Created.Equal(cl.Items[j].Created) {
+				return cl.Items[i].URL < cl.Items[j].URL
+			}
+			return cl.Items[i].Created.Before(cl.Items[j].Created)
+		})
+
+		cl.ID = clusterID(cl.Items[0])
+		cl.Canonical = cl.Items[0]
+		for _, rel := range cl.Items[1:] {
+			if rel.ReactionsTotal > cl.Canonical.ReactionsTotal {
+				cl.Canonical = rel
+			}
+		}
+
+		cls = append(cls, cl)
+	}
+
+	sort.Slice(cls, func(i, j int) bool {
+		if cls[i].ReactionsTotal != cls[j].ReactionsTotal {
+			return cls[i].ReactionsTotal > cls[j].ReactionsTotal
+		}
+		if len(cls[i].Items) != len(cls[j].Items) {
+			return len(cls[i].Items) > len(cls[j].Items)
+		}
+		return cls[i].ID < cls[j].ID
+	})
+
+	return cls
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusters(t *testing.T) {
+	h := New(Config{MinSimilarity: 0.7})
+	now := time.Now()
+
+	cos := []*Conversation{
+		{ID: 1, Organization: "org", Project: "a", URL: "https://github.com/org/a/issues/1", Title: "minikube start fails with docker driver", Created: now.Add(-3 * time.Hour), ReactionsTotal: 1},
+		{ID: 2, Organization: "org", Project: "a", URL: "https://github.com/org/a/issues/2", Title: "minikube start fails with podman driver", Created: now.Add(-2 * time.Hour), ReactionsTotal: 10},
+		{ID: 3, Organization: "org", Project: "a", URL: "https://github.com/org/a/issues/3", Title: "minikube start fails with podman driver on mac", Created: now.Add(-1 * time.Hour), ReactionsTotal: 2},
+		{ID: 4, Organization: "org", Project: "b", URL: "https://github.com/org/b/issues/4", Title: "documentation typo on install page", Created: now, ReactionsTotal: 20},
+		{ID: 5, Organization: "org", Project: "b", URL: "https://github.com/org/b/issues/5", Title: "documentation typo on install page", Created: now, ReactionsTotal: 20},
+		{ID: 6, Organization: "org", Project: "b", URL: "https://github.com/org/b/pull/6", Title: "documentation typo on install page", Created: now, ReactionsTotal: 5},
+	}
+	for _, co := range cos {
+		h.updateConversationCache(co.URL, co)
+		h.updateSimilarityTables(co.Title, co.URL)
+	}
+
+	// 1 ~ 2 and 2 ~ 3 puts 1, 2 and 3 in the same cluster, and PR's are not clustered with issues
+	assert.Equal(t, 3, h.clusterSize(cos[0].URL))
+	assert.Equal(t, 2, h.clusterSize(cos[3].URL))
+	assert.Equal(t, 0, h.clusterSize(cos[5].URL))
+
+	assert.True(t, h.matchClusterSize(cos[0], []provider.Filter{{ClusterSize: ">2"}}))
+	assert.False(t, h.matchClusterSize(cos[3], []provider.Filter{{ClusterSize: ">2"}}))
+
+	cls := h.Clusters()
+	assert.Len(t, cls, 2)
+
+	// Ordered by total demand
+	assert.Equal(t, 40, cls[0].ReactionsTotal)
+	assert.Equal(t, 13, cls[1].ReactionsTotal)
+
+	// Canonical is the most reacted to, falling back to the oldest
+	assert.Equal(t, 2, cls[1].Canonical.ID)
+	assert.Equal(t, "org-a-1", cls[1].ID)
+	assert.Len(t, cls[1].Items, 3)
+	assert.Equal(t, 4, cls[0].Canonical.ID)
+
+	// Clusters shrink when an item is renamed away from the others
+	h.updateSimilarityTables("kernel panic when mounting a volume", cos[1].URL)
+	assert.Equal(t, 0, h.clusterSize(cos[1].URL))
+	assert.Equal(t, 2, h.clusterSize(cos[0].URL))
+}
+
+func TestClustersIgnoreOrder(t *testing.T) {
+	titles := map[string]string{
+		"https://github.com/org/a/issues/1": "minikube start fails with docker driver",
+		"https://github.com/org/a/issues/2": "minikube start fails with podman driver",
+		"https://github.com/org/a/issues/3": "minikube start fails with podman driver on mac",
+		"https://github.com/org/a/issues/4": "documentation typo on install page",
+	}
+	order := []string{
+		"https://github.com/org/a/issues/1",
+		"https://github.com/org/a/issues/2",
+		"https://github.com/org/a/issues/3",
+		"https://github.com/org/a/issues/4",
+	}
+
+	forward := New(Config{MinSimilarity: 0.7})
+	for _, url := range order {
+		forward.updateSimilarityTables(titles[url], url)
+	}
+
+	backward := New(Config{MinSimilarity: 0.7})
+	for i := len(order) - 1; i >= 0; i-- {
+		backward.updateSimilarityTables(titles[order[i]], order[i])
+	}
+
+	assert.Equal(t, forward.clusterMembers(), backward.clusterMembers())
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/google/triage-party/pkg/tag"
+	"k8s.io/klog/v2"
+)
+
+// contribution is a single issue or PR authored by someone
+type contribution struct {
+	Created     time.Time
+	PullRequest bool
+	Merged      time.Time
+}
+
+// contributorHistory is what we've seen an author do within a single repository
+type contributorHistory struct {
+	mu    sync.Mutex
+	items map[int]*contribution
+	// version is incremented whenever the history changes
+	version int
+}
+
+// contributorKey returns the key used for the contributor history of an author within a repository
+func contributorKey(org string, project string, login string) string {
+	if org == "" || project == "" || login == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%s", org, project, login)
+}
+
+// repoProject returns the project of a repository as it appears in conversations
+func repoProject(r provider.Repo) string {
+	if r.Group != "" {
+		return r.Group + "/" + r.Project
+	}
+	return r.Project
+}
+
+// updateContributorsFromIssues records issue authorship, meant for background use
+func (h *Engine) updateContributorsFromIssues(r provider.Repo, is []*provider.Issue) {
+	for _, i := range is {
+		h.recordContribution(r, i, i.IsPullRequest(), time.Time{})
+	}
+}
+
+// updateContributorsFromPullRequests records PR authorship, meant for background use
+func (h *Engine) updateContributorsFromPullRequests(r provider.Repo, prs []*provider.PullRequest) {
+	for _, pr := range prs {
+		h.recordContribution(r, pr, true, pr.GetMergedAt())
+	}
+}
+
+func (h *Engine) recordContribution(r provider.Repo, i provider.IItem, pr bool, merged time.Time) {
+	key := contributorKey(r.Organization, repoProject(r), i.GetUser().GetLogin())
+	if key == "" {
+		return
+	}
+
+	x, _ := h.contributors.LoadOrStore(key, &contributorHistory{items: map[int]*contribution{}})
+	ch := x.(*contributorHistory)
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	old := contribution{}
+	c := ch.items[i.GetNumber()]
+	if c == nil {
+		c = &contribution{}
+		ch.items[i.GetNumber()] = c
+	} else {
+		old = *c
+	}
+
+	c.Created = i.GetCreatedAt()
+	if pr {
+		c.PullRequest = true
+	}
+	// Issue listings don't know about merges, so never overwrite a known merge time
+	if !merged.IsZero() {
+		c.Merged = merged
+	}
+
+	if *c != old {
+		ch.version++
+	}
+}
+
+// contributorHistory returns the contributor history of a conversations author, if any
+func (h *Engine) contributorHistory(co *Conversation) *contributorHistory {
+	x, ok := h.contributors.Load(contributorKey(co.Organization, co.Project, co.Author.GetLogin()))
+	if !ok {
+		return nil
+	}
+	return x.(*contributorHistory)
+}
+
+// contributionsChanged returns whether the authors contributor history changed since a conversation was tagged
+func (h *Engine) contributionsChanged(co *Conversation) bool {
+	ch := h.contributorHistory(co)
+	if ch == nil {
+		return false
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.version != co.ContributionsSeen
+}
+
+// priorContributions returns the contributions an author made before a given item
+func (h *Engine) priorContributions(co *Conversation) []contribution {
+	ch := h.contributorHistory(co)
+	if ch == nil {
+		return nil
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	co.ContributionsSeen = ch.version
+
+	prior := []contribution{}
+	for num, c := range ch.items {
+		if num == co.ID {
+			continue
+		}
+		if c.Created.Before(co.Created) {
+			prior = append(prior, *c)
+		}
+	}
+	return prior
+}
+
+// addContributorTags tags conversations based on the authors history within the repository
+func (h *Engine) addContributorTags(co *Conversation) {
+	prior := h.priorContributions(co)
+
+	for _, c := range prior {
+		if !c.Merged.IsZero() && c.Merged.Before(co.Created) {
+			co.AuthorMergedPullRequests++
+		}
+	}
+
+	// Project members are never new around here
+	if co.SelfInflicted {
+		return
+	}
+
+	if len(prior) == 0 {
+		klog.V(1).Infof("#%d: %s appears to be a first-time contributor", co.ID, co.Author.GetLogin())
+		co.Tags[tag.FirstTimeContributor] = true
+	} else {
+		co.Tags[tag.ReturningContributor] = true
+	}
+
+	if co.Type != PullRequest {
+		return
+	}
+
+	for _, c := range prior {
+		if c.PullRequest {
+			return
+		}
+	}
+	co.Tags[tag.FirstTimePR] = true
+}
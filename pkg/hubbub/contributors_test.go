@@ -0,0 +1,115 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/google/triage-party/pkg/tag"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPR(num int, login string, created time.Time, merged time.Time) *provider.PullRequest {
+	url := fmt.Sprintf("https://github.com/org/project/pull/%d", num)
+	pr := &provider.PullRequest{
+		Number:    &num,
+		HTMLURL:   &url,
+		CreatedAt: &created,
+		User:      &provider.User{Login: &login},
+	}
+	if !merged.IsZero() {
+		pr.MergedAt = &merged
+	}
+	return pr
+}
+
+func TestAddContributorTags(t *testing.T) {
+	h := New(Config{})
+	now := time.Now()
+
+	h.updateContributorsFromPullRequests(provider.Repo{Organization: "org", Project: "project"}, []*provider.PullRequest{
+		testPR(1, "old-timer", now.Add(-72*time.Hour), now.Add(-48*time.Hour)),
+		testPR(2, "old-timer", now, time.Time{}),
+		testPR(3, "newbie", now, time.Time{}),
+	})
+
+	co := &Conversation{ID: 2, Organization: "org", Project: "project", URL: "https://github.com/org/project/pull/2", Type: PullRequest, Created: now, Author: &provider.User{Login: strPtr("old-timer")}, Tags: map[tag.Tag]bool{}}
+	h.addContributorTags(co)
+	assert.True(t, co.Tags[tag.ReturningContributor])
+	assert.False(t, co.Tags[tag.FirstTimePR])
+	assert.Equal(t, 1, co.AuthorMergedPullRequests)
+
+	co = &Conversation{ID: 3, Organization: "org", Project: "project", URL: "https://github.com/org/project/pull/3", Type: PullRequest, Created: now, Author: &provider.User{Login: strPtr("newbie")}, Tags: map[tag.Tag]bool{}}
+	h.addContributorTags(co)
+	assert.True(t, co.Tags[tag.FirstTimeContributor])
+	assert.True(t, co.Tags[tag.FirstTimePR])
+	assert.Equal(t, 0, co.AuthorMergedPullRequests)
+
+	co = &Conversation{ID: 3, Organization: "org", Project: "project", URL: "https://github.com/org/project/pull/3", Type: PullRequest, Created: now, Author: &provider.User{Login: strPtr("newbie")}, SelfInflicted: true, Tags: map[tag.Tag]bool{}}
+	h.addContributorTags(co)
+	assert.False(t, co.Tags[tag.FirstTimeContributor])
+}
+
+func TestContributorTagsRefreshed(t *testing.T) {
+	h := New(Config{})
+	now := time.Now()
+	r := provider.Repo{Organization: "org", Project: "project"}
+
+	// The issue listing is processed before the PR listing has been seen
+	i := testIssue(now.Add(-time.Hour), now.Add(-time.Hour), 0)
+	h.updateContributorsFromIssues(r, []*provider.Issue{i})
+	co := h.IssueSummary(i, nil, now)
+	assert.True(t, co.Tags[tag.FirstTimeContributor])
+
+	h.updateContributorsFromPullRequests(r, []*provider.PullRequest{testPR(2, "author", now.Add(-72*time.Hour), now.Add(-48*time.Hour))})
+	co = h.IssueSummary(i, nil, now)
+	assert.False(t, co.Tags[tag.FirstTimeContributor])
+	assert.True(t, co.Tags[tag.ReturningContributor])
+	assert.Equal(t, 1, co.AuthorMergedPullRequests)
+
+	// Listing the same items again does not invalidate the cache
+	h.updateContributorsFromIssues(r, []*provider.Issue{i})
+	assert.Same(t, co, h.IssueSummary(i, nil, now))
+}
+
+func TestContributorSubgroups(t *testing.T) {
+	h := New(Config{})
+	now := time.Now()
+
+	newbie := func(url string) *provider.Issue {
+		i := testIssue(now, now, 0)
+		i.HTMLURL = &url
+		return i
+	}
+
+	// Projects within different subgroups of the same group have their own history
+	h.updateContributorsFromPullRequests(provider.Repo{Organization: "group", Group: "a", Project: "project"}, []*provider.PullRequest{testPR(2, "author", now.Add(-72*time.Hour), now.Add(-48*time.Hour))})
+	h.updateContributorsFromIssues(provider.Repo{Organization: "group", Group: "b", Project: "project"}, []*provider.Issue{newbie("https://gitlab.com/group/b/project/-/issues/1")})
+
+	co := h.createIssueSummary(newbie("https://gitlab.com/group/b/project/-/issues/1"), nil, now)
+	assert.Equal(t, "group", co.Organization)
+	assert.Equal(t, "b/project", co.Project)
+	assert.True(t, co.Tags[tag.FirstTimeContributor])
+
+	co = h.createIssueSummary(newbie("https://gitlab.com/group/a/project/-/issues/1"), nil, now)
+	assert.True(t, co.Tags[tag.ReturningContributor])
+}
+
+func strPtr(s string) *string {
+	return &s
+}
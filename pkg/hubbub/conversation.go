@@ -15,6 +15,8 @@
 package hubbub
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/triage-party/pkg/provider"
@@ -48,12 +50,17 @@ type Conversation struct {
 	// Seen is the age of the data which generated this data
 	Seen         time.Time `json:"seen"`
 	CommentsSeen int       `json:"comments_seen"`
+	// ContributionsSeen is the version of the authors contributor history used for tagging
+	ContributionsSeen int `json:"contributions_seen"`
 
 	// When did this item reach the current priority?
 	Prioritized time.Time `json:"prioritized"`
 
 	SelfInflicted bool `json:"self_inflicted"`
 
+	// How many of the authors PR's were merged into this repository before this item was created
+	AuthorMergedPullRequests int `json:"author_merged_pull_requests"`
+
 	ReviewState string `json:"review_state"`
 
 	LatestAuthorResponse   time.Time `json:"latest_author_response"`
@@ -70,6 +77,14 @@ type Conversation struct {
 	Reactions         map[string]int `json:"reactions"`
 	ReactionsPerMonth float64        `json:"reactions_per_month"`
 
+	AuthorIsBot      bool      `json:"author_is_bot"`
+	BotCommentsTotal int       `json:"bot_comments_total"`
+	LastBotComment   time.Time `json:"last_bot_comment"`
+
+	// Latest comment, review, push or event by a person, and by a bot
+	LastHumanUpdate time.Time `json:"last_human_update"`
+	LastBotUpdate   time.Time `json:"last_bot_update"`
+
 	Commenters         []*provider.User `json:"commenters"`
 	LastCommentBody    string           `json:"last_comment_body"`
 	LastCommentAuthor  *provider.User   `json:"last_comment_author"`
@@ -112,6 +127,38 @@ type RelatedConversation struct {
 	Updated     time.Time      `json:"updated"`
 	Seen        time.Time      `json:"seen"`
 	ReviewState string         `json:"review_state"`
+
+	ReactionsTotal int `json:"reactions_total"`
+
+	// Similarity is how similar this item is to the conversation that references it (0-1)
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+// repoFromURL returns the "org/project" part of an issue or PR URL
+func repoFromURL(url string) string {
+	org, project := splitRepoURL(url)
+	if org == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", org, project)
+}
+
+// splitRepoURL returns the organization and project of an issue or PR URL. GitLab projects
+// within subgroups are returned as "subgroup/project".
+func splitRepoURL(url string) (string, string) {
+	// "https://github.com/kubernetes/minikube/issues/7179",
+	// "https://gitlab.com/group/subgroup/project/-/issues/12",
+	parts := strings.Split(url, "/")
+	if len(parts) < 5 {
+		return "", ""
+	}
+
+	for i := 5; i < len(parts); i++ {
+		if parts[i] == "-" {
+			return parts[3], strings.Join(parts[4:i], "/")
+		}
+	}
+	return parts[3], parts[4]
 }
 
 func makeRelated(c *Conversation) *RelatedConversation {
@@ -129,5 +176,7 @@ func makeRelated(c *Conversation) *RelatedConversation {
 		Updated: c.Updated,
 		Tags:    c.Tags,
 		Seen:    c.Seen,
+
+		ReactionsTotal: c.ReactionsTotal,
 	}
 }
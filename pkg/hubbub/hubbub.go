@@ -16,7 +16,12 @@
 package hubbub
 
 import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/triage-party/pkg/constants"
@@ -33,6 +38,12 @@ type Config struct {
 	// MinSimilarity is how close two items need to be to each other to be called similar
 	MinSimilarity float64
 
+	// SimilarityMethod is how items are compared: "title" (default) or "tfidf"
+	SimilarityMethod string
+
+	// SimilarityScope is where similar items are searched for: "global" (default) or "repo"
+	SimilarityScope string
+
 	// The furthest we will query back for information on closed issues
 	MaxClosedUpdateAge time.Duration
 
@@ -46,6 +57,15 @@ type Config struct {
 	// Members are which specific users to consider as members
 	Members []string
 
+	// Bots are which specific users to consider as bots
+	Bots []string
+
+	// BotPatterns are login patterns to consider as bots
+	BotPatterns []*regexp.Regexp
+
+	// AnalyzerWorkers is the most items analyzed at once, across all searches
+	AnalyzerWorkers int
+
 	// Providers
 	GitHub provider.Provider
 	GitLab provider.Provider
@@ -65,10 +85,21 @@ type Engine struct {
 
 	titleToURLs   sync.Map
 	similarTitles sync.Map
+	urlToTitle    sync.Map
+
+	similarityMethod string
+	similarityScope  string
+	similarityIndex  *similarityIndex
+
+	// groups of transitively similar items
+	clusters *clusterIndex
 
 	memberRoles map[string]bool
 	members     map[string]bool
 
+	bots        map[string]bool
+	botPatterns []*regexp.Regexp
+
 	// Data source providers
 	github provider.Provider
 	gitlab provider.Provider
@@ -78,6 +109,22 @@ type Engine struct {
 
 	// indexes used for similarity matching & conversation caching
 	seen sync.Map
+
+	// per-repository history of each author: "org/project/login" -> *contributorHistory
+	contributors sync.Map
+
+	// search key -> lock, so that concurrent collection refreshes fetch shared repository data once
+	fetchingMu sync.Mutex
+	fetching   map[string]*searchLock
+
+	// provider host -> most recently seen provider.Rate
+	rates sync.Map
+
+	// whether to skip optional API calls to conserve quota
+	conserve atomic.Bool
+
+	// workers shared by every search to analyze items
+	analyzers *analyzerPool
 }
 
 // ConversationsTotal returns the number of conversations we've seen so far
@@ -98,6 +145,49 @@ func (e *Engine) provider(hostname string) provider.Provider {
 	return e.github
 }
 
+// searchLock serializes lookups of a search key
+type searchLock struct {
+	// held has a value while the lock is held
+	held chan struct{}
+	// refs is the number of holders and waiters, guarded by Engine.fetchingMu
+	refs int
+}
+
+// lockSearch serializes lookups of a search key, returning the function to unlock it.
+//
+// Whoever gets the lock first fetches and caches the data, which later holders then find in the cache.
+// Waiters give up if their context is cancelled, and locks are forgotten once nobody holds or awaits them.
+func (e *Engine) lockSearch(ctx context.Context, key string) (func(), error) {
+	e.fetchingMu.Lock()
+	l := e.fetching[key]
+	if l == nil {
+		l = &searchLock{held: make(chan struct{}, 1)}
+		e.fetching[key] = l
+	}
+	l.refs++
+	e.fetchingMu.Unlock()
+
+	release := func() {
+		e.fetchingMu.Lock()
+		defer e.fetchingMu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(e.fetching, key)
+		}
+	}
+
+	select {
+	case l.held <- struct{}{}:
+		return func() {
+			<-l.held
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, fmt.Errorf("waiting for %s: %w", key, ctx.Err())
+	}
+}
+
 func New(cfg Config) *Engine {
 	e := &Engine{
 		cache: cfg.Cache,
@@ -106,13 +196,24 @@ func New(cfg Config) *Engine {
 		MinSimilarity:      cfg.MinSimilarity,
 		debug:              cfg.DebugNumbers,
 
+		similarityMethod: cfg.SimilarityMethod,
+		similarityScope:  cfg.SimilarityScope,
+		similarityIndex:  newSimilarityIndex(),
+		clusters:         newClusterIndex(),
+
+		fetching:    map[string]*searchLock{},
 		memberRoles: map[string]bool{},
 		members:     map[string]bool{},
 
+		bots:        map[string]bool{},
+		botPatterns: cfg.BotPatterns,
+
 		github: cfg.GitHub,
 		gitlab: cfg.GitLab,
 	}
 
+	e.analyzers = newAnalyzerPool(cfg.AnalyzerWorkers, e.lowQuota)
+
 	klog.Infof("considering users as members: %v", cfg.Members)
 	for _, user := range cfg.Members {
 		e.members[user] = true
@@ -123,11 +224,23 @@ func New(cfg Config) *Engine {
 		e.memberRoles[role] = true
 	}
 
+	for _, user := range cfg.Bots {
+		e.bots[strings.ToLower(user)] = true
+	}
+
 	if len(e.members) == 0 && len(e.memberRoles) == 0 {
 		e.memberRoles = map[string]bool{"collaborator": true, "member": true, "owner": true}
 		klog.Warningf("No memberships defined, using default: %v", e.memberRoles)
 	}
 
+	if e.similarityMethod == "" {
+		e.similarityMethod = TitleSimilarity
+	}
+
+	if e.similarityScope == "" {
+		e.similarityScope = GlobalScope
+	}
+
 	// This value is typically programmed on the fly, but lets give it a good enough default
 	if e.MaxClosedUpdateAge == 0 {
 		e.MaxClosedUpdateAge = 24 * 3 * time.Hour
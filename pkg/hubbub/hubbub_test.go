@@ -0,0 +1,70 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fetchingLocks(h *Engine) int {
+	h.fetchingMu.Lock()
+	defer h.fetchingMu.Unlock()
+	return len(h.fetching)
+}
+
+func TestLockSearch(t *testing.T) {
+	h := New(Config{})
+	ctx := context.Background()
+
+	unlock, err := h.lockSearch(ctx, "org-project-open-issues")
+	require.NoError(t, err)
+
+	// Other keys are not blocked
+	other, err := h.lockSearch(ctx, "org-project-open-prs")
+	require.NoError(t, err)
+	other()
+
+	// Waiters give up when their context is cancelled
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = h.lockSearch(cctx, "org-project-open-issues")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan func())
+	go func() {
+		next, err := h.lockSearch(ctx, "org-project-open-issues")
+		assert.NoError(t, err)
+		acquired <- next
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	unlock()
+	next := <-acquired
+	assert.Equal(t, 1, fetchingLocks(h))
+
+	// Locks are forgotten once nobody holds or awaits them
+	next()
+	assert.Equal(t, 0, fetchingLocks(h))
+}
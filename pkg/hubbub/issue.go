@@ -33,6 +33,11 @@ import (
 // cachedIssues returns issues, cached if possible
 func (h *Engine) cachedIssues(ctx context.Context, sp provider.SearchParams) ([]*provider.Issue, time.Time, error) {
 	sp.SearchKey = issueSearchKey(sp)
+	unlock, err := h.lockSearch(ctx, sp.SearchKey)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer unlock()
 
 	if x := h.cache.Get(sp.SearchKey, sp.NewerThan); x != nil {
 		// Normally the similarity tables are only updated when fresh data is encountered.
@@ -40,6 +45,7 @@ func (h *Engine) cachedIssues(ctx context.Context, sp provider.SearchParams) ([]
 			go h.updateSimilarIssues(sp.SearchKey, x.Issues)
 		}
 
+		h.updateContributorsFromIssues(sp.Repo, x.Issues)
 		return x.Issues, x.Created, nil
 	}
 
@@ -49,7 +55,7 @@ func (h *Engine) cachedIssues(ctx context.Context, sp provider.SearchParams) ([]
 		klog.Warningf("Retrieving stale results for %s due to error: %v", sp.SearchKey, err)
 		x := h.cache.Get(sp.SearchKey, time.Time{})
 		if x != nil {
-			return x.Issues, x.Created, nil
+			return x.Issues, x.Created, &staleError{created: x.Created, err: err}
 		}
 	}
 	return issues, created, err
@@ -58,6 +64,7 @@ func (h *Engine) cachedIssues(ctx context.Context, sp provider.SearchParams) ([]
 // updateIssues updates the issues in cache
 func (h *Engine) updateIssues(ctx context.Context, sp provider.SearchParams) ([]*provider.Issue, time.Time, error) {
 	start := time.Now()
+	base := h.syncBase(sp)
 
 	sp.IssueListByRepoOptions = provider.IssueListByRepoOptions{
 		ListOptions: provider.ListOptions{PerPage: 100},
@@ -68,10 +75,20 @@ func (h *Engine) updateIssues(ctx context.Context, sp provider.SearchParams) ([]
 		sp.IssueListByRepoOptions.Since = time.Now().Add(-1 * sp.UpdateAge)
 	}
 
+	// Only fetch what changed since the last sync, including issues which have since closed
+	if base != nil {
+		sp.IssueListByRepoOptions.State = constants.AllState
+		sp.IssueListByRepoOptions.Since = base.Cursor.Add(-1 * cursorSkew)
+	}
+
 	var allIssues []*provider.Issue
+	var updated []time.Time
 
 	for {
-		if sp.UpdateAge == 0 {
+		if base != nil {
+			klog.Infof("Syncing %s issues for %s/%s changed since %s (page %d)...",
+				sp.State, sp.Repo.Organization, sp.Repo.Project, logu.STime(sp.IssueListByRepoOptions.Since), sp.IssueListByRepoOptions.Page)
+		} else if sp.UpdateAge == 0 {
 			klog.Infof("Downloading %s issues for %s/%s (page %d)...",
 				sp.State, sp.Repo.Organization, sp.Repo.Project, sp.IssueListByRepoOptions.Page)
 		} else {
@@ -95,7 +112,7 @@ func (h *Engine) updateIssues(ctx context.Context, sp provider.SearchParams) ([]
 			return is, start, err
 		}
 
-		h.logRate(resp.Rate)
+		h.logRate(sp.Repo.Host, resp.Rate)
 
 		for _, i := range is {
 			if i.IsPullRequest() {
@@ -103,10 +120,12 @@ func (h *Engine) updateIssues(ctx context.Context, sp provider.SearchParams) ([]
 			}
 
 			h.updateMtime(i, i.GetUpdatedAt())
+			updated = append(updated, i.GetUpdatedAt())
 			allIssues = append(allIssues, i)
 		}
 
 		go h.updateSimilarIssues(sp.SearchKey, is)
+		h.updateContributorsFromIssues(sp.Repo, is)
 
 		if resp.NextPage == 0 {
 			break
@@ -114,7 +133,19 @@ func (h *Engine) updateIssues(ctx context.Context, sp provider.SearchParams) ([]
 		sp.IssueListByRepoOptions.Page = resp.NextPage
 	}
 
-	if err := h.cache.Set(sp.SearchKey, &persist.Blob{Issues: allIssues}); err != nil {
+	bl := &persist.Blob{Issues: allIssues}
+	if base != nil {
+		klog.Infof("Synced %d changed issues into %d open issues for %s", len(allIssues), len(base.Issues), sp.SearchKey)
+		bl.Issues = mergeIssues(base.Issues, allIssues)
+		bl.Cursor = cursorFor(base.Cursor, updated)
+		bl.Listed = base.Listed
+		allIssues = bl.Issues
+	} else if syncable(sp) {
+		bl.Cursor = cursorFor(time.Time{}, updated)
+		bl.Listed = start
+	}
+
+	if err := h.cache.Set(sp.SearchKey, bl); err != nil {
 		klog.Errorf("set %q failed: %v", sp.SearchKey, err)
 	}
 
@@ -165,7 +196,7 @@ func (h *Engine) updateIssueComments(ctx context.Context, sp provider.SearchPara
 		if err != nil {
 			return cs, start, err
 		}
-		h.logRate(resp.Rate)
+		h.logRate(sp.Repo.Host, resp.Rate)
 
 		allComments = append(allComments, cs...)
 		if resp.NextPage == 0 {
@@ -221,6 +252,7 @@ func (h *Engine) createIssueSummary(i *provider.Issue, cs []*provider.IssueComme
 		co.Reactions[k] += v
 	}
 	co.ClosedBy = i.GetClosedBy()
+	h.addContributorTags(co)
 
 	return co
 }
@@ -244,10 +276,13 @@ func (h *Engine) IssueSummary(i *provider.Issue, cs []*provider.IssueComment, ag
 	cached := h.cachedConversation(key)
 	if cached != nil {
 		minAge := h.mtime(i)
-		if !cached.Seen.Before(minAge) && cached.CommentsSeen >= len(cs) {
+		changed := h.contributionsChanged(cached)
+		if !cached.Seen.Before(minAge) && cached.CommentsSeen >= len(cs) && !changed {
 			return cached
 		}
-		if cached.CommentsSeen < len(cs) {
+		if changed {
+			klog.V(2).Infof("%s in issue cache, but the authors contributor history has changed", i.GetHTMLURL())
+		} else if cached.CommentsSeen < len(cs) {
 			klog.V(2).Infof("%s in issue cache, but is missing comments. Live @ %s (%d comments), cached @ %s (%d comments)  ", i.GetHTMLURL(), minAge, len(cs), cached.Seen, cached.CommentsSeen)
 		} else {
 			klog.Infof("%s in issue cache, but may be missing updated references. Live @ %s (%d comments), cached @ %s (%d comments)  ", i.GetHTMLURL(), minAge, len(cs), cached.Seen, cached.CommentsSeen)
@@ -258,21 +293,3 @@ func (h *Engine) IssueSummary(i *provider.Issue, cs []*provider.IssueComment, ag
 	h.updateConversationCache(key, co)
 	return co
 }
-
-func isBot(u *provider.User) bool {
-	if u.GetType() == "bot" {
-		klog.V(3).Infof("%s type=bot", u.GetLogin())
-		return true
-	}
-
-	if strings.Contains(u.GetBio(), "stale issues") {
-		klog.V(3).Infof("%s bio=stale", u.GetLogin())
-		return true
-	}
-
-	if strings.HasSuffix(u.GetLogin(), "-bot") || strings.HasSuffix(u.GetLogin(), "-robot") || strings.HasSuffix(u.GetLogin(), "_bot") || strings.HasSuffix(u.GetLogin(), "_robot") {
-		return true
-	}
-
-	return false
-}
@@ -66,7 +66,9 @@ func (h *Engine) createConversation(i provider.IItem, cs []*provider.Comment, ag
 		CommentsSeen:         len(cs),
 		ClosedAt:             i.GetClosedAt(),
 		SelfInflicted:        authorIsMember,
+		AuthorIsBot:          h.isBot(i.GetUser()),
 		LatestAuthorResponse: i.GetCreatedAt(),
+		LastHumanUpdate:      i.GetCreatedAt(),
 		Milestone:            i.GetMilestone(),
 		Reactions:            map[string]int{},
 		LastCommentAuthor:    i.GetUser(),
@@ -78,10 +80,7 @@ func (h *Engine) createConversation(i provider.IItem, cs []*provider.Comment, ag
 		co.CommentsTotal = len(cs)
 	}
 
-	// "https://github.com/kubernetes/minikube/issues/7179",
-	urlParts := strings.Split(i.GetHTMLURL(), "/")
-	co.Organization = urlParts[3]
-	co.Project = urlParts[4]
+	co.Organization, co.Project = splitRepoURL(i.GetHTMLURL())
 	h.parseRefs(i.GetBody(), co, i.GetUpdatedAt())
 
 	if i.GetAssignee() != nil {
@@ -108,11 +107,22 @@ func (h *Engine) createConversation(i provider.IItem, cs []*provider.Comment, ag
 			klog.Errorf("debug conversation comment: %s", formatStruct(c))
 		}
 
-		// We don't like their kind around here
-		if isBot(c.User) {
+		// We don't like their kind around here, but keep track of what they are up to
+		if h.isBot(c.User) {
+			co.BotCommentsTotal++
+			if c.Created.After(co.LastBotComment) {
+				co.LastBotComment = c.Created
+			}
+			if c.Updated.After(co.LastBotUpdate) {
+				co.LastBotUpdate = c.Updated
+			}
 			continue
 		}
 
+		if c.Updated.After(co.LastHumanUpdate) {
+			co.LastHumanUpdate = c.Updated
+		}
+
 		co.LastCommentBody = c.Body
 		co.LastCommentAuthor = c.User
 
@@ -138,7 +148,7 @@ func (h *Engine) createConversation(i provider.IItem, cs []*provider.Comment, ag
 			co.LatestAssigneeResponse = c.Created
 		}
 
-		if h.isMember(c.User.GetLogin(), c.AuthorAssoc) && !isBot(c.User) {
+		if h.isMember(c.User.GetLogin(), c.AuthorAssoc) {
 			if !co.LatestMemberResponse.After(co.LatestAuthorResponse) && !authorIsMember {
 				co.AccumulatedHoldTime += c.Created.Sub(co.LatestAuthorResponse)
 			}
@@ -207,6 +217,8 @@ func (h *Engine) createConversation(i provider.IItem, cs []*provider.Comment, ag
 		}
 	}
 
+	h.discountBots(co)
+
 	if co.State == constants.ClosedState {
 		co.Tags[tag.Closed] = true
 	}
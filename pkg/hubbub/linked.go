@@ -0,0 +1,77 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"strings"
+
+	"github.com/google/triage-party/pkg/constants"
+	"github.com/google/triage-party/pkg/tag"
+	"k8s.io/klog/v2"
+)
+
+// linkedPRState returns the state of a linked PR: open, closed, or merged
+func linkedPRState(rc *RelatedConversation) string {
+	if rc.ReviewState == Merged {
+		return "merged"
+	}
+	if rc.ReviewState == Closed {
+		return constants.ClosedState
+	}
+	if rc.State == constants.OpenedState {
+		return constants.OpenState
+	}
+	return rc.State
+}
+
+// addLinkedTags adds tags which depend on the state of linked PR's
+func addLinkedTags(co *Conversation) {
+	if co.Type != Issue || co.State == constants.ClosedState {
+		return
+	}
+
+	for _, pr := range co.PullRequestRefs {
+		if pr == nil {
+			continue
+		}
+		if linkedPRState(pr) == "merged" {
+			klog.V(1).Infof("#%d is open, but the fix in %s was merged", co.ID, pr.URL)
+			co.Tags[tag.FixMergedIssueOpen] = true
+			return
+		}
+	}
+
+	delete(co.Tags, tag.FixMergedIssueOpen)
+}
+
+// matchLinkedPRState matches if any linked PR is in a state, or if none are when negated with "!"
+func matchLinkedPRState(refs []*RelatedConversation, state string) bool {
+	negate := false
+	if strings.HasPrefix(state, "!") {
+		state = state[1:]
+		negate = true
+	}
+
+	for _, pr := range refs {
+		if pr == nil {
+			continue
+		}
+		if linkedPRState(pr) == strings.ToLower(state) {
+			return !negate
+		}
+	}
+	// Returns 'false' normally, 'true' when negate is true
+	return negate
+}
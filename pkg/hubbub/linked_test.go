@@ -0,0 +1,64 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/google/triage-party/pkg/tag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddLinkedTags(t *testing.T) {
+	merged := &RelatedConversation{URL: "https://github.com/org/project/pull/2", State: "closed", ReviewState: Merged}
+	open := &RelatedConversation{URL: "https://github.com/org/project/pull/3", State: "open", ReviewState: Approved}
+
+	co := &Conversation{ID: 1, Type: Issue, State: "open", Tags: map[tag.Tag]bool{}, PullRequestRefs: []*RelatedConversation{open, merged}}
+	addLinkedTags(co)
+	assert.True(t, co.Tags[tag.FixMergedIssueOpen])
+
+	co = &Conversation{ID: 1, Type: Issue, State: "closed", Tags: map[tag.Tag]bool{}, PullRequestRefs: []*RelatedConversation{merged}}
+	addLinkedTags(co)
+	assert.False(t, co.Tags[tag.FixMergedIssueOpen])
+
+	co = &Conversation{ID: 1, Type: Issue, State: "open", Tags: map[tag.Tag]bool{}, PullRequestRefs: []*RelatedConversation{open}}
+	addLinkedTags(co)
+	assert.False(t, co.Tags[tag.FixMergedIssueOpen])
+}
+
+func TestMatchLinkedPRState(t *testing.T) {
+	refs := []*RelatedConversation{
+		{State: "closed", ReviewState: Merged},
+		{State: "open", ReviewState: Unreviewed},
+	}
+
+	assert.True(t, matchLinkedPRState(refs, "merged"))
+	assert.True(t, matchLinkedPRState(refs, "open"))
+	assert.False(t, matchLinkedPRState(refs, "closed"))
+	assert.False(t, matchLinkedPRState(refs, "!merged"))
+	assert.True(t, matchLinkedPRState(refs, "!closed"))
+	assert.True(t, matchLinkedPRState(nil, "!merged"))
+}
+
+func TestNeedTimelineForLinked(t *testing.T) {
+	now := time.Now()
+	i := testIssue(now.Add(-time.Hour), now, 0)
+
+	assert.False(t, needTimeline(i, nil, false, true))
+	assert.True(t, needTimeline(i, []provider.Filter{{LinkedPRCount: ">0"}}, false, true))
+	assert.True(t, needTimeline(i, []provider.Filter{{LinkedIssueCount: ">0"}}, false, true))
+}
@@ -25,7 +25,8 @@ import (
 	"k8s.io/klog/v2"
 )
 
-func (h *Engine) logRate(r provider.Rate) {
+func (h *Engine) logRate(host string, r provider.Rate) {
+	h.rates.Store(host, r)
 	msg := fmt.Sprintf("GitHub API hourly quota remaining: %d of %d, resets at %s", r.Remaining, r.Limit, r.Reset)
 
 	if r.Remaining < 25 {
@@ -50,10 +50,13 @@ func preFetchMatch(i provider.IItem, labels []*provider.Label, fs []provider.Fil
 			}
 		}
 
+		// Bot activity counts towards updated_at, so it can only rule out items which must have been updated recently
 		if f.Updated != "" {
-			if ok := matchDuration(i.GetUpdatedAt(), f.Updated); !ok {
-				klog.V(2).Infof("#%d update at %s does not meet %s", i.GetNumber(), i.GetUpdatedAt(), f.Updated)
-				return false
+			if _, within, _ := ParseDuration(f.Updated); within {
+				if ok := matchDuration(i.GetUpdatedAt(), f.Updated); !ok {
+					klog.V(2).Infof("#%d update at %s does not meet %s", i.GetNumber(), i.GetUpdatedAt(), f.Updated)
+					return false
+				}
 			}
 		}
 
@@ -126,6 +129,13 @@ func postFetchMatch(co *Conversation, fs []provider.Filter) bool {
 				return false
 			}
 		}
+		if f.AuthorIsBot != "" {
+			if ok := matchBool(co.AuthorIsBot, f.AuthorIsBot); !ok {
+				klog.V(2).Infof("#%d did not pass author-is-bot: %v vs %s", co.ID, co.AuthorIsBot, f.AuthorIsBot)
+				return false
+			}
+		}
+
 		if f.Reactions != "" {
 			if ok := matchRange(float64(co.ReactionsTotal), f.Reactions); !ok {
 				klog.V(2).Infof("#%d did not pass reactions matchRange: %d vs %s", co.ID, co.ReactionsTotal, f.Reactions)
@@ -173,6 +183,13 @@ func postFetchMatch(co *Conversation, fs []provider.Filter) bool {
 			}
 		}
 
+		if f.AuthorMergedPRs != "" {
+			if ok := matchRange(float64(co.AuthorMergedPullRequests), f.AuthorMergedPRs); !ok {
+				klog.V(2).Infof("#%d did not pass author-merged-prs matchRange: %d vs %s", co.ID, co.AuthorMergedPullRequests, f.AuthorMergedPRs)
+				return false
+			}
+		}
+
 	}
 	return true
 }
@@ -187,12 +204,41 @@ func postEventsMatch(co *Conversation, fs []provider.Filter) bool {
 			}
 		}
 
+		// Bot activity is not considered to be an update, which is only known once events have been seen
+		if f.Updated != "" {
+			if ok := matchDuration(co.Updated, f.Updated); !ok {
+				klog.V(2).Infof("#%d did not pass updated matchDuration: %s vs %s", co.ID, co.Updated, f.Updated)
+				return false
+			}
+		}
+
 		if f.Prioritized != "" {
 			if ok := matchDuration(co.Prioritized, f.Prioritized); !ok {
 				klog.V(4).Infof("#%d did not pass prioritized duration: %s vs %s", co.ID, co.LatestMemberResponse, f.Prioritized)
 				return false
 			}
 		}
+
+		if f.LinkedPRState != "" {
+			if ok := matchLinkedPRState(co.PullRequestRefs, f.LinkedPRState); !ok {
+				klog.V(4).Infof("#%d did not pass linked-pr-state: %s", co.ID, f.LinkedPRState)
+				return false
+			}
+		}
+
+		if f.LinkedPRCount != "" {
+			if ok := matchRange(float64(len(co.PullRequestRefs)), f.LinkedPRCount); !ok {
+				klog.V(4).Infof("#%d did not pass linked-pr-count matchRange: %d vs %s", co.ID, len(co.PullRequestRefs), f.LinkedPRCount)
+				return false
+			}
+		}
+
+		if f.LinkedIssueCount != "" {
+			if ok := matchRange(float64(len(co.IssueRefs)), f.LinkedIssueCount); !ok {
+				klog.V(4).Infof("#%d did not pass linked-issue-count matchRange: %d vs %s", co.ID, len(co.IssueRefs), f.LinkedIssueCount)
+				return false
+			}
+		}
 	}
 	return true
 }
@@ -289,6 +335,15 @@ func matchDuration(t time.Time, ds string) bool {
 	return false
 }
 
+func matchBool(b bool, s string) bool {
+	want, err := strconv.ParseBool(s)
+	if err != nil {
+		klog.Errorf("unable to parse bool: %s", s)
+		return false
+	}
+	return b == want
+}
+
 func matchRange(i float64, r string) bool {
 	matches := rangeRegexp.FindStringSubmatch(r)
 	if len(matches) != 3 {
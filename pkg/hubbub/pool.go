@@ -0,0 +1,315 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultAnalyzerWorkers is the default maximum number of items analyzed at once, across all searches
+const DefaultAnalyzerWorkers = 16
+
+const (
+	// minAnalyzerWorkers is how many analyzer workers are kept once started
+	minAnalyzerWorkers = 2
+
+	// initialAnalyzerWorkers is the pool size before any latency has been observed
+	initialAnalyzerWorkers = 6
+
+	// slowJob is the average analysis latency above which items are assumed to be waiting on the network,
+	// so more workers help. Below fastJob, items are cache hits and extra workers only add contention.
+	slowJob = 100 * time.Millisecond
+	fastJob = 10 * time.Millisecond
+
+	// throughputWindow is how long completions are counted for before the throughput is updated
+	throughputWindow = time.Minute
+
+	// latencyWeight is how much each completed job moves the average latency
+	latencyWeight = 0.2
+)
+
+type onDemandKey struct{}
+
+// OnDemand returns a context for a refresh which someone is waiting on, such as a Shift-Reload.
+// Its items are analyzed ahead of those queued by background refreshes.
+func OnDemand(ctx context.Context) context.Context {
+	return context.WithValue(ctx, onDemandKey{}, true)
+}
+
+// onDemand returns whether someone is waiting on a context's refresh
+func onDemand(ctx context.Context) bool {
+	v, _ := ctx.Value(onDemandKey{}).(bool)
+	return v
+}
+
+// AnalyzerMetrics is a snapshot of the shared analyzer pool
+type AnalyzerMetrics struct {
+	Since time.Time
+
+	// Workers is how many workers are running, and Target how many the pool is converging on
+	Workers    int
+	Target     int
+	MinWorkers int
+	MaxWorkers int
+
+	// Queued is how many items are waiting for a worker, of which OnDemand are for refreshes someone is waiting on
+	Queued   int
+	OnDemand int
+
+	// Completed is how many items have been analyzed since the pool started
+	Completed int64
+
+	// Throughput is items analyzed per second, over the last full window
+	Throughput float64
+
+	// Latency is the moving average of how long an item takes to analyze
+	Latency time.Duration
+
+	// Throttled is whether the pool has been shrunk to conserve API quota
+	Throttled bool
+}
+
+// analyzerPool runs analysis jobs for every search on one set of workers.
+//
+// The pool grows while jobs are slow and queued, which means they are waiting on API calls, and shrinks
+// when they are fast cache hits or when the API quota is running low. On-demand jobs are run before any
+// queued background job, so that a reload is not stuck behind the refresh of every other collection.
+type analyzerPool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	queue    []func()
+	onDemand []func()
+
+	min     int
+	max     int
+	target  int
+	workers int
+	idle    int
+
+	// slow and fast are the latencies which grow and shrink the pool
+	slow time.Duration
+	fast time.Duration
+
+	// throttled returns whether API calls should be cut back
+	throttled func() bool
+	throttle  bool
+
+	latency   time.Duration
+	completed int64
+
+	started     time.Time
+	windowStart time.Time
+	windowCount int64
+	throughput  float64
+}
+
+func newAnalyzerPool(max int, throttled func() bool) *analyzerPool {
+	if max <= 0 {
+		max = DefaultAnalyzerWorkers
+	}
+
+	min := minAnalyzerWorkers
+	if min > max {
+		min = max
+	}
+
+	target := initialAnalyzerWorkers
+	if target > max {
+		target = max
+	}
+
+	now := time.Now()
+	p := &analyzerPool{
+		min:         min,
+		max:         max,
+		target:      target,
+		slow:        slowJob,
+		fast:        fastJob,
+		throttled:   throttled,
+		started:     now,
+		windowStart: now,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// submit queues a job, starting workers if the pool is below its target
+func (p *analyzerPool) submit(job func(), onDemand bool) {
+	p.mu.Lock()
+	if onDemand {
+		p.onDemand = append(p.onDemand, job)
+	} else {
+		p.queue = append(p.queue, job)
+	}
+	p.scaleLocked()
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// queuedLocked returns how many jobs are waiting for a worker. p.mu must be held.
+func (p *analyzerPool) queuedLocked() int {
+	return len(p.queue) + len(p.onDemand)
+}
+
+// nextLocked dequeues the next job, on-demand jobs first. p.mu must be held.
+func (p *analyzerPool) nextLocked() func() {
+	q := &p.queue
+	if len(p.onDemand) > 0 {
+		q = &p.onDemand
+	}
+
+	job := (*q)[0]
+	(*q)[0] = nil
+	*q = (*q)[1:]
+	return job
+}
+
+// scaleLocked starts workers for queued jobs until the target is reached. p.mu must be held.
+func (p *analyzerPool) scaleLocked() {
+	for p.workers < p.target && p.queuedLocked() > p.idle {
+		p.workers++
+		p.idle++
+		go p.work()
+	}
+}
+
+func (p *analyzerPool) work() {
+	p.mu.Lock()
+	for {
+		// Surplus workers exit once the target shrinks
+		if p.workers > p.target {
+			p.workers--
+			p.idle--
+			p.mu.Unlock()
+			return
+		}
+
+		if p.queuedLocked() == 0 {
+			p.cond.Wait()
+			continue
+		}
+
+		p.idle--
+		job := p.nextLocked()
+		p.mu.Unlock()
+
+		start := time.Now()
+		job()
+		d := time.Since(start)
+
+		p.mu.Lock()
+		p.idle++
+		p.doneLocked(d)
+	}
+}
+
+// doneLocked records a completed job and adapts the pool size. p.mu must be held.
+func (p *analyzerPool) doneLocked(d time.Duration) {
+	now := time.Now()
+	p.completed++
+	p.windowCount++
+	if elapsed := now.Sub(p.windowStart); elapsed >= throughputWindow {
+		p.throughput = float64(p.windowCount) / elapsed.Seconds()
+		p.windowStart = now
+		p.windowCount = 0
+	}
+
+	if p.latency == 0 {
+		p.latency = d
+	} else {
+		p.latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(p.latency))
+	}
+
+	p.adaptLocked()
+	p.scaleLocked()
+}
+
+// adaptLocked moves the target size towards what the recent latency and API quota call for. p.mu must be held.
+func (p *analyzerPool) adaptLocked() {
+	throttle := p.throttled != nil && p.throttled()
+	if throttle != p.throttle {
+		if throttle {
+			klog.Warningf("API quota is low: shrinking analyzer pool to %d workers", p.min)
+		} else {
+			klog.Infof("API quota has recovered: analyzer pool may grow to %d workers", p.max)
+		}
+		p.throttle = throttle
+	}
+
+	target := p.target
+	switch {
+	case throttle:
+		target = p.min
+	case p.latency >= p.slow && p.queuedLocked() > 0:
+		target++
+	case p.latency < p.fast:
+		target--
+	}
+
+	if target < p.min {
+		target = p.min
+	}
+	if target > p.max {
+		target = p.max
+	}
+
+	if target != p.target {
+		klog.V(1).Infof("analyzer pool: %d -> %d workers (latency %s, %d queued)", p.target, target, p.latency, p.queuedLocked())
+		p.target = target
+	}
+
+	// Wake idle workers so that any surplus can exit
+	if p.workers > p.target {
+		p.cond.Broadcast()
+	}
+}
+
+// metrics returns a snapshot of the pool
+func (p *analyzerPool) metrics() AnalyzerMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m := AnalyzerMetrics{
+		Since:      p.started,
+		Workers:    p.workers,
+		Target:     p.target,
+		MinWorkers: p.min,
+		MaxWorkers: p.max,
+		Queued:     p.queuedLocked(),
+		OnDemand:   len(p.onDemand),
+		Completed:  p.completed,
+		Throughput: p.throughput,
+		Latency:    p.latency,
+		Throttled:  p.throttle,
+	}
+
+	// Until a full window has passed, report the rate so far
+	if m.Throughput == 0 {
+		if elapsed := time.Since(p.windowStart); elapsed > 0 {
+			m.Throughput = float64(p.windowCount) / elapsed.Seconds()
+		}
+	}
+	return m
+}
+
+// AnalyzerMetrics returns a snapshot of the analyzer pool shared by all searches
+func (e *Engine) AnalyzerMetrics() AnalyzerMetrics {
+	return e.analyzers.metrics()
+}
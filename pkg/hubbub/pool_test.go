@@ -0,0 +1,117 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runJobs submits n jobs which each take d, waiting until the pool has recorded them all
+func runJobs(t *testing.T, p *analyzerPool, n int, d time.Duration) {
+	t.Helper()
+
+	want := p.metrics().Completed + int64(n)
+	for i := 0; i < n; i++ {
+		p.submit(func() { time.Sleep(d) }, false)
+	}
+	require.Eventually(t, func() bool { return p.metrics().Completed == want }, 10*time.Second, time.Millisecond)
+}
+
+func TestAnalyzerPoolAdapts(t *testing.T) {
+	p := newAnalyzerPool(8, nil)
+	p.slow = 5 * time.Millisecond
+	p.fast = time.Millisecond
+	assert.Equal(t, initialAnalyzerWorkers, p.metrics().Target)
+
+	// Slow jobs with a backlog grow the pool to its maximum
+	runJobs(t, p, 60, 10*time.Millisecond)
+	m := p.metrics()
+	assert.Equal(t, 8, m.Target)
+	assert.Equal(t, int64(60), m.Completed)
+	assert.Equal(t, 0, m.Queued)
+	assert.Greater(t, m.Throughput, 0.0)
+
+	// Cache hits shrink it to the minimum
+	runJobs(t, p, 60, 0)
+	assert.Equal(t, minAnalyzerWorkers, p.metrics().Target)
+	assert.Eventually(t, func() bool { return p.metrics().Workers <= minAnalyzerWorkers }, time.Second, time.Millisecond)
+}
+
+func TestAnalyzerPoolThrottled(t *testing.T) {
+	var low atomic.Bool
+	low.Store(true)
+	p := newAnalyzerPool(8, low.Load)
+	p.slow = time.Millisecond
+	p.fast = 100 * time.Microsecond
+
+	runJobs(t, p, 20, 2*time.Millisecond)
+	m := p.metrics()
+	assert.True(t, m.Throttled)
+	assert.Equal(t, minAnalyzerWorkers, m.Target)
+	assert.LessOrEqual(t, m.Workers, initialAnalyzerWorkers)
+
+	low.Store(false)
+	runJobs(t, p, 40, 2*time.Millisecond)
+	m = p.metrics()
+	assert.False(t, m.Throttled)
+	assert.Greater(t, m.Target, minAnalyzerWorkers)
+}
+
+func TestAnalyzerPoolBounds(t *testing.T) {
+	p := newAnalyzerPool(1, nil)
+	m := p.metrics()
+	assert.Equal(t, 1, m.MinWorkers)
+	assert.Equal(t, 1, m.MaxWorkers)
+	assert.Equal(t, 1, m.Target)
+
+	p = newAnalyzerPool(0, nil)
+	assert.Equal(t, DefaultAnalyzerWorkers, p.metrics().MaxWorkers)
+}
+
+func TestAnalyzerPoolOnDemand(t *testing.T) {
+	p := newAnalyzerPool(1, nil)
+
+	// Occupy the only worker while jobs queue up
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p.submit(func() {
+		close(started)
+		<-release
+	}, false)
+	<-started
+
+	order := make(chan string, 4)
+	p.submit(func() { order <- "background 1" }, false)
+	p.submit(func() { order <- "background 2" }, false)
+	p.submit(func() { order <- "on demand" }, true)
+
+	m := p.metrics()
+	assert.Equal(t, 3, m.Queued)
+	assert.Equal(t, 1, m.OnDemand)
+
+	close(release)
+	assert.Equal(t, "on demand", <-order)
+	assert.Equal(t, "background 1", <-order)
+	assert.Equal(t, "background 2", <-order)
+
+	assert.True(t, onDemand(OnDemand(context.Background())))
+	assert.False(t, onDemand(context.Background()))
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/google/triage-party/pkg/provider"
 
 	"github.com/google/go-github/v33/github"
+	"github.com/google/triage-party/pkg/logu"
 	"github.com/google/triage-party/pkg/tag"
 	"k8s.io/klog/v2"
 )
@@ -43,11 +44,18 @@ const (
 // cachedPRs returns a list of cached PR's if possible
 func (h *Engine) cachedPRs(ctx context.Context, sp provider.SearchParams) ([]*provider.PullRequest, time.Time, error) {
 	sp.SearchKey = prSearchKey(sp)
+	unlock, err := h.lockSearch(ctx, sp.SearchKey)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer unlock()
+
 	if x := h.cache.Get(sp.SearchKey, sp.NewerThan); x != nil {
 		// Normally the similarity tables are only updated when fresh data is encountered.
 		if sp.NewerThan.IsZero() {
 			go h.updateSimilarPullRequests(sp.SearchKey, x.PullRequests)
 		}
+		h.updateContributorsFromPullRequests(sp.Repo, x.PullRequests)
 		return x.PullRequests, x.Created, nil
 	}
 
@@ -57,7 +65,7 @@ func (h *Engine) cachedPRs(ctx context.Context, sp provider.SearchParams) ([]*pr
 		klog.Warningf("Retrieving stale results for %s due to error: %v", sp.SearchKey, err)
 		x := h.cache.Get(sp.SearchKey, time.Time{})
 		if x != nil {
-			return x.PullRequests, x.Created, nil
+			return x.PullRequests, x.Created, &staleError{created: x.Created, err: err}
 		}
 	}
 	return prs, created, err
@@ -66,18 +74,36 @@ func (h *Engine) cachedPRs(ctx context.Context, sp provider.SearchParams) ([]*pr
 // updatePRs returns and caches live PR's
 func (h *Engine) updatePRs(ctx context.Context, sp provider.SearchParams) ([]*provider.PullRequest, time.Time, error) {
 	start := time.Now()
+	base := h.syncBase(sp)
+
 	sp.PullRequestListOptions = provider.PullRequestListOptions{
 		ListOptions: provider.ListOptions{PerPage: 100},
 		State:       sp.State,
 		Sort:        constants.UpdatedSortOption,
 		Direction:   constants.DescDirectionOption,
 	}
+
+	// PR listings do not support opt.Since, so stop once results are older than this
+	var oldest time.Time
+	if sp.UpdateAge != 0 {
+		oldest = time.Now().Add(-1 * sp.UpdateAge)
+	}
+
+	// Only fetch what changed since the last sync, including PRs which have since closed
+	if base != nil {
+		sp.PullRequestListOptions.State = constants.AllState
+		oldest = base.Cursor.Add(-1 * cursorSkew)
+	}
 	klog.V(1).Infof("%s PR list opts for %s: %+v", sp.State, sp.SearchKey, sp.PullRequestListOptions)
 
 	foundOldest := false
 	var allPRs []*provider.PullRequest
+	var updated []time.Time
 	for {
-		if sp.UpdateAge == 0 {
+		if base != nil {
+			klog.Infof("Syncing %s pull requests for %s/%s changed since %s (page %d)...",
+				sp.State, sp.Repo.Organization, sp.Repo.Project, logu.STime(oldest), sp.PullRequestListOptions.Page)
+		} else if sp.UpdateAge == 0 {
 			klog.Infof("Downloading %s pull requests for %s/%s (page %d)...",
 				sp.State, sp.Repo.Organization, sp.Repo.Project, sp.PullRequestListOptions.Page)
 		} else {
@@ -93,23 +119,22 @@ func (h *Engine) updatePRs(ctx context.Context, sp provider.SearchParams) ([]*pr
 			}
 			return prs, start, err
 		}
-		h.logRate(resp.Rate)
+		h.logRate(sp.Repo.Host, resp.Rate)
 
 		for _, pr := range prs {
-			// Because PR searches do not support opt.Since
-			if sp.UpdateAge != 0 {
-				if time.Since(pr.GetUpdatedAt()) > sp.UpdateAge {
-					foundOldest = true
-					break
-				}
+			if !oldest.IsZero() && pr.GetUpdatedAt().Before(oldest) {
+				foundOldest = true
+				break
 			}
 
 			h.updateMtime(pr, pr.GetUpdatedAt())
+			updated = append(updated, pr.GetUpdatedAt())
 
 			allPRs = append(allPRs, pr)
 		}
 
 		go h.updateSimilarPullRequests(sp.SearchKey, prs)
+		h.updateContributorsFromPullRequests(sp.Repo, prs)
 
 		if resp.NextPage == 0 || resp.NextPage == sp.PullRequestListOptions.Page || foundOldest {
 			break
@@ -117,7 +142,19 @@ func (h *Engine) updatePRs(ctx context.Context, sp provider.SearchParams) ([]*pr
 		sp.PullRequestListOptions.Page = resp.NextPage
 	}
 
-	if err := h.cache.Set(sp.SearchKey, &persist.Blob{PullRequests: allPRs}); err != nil {
+	bl := &persist.Blob{PullRequests: allPRs}
+	if base != nil {
+		klog.Infof("Synced %d changed PRs into %d open PRs for %s", len(allPRs), len(base.PullRequests), sp.SearchKey)
+		bl.PullRequests = mergePRs(base.PullRequests, allPRs)
+		bl.Cursor = cursorFor(base.Cursor, updated)
+		bl.Listed = base.Listed
+		allPRs = bl.PullRequests
+	} else if syncable(sp) {
+		bl.Cursor = cursorFor(time.Time{}, updated)
+		bl.Listed = start
+	}
+
+	if err := h.cache.Set(sp.SearchKey, bl); err != nil {
 		klog.Errorf("set %q failed: %v", sp.SearchKey, err)
 	}
 
@@ -160,7 +197,7 @@ func (h *Engine) updatePR(ctx context.Context, sp provider.SearchParams) (*provi
 		return pr, start, err
 	}
 
-	h.logRate(resp.Rate)
+	h.logRate(sp.Repo.Host, resp.Rate)
 	h.updateMtime(pr, pr.GetUpdatedAt())
 
 	if err := h.cache.Set(sp.SearchKey, &persist.Blob{PullRequests: []*provider.PullRequest{pr}}); err != nil {
@@ -245,7 +282,7 @@ func (h *Engine) updateReviewComments(ctx context.Context, sp provider.SearchPar
 			return cs, start, err
 		}
 
-		h.logRate(resp.Rate)
+		h.logRate(sp.Repo.Host, resp.Rate)
 
 		klog.V(2).Infof("Received %d review comments", len(cs))
 		for _, c := range cs {
@@ -273,6 +310,11 @@ func (h *Engine) createPRSummary(ctx context.Context, sp provider.SearchParams,
 	co.TimelineTotal = len(timeline)
 	h.addEvents(ctx, sp, co, timeline)
 
+	for _, r := range reviews {
+		h.addActivity(co, r.User, r.GetSubmittedAt())
+	}
+	h.discountBots(co)
+
 	co.ReviewState = reviewState(pr, timeline, reviews)
 	co.Tags[reviewStateTag(co.ReviewState)] = true
 
@@ -287,6 +329,7 @@ func (h *Engine) createPRSummary(ctx context.Context, sp provider.SearchParams,
 		co.Tags[tag.Merged] = true
 	}
 
+	h.addContributorTags(co)
 	return co
 }
 
@@ -295,10 +338,13 @@ func (h *Engine) PRSummary(ctx context.Context, sp provider.SearchParams, pr *pr
 	key := pr.GetHTMLURL()
 	cached := h.cachedConversation(key)
 	if cached != nil {
-		if !cached.Seen.Before(h.mtime(pr)) && cached.CommentsSeen >= len(cs) && cached.TimelineTotal >= len(timeline) && cached.ReviewsTotal >= len(reviews) {
+		changed := h.contributionsChanged(cached)
+		if !cached.Seen.Before(h.mtime(pr)) && cached.CommentsSeen >= len(cs) && cached.TimelineTotal >= len(timeline) && cached.ReviewsTotal >= len(reviews) && !changed {
 			return cached
 		}
-		if cached.CommentsSeen < len(cs) {
+		if changed {
+			klog.V(2).Infof("%s in PR cache, but the authors contributor history has changed", pr.GetHTMLURL())
+		} else if cached.CommentsSeen < len(cs) {
 			klog.V(2).Infof("%s in issue cache, but is missing comments. Live @ %s (%d comments), cached @ %s (%d comments)  ", pr.GetHTMLURL(), h.mtime(pr), len(cs), cached.Seen, cached.CommentsSeen)
 		} else if cached.TimelineTotal < len(timeline) {
 			klog.Infof("%s in issue cache, but is missing timeline events. Live @ %s (%d events), cached @ %s (%d events)  ", pr.GetHTMLURL(), h.mtime(pr), len(timeline), cached.Seen, cached.TimelineTotal)
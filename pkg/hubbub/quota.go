@@ -0,0 +1,65 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+)
+
+// lowQuotaFraction is the fraction of an API rate limit below which the analyzer pool is shrunk
+const lowQuotaFraction = 0.20
+
+// Quota returns the most constrained API rate limit seen which has not yet reset
+func (h *Engine) Quota() (provider.Rate, bool) {
+	var lowest provider.Rate
+	found := false
+	now := time.Now()
+
+	h.rates.Range(func(_, v interface{}) bool {
+		r := v.(provider.Rate)
+		if r.Limit <= 0 || !now.Before(r.Reset.Time) {
+			return true
+		}
+
+		if !found || float64(r.Remaining)/float64(r.Limit) < float64(lowest.Remaining)/float64(lowest.Limit) {
+			lowest = r
+			found = true
+		}
+		return true
+	})
+
+	return lowest, found
+}
+
+// Conserve sets whether optional API calls, such as timelines for hidden collections, are skipped
+func (h *Engine) Conserve(on bool) {
+	h.conserve.Store(on)
+}
+
+func (h *Engine) conserving() bool {
+	return h.conserve.Load()
+}
+
+// lowQuota returns whether API calls should be cut back, either because the updater asked or the quota is nearly spent
+func (h *Engine) lowQuota() bool {
+	if h.conserving() {
+		return true
+	}
+
+	r, ok := h.Quota()
+	return ok && float64(r.Remaining)/float64(r.Limit) < lowQuotaFraction
+}
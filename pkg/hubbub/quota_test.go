@@ -0,0 +1,44 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuota(t *testing.T) {
+	h := &Engine{}
+	_, ok := h.Quota()
+	assert.False(t, ok)
+
+	soon := provider.Timestamp{Time: time.Now().Add(time.Hour)}
+	past := provider.Timestamp{Time: time.Now().Add(-time.Minute)}
+
+	h.logRate("github.com", provider.Rate{Remaining: 3000, Limit: 5000, Reset: soon})
+	h.logRate("gitlab.com", provider.Rate{Remaining: 500, Limit: 600, Reset: soon})
+	r, ok := h.Quota()
+	assert.True(t, ok)
+	assert.Equal(t, 3000, r.Remaining)
+
+	// Quotas which have since reset no longer constrain anything
+	h.logRate("github.com", provider.Rate{Remaining: 1, Limit: 5000, Reset: past})
+	r, ok = h.Quota()
+	assert.True(t, ok)
+	assert.Equal(t, 500, r.Remaining)
+}
@@ -58,7 +58,7 @@ func (h *Engine) updateReviews(ctx context.Context, sp provider.SearchParams) ([
 			return cs, start, err
 		}
 
-		h.logRate(resp.Rate)
+		h.logRate(sp.Repo.Host, resp.Rate)
 
 		allReviews = append(allReviews, cs...)
 		if resp.NextPage == 0 {
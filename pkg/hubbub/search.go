@@ -16,6 +16,7 @@ package hubbub
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -27,47 +28,52 @@ import (
 )
 
 // Search for GitHub issues or PR's
-func (h *Engine) SearchAny(ctx context.Context, sp provider.SearchParams) ([]*Conversation, time.Time, error) {
+func (h *Engine) SearchAny(ctx context.Context, sp provider.SearchParams) ([]*Conversation, time.Time, []Warning, error) {
 	var wg sync.WaitGroup
 	var cs []*Conversation
 	var ts time.Time
+	var ws []Warning
 	var err error
 
 	wg.Add(1)
 	go func() {
-		cs, ts, err = h.SearchIssues(ctx, sp)
+		cs, ts, ws, err = h.SearchIssues(ctx, sp)
 		wg.Done()
 	}()
 
 	var pcs []*Conversation
 	var pts time.Time
+	var pws []Warning
 	var perr error
 
 	wg.Add(1)
 	go func() {
-		pcs, pts, perr = h.SearchPullRequests(ctx, sp)
+		pcs, pts, pws, perr = h.SearchPullRequests(ctx, sp)
 		wg.Done()
 	}()
 
 	wg.Wait()
 
+	// Warnings from either search are kept, even if the other failed
+	ws = AppendWarnings(ws, pws...)
+
 	if err != nil {
-		return cs, ts, err
+		return cs, ts, ws, err
 	}
 
 	if perr != nil {
-		return pcs, pts, perr
+		return pcs, pts, ws, perr
 	}
 
 	if pts.After(ts) {
 		ts = pts
 	}
 
-	return append(cs, pcs...), ts, nil
+	return append(cs, pcs...), ts, ws, nil
 }
 
 // Search for GitHub issues or PR's
-func (h *Engine) SearchIssues(ctx context.Context, sp provider.SearchParams) ([]*Conversation, time.Time, error) {
+func (h *Engine) SearchIssues(ctx context.Context, sp provider.SearchParams) ([]*Conversation, time.Time, []Warning, error) {
 	sp.Filters = openByDefault(sp)
 	klog.V(1).Infof(
 		"Gathering raw data for %s/%s issues %s - newer than %s",
@@ -80,6 +86,7 @@ func (h *Engine) SearchIssues(ctx context.Context, sp provider.SearchParams) ([]
 
 	var open []*provider.Issue
 	var closed []*provider.Issue
+	ws := &warnings{}
 
 	start := time.Now()
 	age := time.Now()
@@ -95,8 +102,10 @@ func (h *Engine) SearchIssues(ctx context.Context, sp provider.SearchParams) ([]
 
 		oi, ots, err := h.cachedIssues(ctx, sp)
 		if err != nil {
-			klog.Errorf("open issues: %v", err)
-			return
+			ws.add(sp, "open issues", err)
+			if !isStale(err) {
+				return
+			}
 		}
 		if ots.Before(age) {
 			age = ots
@@ -117,7 +126,10 @@ func (h *Engine) SearchIssues(ctx context.Context, sp provider.SearchParams) ([]
 
 		ci, cts, err := h.cachedIssues(ctx, sp)
 		if err != nil {
-			klog.Errorf("closed issues: %v", err)
+			ws.add(sp, "closed issues", err)
+			if !isStale(err) {
+				return
+			}
 		}
 
 		if cts.Before(age) {
@@ -161,8 +173,11 @@ func (h *Engine) SearchIssues(ctx context.Context, sp provider.SearchParams) ([]
 	}
 
 	filtered := h.analyzeIssueMatches(ctx, is, sp, age, latestIssueUpdate)
+	if err := ctx.Err(); err != nil {
+		return nil, age, ws.list, fmt.Errorf("issue search: %w", err)
+	}
 	klog.Infof("issue search took %s, returning %d items: %+v", time.Since(start), len(filtered), sp)
-	return filtered, age, nil
+	return filtered, age, ws.list, nil
 }
 
 // NeedsClosed returns whether or not the filters require closed items
@@ -185,7 +200,7 @@ func NeedsClosed(fs []provider.Filter) bool {
 	return false
 }
 
-func (h *Engine) SearchPullRequests(ctx context.Context, sp provider.SearchParams) ([]*Conversation, time.Time, error) {
+func (h *Engine) SearchPullRequests(ctx context.Context, sp provider.SearchParams) ([]*Conversation, time.Time, []Warning, error) {
 	sp.Filters = openByDefault(sp)
 
 	klog.V(1).Infof("Gathering raw data for %s/%s PR's matching: %s - newer than %s",
@@ -195,6 +210,7 @@ func (h *Engine) SearchPullRequests(ctx context.Context, sp provider.SearchParam
 
 	var open []*provider.PullRequest
 	var closed []*provider.PullRequest
+	ws := &warnings{}
 	age := time.Now()
 	start := time.Now()
 
@@ -210,8 +226,10 @@ func (h *Engine) SearchPullRequests(ctx context.Context, sp provider.SearchParam
 
 		op, ots, err := h.cachedPRs(ctx, sp)
 		if err != nil {
-			klog.Errorf("open prs: %v", err)
-			return
+			ws.add(sp, "open PRs", err)
+			if !isStale(err) {
+				return
+			}
 		}
 		if ots.Before(age) {
 			klog.Infof("setting age to %s (open PR count)", ots)
@@ -233,8 +251,10 @@ func (h *Engine) SearchPullRequests(ctx context.Context, sp provider.SearchParam
 
 		cp, cts, err := h.cachedPRs(ctx, sp)
 		if err != nil {
-			klog.Errorf("closed prs: %v", err)
-			return
+			ws.add(sp, "closed PRs", err)
+			if !isStale(err) {
+				return
+			}
 		}
 
 		if cts.Before(age) {
@@ -263,7 +283,10 @@ func (h *Engine) SearchPullRequests(ctx context.Context, sp provider.SearchParam
 	}
 
 	filtered := h.analyzePRMatches(ctx, prs, sp, age)
+	if err := ctx.Err(); err != nil {
+		return nil, age, ws.list, fmt.Errorf("PR search: %w", err)
+	}
 
 	klog.Infof("PR search took %s, returning %d items: %+v", time.Since(start), len(filtered), sp)
-	return filtered, age, nil
+	return filtered, age, ws.list, nil
 }
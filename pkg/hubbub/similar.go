@@ -91,7 +91,7 @@ func (h *Engine) updateSimilarIssues(key string, is []*provider.Issue) {
 	start := time.Now()
 	klog.V(1).Infof("Updating similarity table from issue cache %q (%d items)", key, len(is))
 	for _, i := range is {
-		h.updateSimilarityTables(i.GetTitle(), i.GetHTMLURL())
+		h.updateSimilarity(i)
 	}
 	klog.V(1).Infof("%q took %s to update", key, time.Since(start))
 }
@@ -101,11 +101,27 @@ func (h *Engine) updateSimilarPullRequests(key string, prs []*provider.PullReque
 	start := time.Now()
 	klog.V(1).Infof("Updating similarity table from PR cache %q (%d items)", key, len(prs))
 	for _, i := range prs {
-		h.updateSimilarityTables(i.GetTitle(), i.GetHTMLURL())
+		h.updateSimilarity(i)
 	}
 	klog.V(1).Infof("%q took %s to update", key, time.Since(start))
 }
 
+// updateSimilarity updates whichever similarity tables are in use for an item
+func (h *Engine) updateSimilarity(i provider.IItem) {
+	if h.MinSimilarity == 0 {
+		return
+	}
+
+	if h.similarityMethod == TFIDFSimilarity {
+		if h.similarityIndex.update(i.GetHTMLURL(), repoFromURL(i.GetHTMLURL()), i.GetUpdatedAt(), i.GetTitle(), i.GetBody()) {
+			h.clusters.invalidate()
+		}
+		return
+	}
+
+	h.updateSimilarityTables(i.GetTitle(), i.GetHTMLURL())
+}
+
 func (h *Engine) updateSimilarityTables(rawTitle, url string) {
 	if h.MinSimilarity == 0 {
 		return
@@ -113,6 +129,21 @@ func (h *Engine) updateSimilarityTables(rawTitle, url string) {
 
 	title := normalizeTitle(rawTitle)
 
+	// Forget the previous title of a renamed item, so that it is no longer similar to the items it was
+	if prev, ok := h.urlToTitle.Load(url); ok && prev.(string) != title {
+		if res, ok := h.titleToURLs.Load(prev); ok {
+			kept := []string{}
+			for _, v := range res.([]string) {
+				if v != url {
+					kept = append(kept, v)
+				}
+			}
+			h.titleToURLs.Store(prev, kept)
+		}
+		h.clusters.invalidate()
+	}
+	h.urlToTitle.Store(url, title)
+
 	result, existing := h.titleToURLs.LoadOrStore(title, []string{url})
 	if existing {
 		foundURL := false
@@ -128,6 +159,7 @@ func (h *Engine) updateSimilarityTables(rawTitle, url string) {
 		if !foundURL {
 			klog.V(4).Infof("updating %q with %v", rawTitle, otherURLs)
 			h.titleToURLs.Store(title, append(otherURLs, url))
+			h.clusters.invalidate()
 		}
 		return
 	}
@@ -152,6 +184,7 @@ func (h *Engine) updateSimilarityTables(rawTitle, url string) {
 	})
 
 	h.similarTitles.Store(title, similarTo)
+	h.clusters.invalidate()
 
 	// Update them -> us title similarity
 	for _, other := range similarTo {
@@ -169,6 +202,10 @@ func (h *Engine) FindSimilar(co *Conversation) []*RelatedConversation {
 		return nil
 	}
 
+	if h.similarityMethod == TFIDFSimilarity {
+		return h.findSimilarTFIDF(co)
+	}
+
 	simco := []*RelatedConversation{}
 	title := normalizeTitle(co.Title)
 	similarURLs := []string{}
@@ -214,8 +251,40 @@ func (h *Engine) FindSimilar(co *Conversation) []*RelatedConversation {
 			continue
 		}
 
-		simco = append(simco, makeRelated(oco))
+		if h.similarityScope == RepoScope && repoFromURL(url) != repoFromURL(co.URL) {
+			continue
+		}
+
+		rel := makeRelated(oco)
+		rel.Similarity = godice.CompareString(title, normalizeTitle(oco.Title))
+		simco = append(simco, rel)
 		added[url] = true
 	}
 	return simco
 }
+
+// findSimilarTFIDF locates similar conversations using the TF-IDF index
+func (h *Engine) findSimilarTFIDF(co *Conversation) []*RelatedConversation {
+	simco := []*RelatedConversation{}
+
+	for _, su := range h.similarityIndex.similar(co.URL, h.similarityScope, h.MinSimilarity) {
+		oco := h.cachedConversation(su.URL)
+		if oco == nil {
+			continue
+		}
+
+		if oco.Type != co.Type {
+			continue
+		}
+
+		klog.V(4).Infof("#%d is %.2f similar to %s", co.ID, su.Score, su.URL)
+		rel := makeRelated(oco)
+		rel.Similarity = su.Score
+		simco = append(simco, rel)
+	}
+
+	if len(simco) == 0 {
+		return nil
+	}
+	return simco
+}
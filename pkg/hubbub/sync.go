@@ -0,0 +1,119 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/triage-party/pkg/constants"
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/provider"
+)
+
+// fullListingAge is how often incremental syncs are replaced by a full listing, which catches deleted and transferred items
+var fullListingAge = 24 * time.Hour
+
+// cursorSkew is how far before the cursor incremental syncs begin, to tolerate clock skew and in-flight updates
+var cursorSkew = 2 * time.Minute
+
+// syncable returns whether a search lists every open item, and so can be synced incrementally
+func syncable(sp provider.SearchParams) bool {
+	return sp.UpdateAge == 0 && (sp.State == constants.OpenState || sp.State == constants.OpenedState)
+}
+
+// syncBase returns a previous listing which can be synced incrementally, or nil if a full listing is needed
+func (h *Engine) syncBase(sp provider.SearchParams) *persist.Blob {
+	if !syncable(sp) {
+		return nil
+	}
+
+	x := h.cache.Get(sp.SearchKey, time.Time{})
+	if x == nil || x.Cursor.IsZero() || time.Since(x.Listed) > fullListingAge {
+		return nil
+	}
+	return x
+}
+
+// isOpen returns whether an item is open, on any provider
+func isOpen(i provider.IItem) bool {
+	return i.GetState() == constants.OpenState || i.GetState() == constants.OpenedState
+}
+
+// cursorFor returns the latest update time among items, or the previous cursor if it is later
+func cursorFor(prev time.Time, updated []time.Time) time.Time {
+	c := prev
+	for _, t := range updated {
+		if t.After(c) {
+			c = t
+		}
+	}
+	return c
+}
+
+// mergeIssues applies changed issues to a previous open set, dropping those which are no longer open
+func mergeIssues(open []*provider.Issue, changed []*provider.Issue) []*provider.Issue {
+	byNum := map[int]*provider.Issue{}
+	for _, i := range open {
+		byNum[i.GetNumber()] = i
+	}
+
+	for _, i := range changed {
+		if isOpen(i) {
+			byNum[i.GetNumber()] = i
+		} else {
+			delete(byNum, i.GetNumber())
+		}
+	}
+
+	merged := []*provider.Issue{}
+	for _, i := range byNum {
+		merged = append(merged, i)
+	}
+
+	// Match the newest-first order of a full listing
+	sort.Slice(merged, func(a, b int) bool { return merged[a].GetNumber() > merged[b].GetNumber() })
+	return merged
+}
+
+// mergePRs applies changed pull requests to a previous open set, dropping those which are no longer open
+func mergePRs(open []*provider.PullRequest, changed []*provider.PullRequest) []*provider.PullRequest {
+	byNum := map[int]*provider.PullRequest{}
+	for _, pr := range open {
+		byNum[pr.GetNumber()] = pr
+	}
+
+	for _, pr := range changed {
+		if isOpen(pr) {
+			byNum[pr.GetNumber()] = pr
+		} else {
+			delete(byNum, pr.GetNumber())
+		}
+	}
+
+	merged := []*provider.PullRequest{}
+	for _, pr := range byNum {
+		merged = append(merged, pr)
+	}
+
+	// Match the most-recently-updated-first order of a full listing
+	sort.Slice(merged, func(a, b int) bool {
+		if !merged[a].GetUpdatedAt().Equal(merged[b].GetUpdatedAt()) {
+			return merged[a].GetUpdatedAt().After(merged[b].GetUpdatedAt())
+		}
+		return merged[a].GetNumber() > merged[b].GetNumber()
+	})
+	return merged
+}
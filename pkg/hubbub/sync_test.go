@@ -0,0 +1,222 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLister serves issues and pull requests the way the GitHub API lists them
+type fakeLister struct {
+	provider.Provider
+
+	issues   []*provider.Issue
+	prs      []*provider.PullRequest
+	comments []*provider.IssueComment
+	err      error
+
+	// timelines is how many issue timelines were requested
+	timelines int
+
+	issueOpts []provider.IssueListByRepoOptions
+	prOpts    []provider.PullRequestListOptions
+}
+
+func (f *fakeLister) IssuesListByRepo(_ context.Context, sp provider.SearchParams) ([]*provider.Issue, *provider.Response, error) {
+	opts := sp.IssueListByRepoOptions
+	f.issueOpts = append(f.issueOpts, opts)
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+
+	var is []*provider.Issue
+	for _, i := range f.issues {
+		if opts.State != "all" && i.GetState() != opts.State {
+			continue
+		}
+		if i.GetUpdatedAt().Before(opts.Since) {
+			continue
+		}
+		is = append(is, i)
+	}
+	return is, &provider.Response{}, nil
+}
+
+func (f *fakeLister) PullRequestsList(_ context.Context, sp provider.SearchParams) ([]*provider.PullRequest, *provider.Response, error) {
+	opts := sp.PullRequestListOptions
+	f.prOpts = append(f.prOpts, opts)
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+
+	var prs []*provider.PullRequest
+	for _, pr := range f.prs {
+		if opts.State != "all" && pr.GetState() != opts.State {
+			continue
+		}
+		prs = append(prs, pr)
+	}
+
+	sort.Slice(prs, func(a, b int) bool { return prs[a].GetUpdatedAt().After(prs[b].GetUpdatedAt()) })
+	return prs, &provider.Response{}, nil
+}
+
+func (f *fakeLister) IssuesListComments(_ context.Context, _ provider.SearchParams) ([]*provider.IssueComment, *provider.Response, error) {
+	return f.comments, &provider.Response{}, nil
+}
+
+func (f *fakeLister) IssuesListIssueTimeline(_ context.Context, _ provider.SearchParams) ([]*provider.Timeline, *provider.Response, error) {
+	f.timelines++
+	return nil, &provider.Response{}, nil
+}
+
+func issue(num int, state string, updated time.Time) *provider.Issue {
+	return &provider.Issue{Number: &num, State: &state, UpdatedAt: &updated}
+}
+
+func pullRequest(num int, state string, updated time.Time) *provider.PullRequest {
+	return &provider.PullRequest{Number: &num, State: &state, UpdatedAt: &updated}
+}
+
+func newSyncEngine(t *testing.T, f *fakeLister) *Engine {
+	t.Helper()
+
+	c, err := persist.NewMemory(persist.Config{})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+	return New(Config{Cache: c, GitHub: f, GitLab: f})
+}
+
+func issueNumbers(is []*provider.Issue) []int {
+	nums := []int{}
+	for _, i := range is {
+		nums = append(nums, i.GetNumber())
+	}
+	return nums
+}
+
+func TestIncrementalIssues(t *testing.T) {
+	then := time.Now().Add(-time.Hour)
+	f := &fakeLister{issues: []*provider.Issue{
+		issue(1, "open", then),
+		issue(2, "open", then.Add(time.Minute)),
+		issue(3, "open", then.Add(2*time.Minute)),
+	}}
+	h := newSyncEngine(t, f)
+
+	sp := provider.SearchParams{Repo: provider.Repo{Organization: "org", Project: "project"}, State: "open"}
+	sp.SearchKey = issueSearchKey(sp)
+
+	is, _, err := h.updateIssues(context.Background(), sp)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, issueNumbers(is))
+	assert.Equal(t, "open", f.issueOpts[0].State)
+	assert.True(t, f.issueOpts[0].Since.IsZero())
+
+	// #2 closes and #4 opens
+	f.issues[1] = issue(2, "closed", then.Add(30*time.Minute))
+	f.issues = append(f.issues, issue(4, "open", then.Add(40*time.Minute)))
+
+	is, _, err = h.updateIssues(context.Background(), sp)
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 3, 1}, issueNumbers(is))
+	assert.Equal(t, "all", f.issueOpts[1].State)
+	assert.Equal(t, then.Add(2*time.Minute).Add(-cursorSkew), f.issueOpts[1].Since)
+
+	bl := h.cache.Get(sp.SearchKey, time.Time{})
+	require.NotNil(t, bl)
+	assert.Equal(t, then.Add(40*time.Minute), bl.Cursor)
+
+	// Listings which are too old are refreshed in full
+	bl.Listed = time.Now().Add(-2 * fullListingAge)
+	require.NoError(t, h.cache.Set(sp.SearchKey, bl))
+	_, _, err = h.updateIssues(context.Background(), sp)
+	require.NoError(t, err)
+	assert.Equal(t, "open", f.issueOpts[2].State)
+	assert.True(t, f.issueOpts[2].Since.IsZero())
+
+	// Partial searches are never synced incrementally
+	sp.UpdateAge = time.Hour
+	sp.SearchKey = issueSearchKey(sp)
+	assert.Nil(t, h.syncBase(sp))
+}
+
+func TestIncrementalPRs(t *testing.T) {
+	then := time.Now().Add(-time.Hour)
+	f := &fakeLister{prs: []*provider.PullRequest{
+		pullRequest(1, "open", then),
+		pullRequest(2, "open", then.Add(time.Minute)),
+	}}
+	h := newSyncEngine(t, f)
+
+	sp := provider.SearchParams{Repo: provider.Repo{Organization: "org", Project: "project"}, State: "open"}
+	sp.SearchKey = prSearchKey(sp)
+
+	prs, _, err := h.updatePRs(context.Background(), sp)
+	require.NoError(t, err)
+	assert.Len(t, prs, 2)
+
+	f.prs[0] = pullRequest(1, "closed", then.Add(20*time.Minute))
+	f.prs = append(f.prs, pullRequest(3, "open", then.Add(30*time.Minute)))
+
+	prs, _, err = h.updatePRs(context.Background(), sp)
+	require.NoError(t, err)
+	require.Len(t, prs, 2)
+	assert.Equal(t, 3, prs[0].GetNumber())
+	assert.Equal(t, 2, prs[1].GetNumber())
+	assert.Equal(t, "all", f.prOpts[1].State)
+}
+
+func TestIncrementalGitLab(t *testing.T) {
+	then := time.Now().Add(-time.Hour)
+	f := &fakeLister{
+		issues: []*provider.Issue{issue(1, "opened", then), issue(2, "opened", then.Add(time.Minute))},
+		prs:    []*provider.PullRequest{pullRequest(1, "opened", then), pullRequest(2, "opened", then.Add(time.Minute))},
+	}
+	h := newSyncEngine(t, f)
+
+	// GitLab calls open items "opened"
+	sp := provider.SearchParams{Repo: provider.Repo{Host: "gitlab.com", Organization: "org", Project: "project"}, State: "opened"}
+	assert.True(t, syncable(sp))
+
+	sp.SearchKey = issueSearchKey(sp)
+	_, _, err := h.updateIssues(context.Background(), sp)
+	require.NoError(t, err)
+
+	f.issues[0] = issue(1, "closed", then.Add(20*time.Minute))
+	is, _, err := h.updateIssues(context.Background(), sp)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, issueNumbers(is))
+	assert.Equal(t, "all", f.issueOpts[1].State)
+
+	sp.SearchKey = prSearchKey(sp)
+	_, _, err = h.updatePRs(context.Background(), sp)
+	require.NoError(t, err)
+
+	f.prs[1] = pullRequest(2, "merged", then.Add(20*time.Minute))
+	prs, _, err := h.updatePRs(context.Background(), sp)
+	require.NoError(t, err)
+	require.Len(t, prs, 1)
+	assert.Equal(t, 1, prs[0].GetNumber())
+	assert.Equal(t, "all", f.prOpts[1].State)
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TitleSimilarity compares normalized titles
+	TitleSimilarity = "title"
+	// TFIDFSimilarity compares titles, bodies and error signatures
+	TFIDFSimilarity = "tfidf"
+
+	// RepoScope only finds similar items within the same repository
+	RepoScope = "repo"
+	// GlobalScope finds similar items across all repositories
+	GlobalScope = "global"
+
+	// maxIndexedBody is how much of a body we are willing to index
+	maxIndexedBody = 16 * 1024
+
+	// signatureWeight is how many times an error signature counts for
+	signatureWeight = 3
+)
+
+var (
+	tokenRe     = regexp.MustCompile(`[a-z][a-z0-9_]+`)
+	errorLineRe = regexp.MustCompile(`(?i)(panic|error|exception|fatal|traceback|segfault)`)
+	frameRe     = regexp.MustCompile(`([\w\-]+\.(?:go|py|java|js|ts|rb|rs|c|cc|cpp|kt|swift)):\d+`)
+	javaFrameRe = regexp.MustCompile(`\bat ([\w\.$]+)\(`)
+	volatileRe  = regexp.MustCompile(`0x[0-9a-fA-F]+|[0-9]+`)
+	spaceRe     = regexp.MustCompile(`\s+`)
+)
+
+// document is an indexed conversation
+type document struct {
+	repo    string
+	updated time.Time
+	terms   map[string]float64
+
+	// norm is the length of the TF-IDF vector as of index generation normGen
+	norm    float64
+	normGen uint64
+}
+
+// similarityIndex is an incrementally updated TF-IDF index over conversation text
+type similarityIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*document
+	df       map[string]int
+	postings map[string]map[string]bool
+
+	// gen changes whenever documents are added or removed, as that changes the IDF of every term
+	gen uint64
+	// normMu guards cached document norms, which are filled in under the read lock
+	normMu sync.Mutex
+}
+
+// scoredURL is a similarity search result
+type scoredURL struct {
+	URL   string
+	Score float64
+}
+
+func newSimilarityIndex() *similarityIndex {
+	return &similarityIndex{
+		docs:     map[string]*document{},
+		df:       map[string]int{},
+		postings: map[string]map[string]bool{},
+		gen:      1,
+	}
+}
+
+// errorSignatures extracts normalized error lines and stack frames from text
+func errorSignatures(text string) []string {
+	sigs := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || len(line) > 300 {
+			continue
+		}
+
+		for _, m := range frameRe.FindAllStringSubmatch(line, -1) {
+			sigs = append(sigs, "frame:"+strings.ToLower(m[1]))
+		}
+
+		for _, m := range javaFrameRe.FindAllStringSubmatch(line, -1) {
+			sigs = append(sigs, "frame:"+strings.ToLower(m[1]))
+		}
+
+		if errorLineRe.MatchString(line) {
+			norm := strings.ToLower(volatileRe.ReplaceAllString(line, ""))
+			norm = strings.TrimSpace(spaceRe.ReplaceAllString(norm, " "))
+			if len(norm) > 120 {
+				norm = norm[:120]
+			}
+			sigs = append(sigs, "sig:"+norm)
+		}
+	}
+	return sigs
+}
+
+// termFrequencies returns log-scaled term frequencies for a title and body
+func termFrequencies(title string, body string) map[string]float64 {
+	if len(body) > maxIndexedBody {
+		body = body[:maxIndexedBody]
+	}
+
+	counts := map[string]int{}
+	for _, t := range tokenRe.FindAllString(strings.ToLower(title+"\n"+body), -1) {
+		if len(t) < 3 || removeWords[t] {
+			continue
+		}
+		counts[t]++
+	}
+
+	// Titles are short, but say a lot
+	for _, t := range tokenRe.FindAllString(strings.ToLower(title), -1) {
+		if len(t) < 3 || removeWords[t] {
+			continue
+		}
+		counts[t]++
+	}
+
+	for _, s := range errorSignatures(body) {
+		counts[s] += signatureWeight
+	}
+
+	tf := map[string]float64{}
+	for t, c := range counts {
+		tf[t] = 1 + math.Log(float64(c))
+	}
+	return tf
+}
+
+// update adds or replaces a document within the index, returning whether it changed
+func (x *similarityIndex) update(url string, repo string, updated time.Time, title string, body string) bool {
+	x.mu.RLock()
+	old := x.docs[url]
+	x.mu.RUnlock()
+
+	if old != nil && !updated.After(old.updated) {
+		return false
+	}
+
+	doc := &document{repo: repo, updated: updated, terms: termFrequencies(title, body)}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if old = x.docs[url]; old != nil {
+		if !updated.After(old.updated) {
+			return false
+		}
+		x.remove(url, old)
+	}
+
+	x.gen++
+	x.docs[url] = doc
+	for t := range doc.terms {
+		x.df[t]++
+		if x.postings[t] == nil {
+			x.postings[t] = map[string]bool{}
+		}
+		x.postings[t][url] = true
+	}
+	return true
+}
+
+// urls returns the URL's of every indexed document
+func (x *similarityIndex) urls() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	urls := make([]string, 0, len(x.docs))
+	for url := range x.docs {
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+// remove removes a document from the index, must be called with the lock held
+func (x *similarityIndex) remove(url string, doc *document) {
+	for t := range doc.terms {
+		x.df[t]--
+		delete(x.postings[t], url)
+		if x.df[t] <= 0 {
+			delete(x.df, t)
+			delete(x.postings, t)
+		}
+	}
+	delete(x.docs, url)
+	x.gen++
+}
+
+// idf returns the inverse document frequency for a term, must be called with the lock held
+func (x *similarityIndex) idf(t string) float64 {
+	df := x.df[t]
+	if df == 0 {
+		return 0
+	}
+	return math.Log(float64(len(x.docs)+1) / float64(df))
+}
+
+// norm returns the length of a documents TF-IDF vector, must be called with the lock held
+func (x *similarityIndex) norm(doc *document) float64 {
+	x.normMu.Lock()
+	defer x.normMu.Unlock()
+
+	if doc.normGen == x.gen {
+		return doc.norm
+	}
+
+	sum := 0.0
+	for t, tf := range doc.terms {
+		w := tf * x.idf(t)
+		sum += w * w
+	}
+	doc.norm = math.Sqrt(sum)
+	doc.normGen = x.gen
+	return doc.norm
+}
+
+// similar returns documents with a cosine similarity of at least min, most similar first
+func (x *similarityIndex) similar(url string, scope string, min float64) []scoredURL {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	q := x.docs[url]
+	if q == nil {
+		return nil
+	}
+
+	qnorm := x.norm(q)
+	if qnorm == 0 {
+		return nil
+	}
+
+	// Terms which appear in most documents are not worth comparing against
+	maxDF := len(x.docs)/2 + 1
+
+	dots := map[string]float64{}
+	for t, tf := range q.terms {
+		if x.df[t] > maxDF {
+			continue
+		}
+		idf := x.idf(t)
+		qw := tf * idf
+		for other := range x.postings[t] {
+			if other == url {
+				continue
+			}
+			od := x.docs[other]
+			if scope != GlobalScope && od.repo != q.repo {
+				continue
+			}
+			dots[other] += qw * od.terms[t] * idf
+		}
+	}
+
+	found := []scoredURL{}
+	for other, dot := range dots {
+		onorm := x.norm(x.docs[other])
+		if onorm == 0 {
+			continue
+		}
+		score := dot / (qnorm * onorm)
+		if score >= min {
+			found = append(found, scoredURL{URL: other, Score: score})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Score > found[j].Score })
+	return found
+}
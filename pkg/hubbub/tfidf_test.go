@@ -0,0 +1,88 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTrace = `
+panic: runtime error: invalid memory address or nil pointer dereference
+[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4a2b3c]
+
+goroutine 1 [running]:
+k8s.io/minikube/pkg/drivers/kic.(*Driver).Start(0x0)
+	/app/pkg/drivers/kic/kic.go:231 +0x3c
+k8s.io/minikube/cmd/start.runStart()
+	/app/cmd/start.go:151 +0x8f
+`
+
+func TestSimilarityIndex(t *testing.T) {
+	x := newSimilarityIndex()
+	now := time.Now()
+
+	x.update("https://github.com/org/a/issues/1", "org/a", now, "minikube crashes on start", "I ran start and got:\n"+testTrace)
+	x.update("https://github.com/org/a/issues/2", "org/a", now, "nil pointer with kic driver", "Logs:\n"+testTrace+"\nversion 1.12")
+	x.update("https://github.com/org/a/issues/3", "org/a", now, "documentation typo", "the word receive is misspelled on the install page")
+	x.update("https://github.com/org/b/issues/4", "org/b", now, "crash when starting", testTrace)
+
+	found := x.similar("https://github.com/org/a/issues/1", RepoScope, 0.3)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "https://github.com/org/a/issues/2", found[0].URL)
+	assert.Greater(t, found[0].Score, 0.3)
+
+	found = x.similar("https://github.com/org/a/issues/1", GlobalScope, 0.3)
+	assert.Len(t, found, 2)
+
+	// Incremental updates replace the previous document
+	x.update("https://github.com/org/a/issues/2", "org/a", now.Add(time.Minute), "unrelated now", "the install page looks great")
+	found = x.similar("https://github.com/org/a/issues/1", RepoScope, 0.3)
+	assert.Len(t, found, 0)
+	assert.Len(t, x.docs, 4)
+
+	// Stale updates are ignored
+	x.update("https://github.com/org/a/issues/2", "org/a", now, "nil pointer with kic driver", testTrace)
+	found = x.similar("https://github.com/org/a/issues/1", RepoScope, 0.3)
+	assert.Len(t, found, 0)
+}
+
+func TestSimilarityIndexNorms(t *testing.T) {
+	x := newSimilarityIndex()
+	now := time.Now()
+
+	x.update("https://github.com/org/a/issues/1", "org/a", now, "minikube crashes on start", testTrace)
+	x.update("https://github.com/org/a/issues/2", "org/a", now, "crash when starting", testTrace)
+	x.similar("https://github.com/org/a/issues/1", RepoScope, 0.3)
+
+	doc := x.docs["https://github.com/org/a/issues/2"]
+	assert.Equal(t, x.gen, doc.normGen)
+	cached := doc.norm
+
+	// New documents change every IDF, so cached norms must be recomputed
+	x.update("https://github.com/org/a/issues/3", "org/a", now, "documentation typo", "the word receive is misspelled")
+	assert.NotEqual(t, x.gen, doc.normGen)
+	assert.NotEqual(t, cached, x.norm(doc))
+	assert.Equal(t, x.gen, doc.normGen)
+}
+
+func TestErrorSignatures(t *testing.T) {
+	sigs := errorSignatures(testTrace)
+	assert.Contains(t, sigs, "frame:kic.go")
+	assert.Contains(t, sigs, "frame:start.go")
+	assert.Contains(t, sigs, "sig:panic: runtime error: invalid memory address or nil pointer dereference")
+}
@@ -56,7 +56,7 @@ func (h *Engine) updateTimeline(ctx context.Context, sp provider.SearchParams) (
 		if err != nil {
 			return nil, err
 		}
-		h.logRate(resp.Rate)
+		h.logRate(sp.Repo.Host, resp.Rate)
 
 		for _, ev := range evs {
 			h.updateMtimeLong(sp.Repo.Organization, sp.Repo.Project, sp.IssueNumber, ev.GetCreatedAt())
@@ -98,6 +98,10 @@ func (h *Engine) addEvents(ctx context.Context, sp provider.SearchParams, co *Co
 			klog.Errorf("debug timeline event %q: %s", t.GetEvent(), formatStruct(t))
 		}
 
+		if humanEvents[t.GetEvent()] {
+			h.addActivity(co, t.GetActor(), t.GetCreatedAt())
+		}
+
 		if t.GetEvent() == "labeled" && t.GetLabel().GetName() == priority {
 			co.Prioritized = t.GetCreatedAt()
 		}
@@ -142,6 +146,8 @@ func (h *Engine) addEvents(ctx context.Context, sp provider.SearchParams, co *Co
 			}
 		}
 	}
+
+	h.discountBots(co)
 }
 
 func (h *Engine) prRef(ctx context.Context, sp provider.SearchParams, pr provider.IItem) *RelatedConversation {
@@ -0,0 +1,96 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/triage-party/pkg/logu"
+	"github.com/google/triage-party/pkg/provider"
+	"k8s.io/klog/v2"
+)
+
+// Warning describes data which could not be fetched, so search results may be incomplete
+type Warning struct {
+	// Repo is the repository searched, such as "kubernetes/minikube"
+	Repo string
+	// Phase is the data which could not be fetched, such as "closed issues"
+	Phase string
+	Error string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s %s: %s", w.Repo, w.Phase, w.Error)
+}
+
+// AppendWarnings appends warnings to a list, skipping those already present for the same repository and phase
+func AppendWarnings(ws []Warning, more ...Warning) []Warning {
+	for _, w := range more {
+		dupe := false
+		for _, x := range ws {
+			if x.Repo == w.Repo && x.Phase == w.Phase {
+				dupe = true
+				break
+			}
+		}
+
+		if !dupe {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+// staleError is returned along with cached data, when fresher data could not be fetched
+type staleError struct {
+	created time.Time
+	err     error
+}
+
+func (e *staleError) Error() string {
+	return fmt.Sprintf("showing stale data from %s: %v", logu.STime(e.created), e.err)
+}
+
+func (e *staleError) Unwrap() error {
+	return e.err
+}
+
+// isStale returns whether an error came with stale data, which may still be used
+func isStale(err error) bool {
+	var se *staleError
+	return errors.As(err, &se)
+}
+
+// warnings collects warnings from concurrent search phases
+type warnings struct {
+	mu   sync.Mutex
+	list []Warning
+}
+
+func (ws *warnings) add(sp provider.SearchParams, phase string, err error) {
+	w := Warning{
+		Repo:  fmt.Sprintf("%s/%s", sp.Repo.Organization, sp.Repo.Project),
+		Phase: phase,
+		Error: err.Error(),
+	}
+	klog.Errorf("%s", w)
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.list = append(ws.list, w)
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hubbub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendWarnings(t *testing.T) {
+	ws := AppendWarnings(nil,
+		Warning{Repo: "org/a", Phase: "open issues", Error: "first"},
+		Warning{Repo: "org/a", Phase: "open issues", Error: "second"},
+		Warning{Repo: "org/a", Phase: "open PRs", Error: "first"},
+	)
+	ws = AppendWarnings(ws, Warning{Repo: "org/b", Phase: "open issues", Error: "first"})
+
+	require.Len(t, ws, 3)
+	assert.Equal(t, "first", ws[0].Error)
+	assert.Equal(t, "open PRs", ws[1].Phase)
+	assert.Equal(t, "org/b", ws[2].Repo)
+}
+
+func TestStaleWarning(t *testing.T) {
+	f := &fakeLister{issues: []*provider.Issue{issue(1, "open", time.Now())}}
+	h := newSyncEngine(t, f)
+
+	sp := provider.SearchParams{Repo: provider.Repo{Organization: "org", Project: "project"}, State: "open"}
+	_, _, err := h.cachedIssues(context.Background(), sp)
+	require.NoError(t, err)
+
+	// Cached data is still shown when it can't be refreshed, with a warning
+	f.err = errors.New("unavailable")
+	sp.NewerThan = time.Now().Add(time.Hour)
+	is, _, err := h.cachedIssues(context.Background(), sp)
+	assert.Equal(t, []int{1}, issueNumbers(is))
+	require.Error(t, err)
+	assert.True(t, isStale(err))
+	assert.ErrorIs(t, err, f.err)
+	assert.Contains(t, err.Error(), "showing stale data")
+
+	f.err = nil
+	_, _, err = h.cachedIssues(context.Background(), sp)
+	assert.False(t, isStale(err))
+}
+
+func TestSearchAnyWarnings(t *testing.T) {
+	h := newSyncEngine(t, &fakeLister{err: errors.New("unavailable")})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Both searches fail, but the PR warnings are not lost to the issue error
+	sp := provider.SearchParams{Repo: provider.Repo{Organization: "org", Project: "project"}, NewerThan: time.Now()}
+	_, _, ws, err := h.SearchAny(ctx, sp)
+	require.Error(t, err)
+
+	phases := map[string]bool{}
+	for _, w := range ws {
+		phases[w.Phase] = true
+	}
+	assert.True(t, phases["open issues"])
+	assert.True(t, phases["open PRs"])
+}
@@ -0,0 +1,275 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Entry describes a stored entry
+type Entry struct {
+	Key   string
+	Size  int64
+	Saved time.Time
+	// Read is when the entry was last read, for backends which store it
+	Read time.Time
+}
+
+// lister is implemented by backends which can enumerate their stored entries
+type lister interface {
+	entries() ([]Entry, error)
+}
+
+// storedReader is implemented by backends which can read stored entries without caching them in memory
+type storedReader interface {
+	lister
+	readStored(key string) (*Blob, error)
+}
+
+// List returns the entries stored within a cache, sorted by key
+func List(c Cacher) ([]Entry, error) {
+	l, ok := c.(lister)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support listing", c)
+	}
+
+	es, err := l.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(es, func(i, j int) bool { return es[i].Key < es[j].Key })
+	return es, nil
+}
+
+// KeyFilter selects cache keys by repository or prefix. An empty filter matches everything.
+type KeyFilter struct {
+	// Repos selects the keys of repositories, such as "kubernetes/minikube"
+	Repos    []string
+	Prefixes []string
+}
+
+// repoName returns the name used within keys for a repository, such as "kubernetes-minikube"
+func repoName(repo string) string {
+	return strings.Replace(strings.Trim(repo, "/"), "/", "-", 1)
+}
+
+// Match returns true if a key should be selected
+func (f KeyFilter) Match(key string) bool {
+	if len(f.Repos) == 0 && len(f.Prefixes) == 0 {
+		return true
+	}
+
+	if repo := keyRepo(key); repo != "" {
+		for _, r := range f.Repos {
+			if repo == repoName(r) {
+				return true
+			}
+		}
+	}
+
+	for _, p := range f.Prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// readable returns a cache which can read stored entries directly
+func readable(c Cacher) (storedReader, error) {
+	sr, ok := c.(storedReader)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support reading stored entries", c)
+	}
+	return sr, nil
+}
+
+// Dump writes the matching entries of a cache to a tar archive of versioned blobs.
+// Entries are read from the backend directly, rather than through the in-memory cache.
+func Dump(c Cacher, w io.Writer, f KeyFilter) (int, error) {
+	sr, err := readable(c)
+	if err != nil {
+		return 0, err
+	}
+
+	es, err := List(c)
+	if err != nil {
+		return 0, fmt.Errorf("list: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	n := 0
+
+	for _, e := range es {
+		if !f.Match(e.Key) {
+			continue
+		}
+
+		bl, err := sr.readStored(e.Key)
+		if err != nil {
+			klog.Warningf("skipping %s: %v", e.Key, err)
+			continue
+		}
+
+		b, err := encodeBlob(bl)
+		if err != nil {
+			return n, fmt.Errorf("encode %s: %w", e.Key, err)
+		}
+
+		hdr := &tar.Header{
+			Name:    e.Key,
+			Mode:    0o644,
+			Size:    int64(len(b)),
+			ModTime: bl.Created,
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return n, fmt.Errorf("header %s: %w", e.Key, err)
+		}
+
+		if _, err := tw.Write(b); err != nil {
+			return n, fmt.Errorf("write %s: %w", e.Key, err)
+		}
+		n++
+	}
+
+	return n, tw.Close()
+}
+
+// Load stores the matching entries of an archive created by Dump into a cache
+func Load(c Cacher, r io.Reader, f KeyFilter) (int, error) {
+	tr := tar.NewReader(r)
+	n := 0
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+
+		if err != nil {
+			return n, fmt.Errorf("next: %w", err)
+		}
+
+		if !f.Match(hdr.Name) {
+			continue
+		}
+
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return n, fmt.Errorf("read %s: %w", hdr.Name, err)
+		}
+
+		bl, _, err := decodeBlob(b)
+		if err != nil {
+			klog.Warningf("skipping %s: %v", hdr.Name, err)
+			continue
+		}
+
+		if err := c.Set(hdr.Name, bl); err != nil {
+			return n, fmt.Errorf("set %s: %w", hdr.Name, err)
+		}
+		n++
+	}
+}
+
+// keyFamilyRe matches what follows the "org-project" portion of the keys written by hubbub, such as
+// "-open-issues", "-closed-prs-within-24.0h" or "-123-timeline". It is anchored to the end of the key,
+// as repository names may themselves contain "-open-" or numbers.
+var keyFamilyRe = regexp.MustCompile(`-(?:(?:open|closed|all)-(?:issues|prs)(?:-within-[0-9.]+h)?|[0-9]+-(?:issue-comments|pr|pr-comments|pr-reviews|timeline))$`)
+
+// keyRepo returns the "org-project" portion of a key, or "" if it is not specific to a repository
+func keyRepo(key string) string {
+	loc := keyFamilyRe.FindStringIndex(key)
+	if loc == nil || loc[0] == 0 {
+		return ""
+	}
+	return key[:loc[0]]
+}
+
+// RepoStats are statistics for the entries of a single repository
+type RepoStats struct {
+	Repo   string
+	Keys   int
+	Bytes  int64
+	Oldest time.Time
+	Newest time.Time
+}
+
+// Stats returns per-repository statistics for the matching entries of a cache.
+// Entry ages are based on when they were saved, or created if the backend does not know.
+func Stats(c Cacher, f KeyFilter) ([]*RepoStats, error) {
+	sr, err := readable(c)
+	if err != nil {
+		return nil, err
+	}
+
+	es, err := List(c)
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
+
+	byRepo := map[string]*RepoStats{}
+	for _, e := range es {
+		if !f.Match(e.Key) {
+			continue
+		}
+
+		repo := keyRepo(e.Key)
+		if repo == "" {
+			repo = e.Key
+		}
+
+		saved := e.Saved
+		if saved.IsZero() {
+			if bl, err := sr.readStored(e.Key); err == nil {
+				saved = bl.Created
+			}
+		}
+
+		rs := byRepo[repo]
+		if rs == nil {
+			rs = &RepoStats{Repo: repo, Oldest: saved, Newest: saved}
+			byRepo[repo] = rs
+		}
+
+		rs.Keys++
+		rs.Bytes += e.Size
+		if saved.Before(rs.Oldest) {
+			rs.Oldest = saved
+		}
+		if saved.After(rs.Newest) {
+			rs.Newest = saved
+		}
+	}
+
+	st := []*RepoStats{}
+	for _, rs := range byRepo {
+		st = append(st, rs)
+	}
+
+	sort.Slice(st, func(i, j int) bool { return st[i].Repo < st[j].Repo })
+	return st, nil
+}
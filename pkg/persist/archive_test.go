@@ -0,0 +1,109 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpLoad(t *testing.T) {
+	src, err := NewSQLite(Config{Path: filepath.Join(t.TempDir(), "cache.db")})
+	require.NoError(t, err)
+	defer src.Close()
+	require.NoError(t, src.Initialize())
+
+	title := "crash on start"
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, k := range []string{"org-a-open-issues", "org-a-12-timeline", "org-b-open-prs", "other-project-7-pr"} {
+		require.NoError(t, src.Set(k, &Blob{Created: created, Issues: []*provider.Issue{{Title: &title}}}))
+	}
+
+	var buf bytes.Buffer
+	// Reopen, so that entries are only in the persistent layer
+	require.NoError(t, src.Initialize())
+
+	n, err := Dump(src, &buf, KeyFilter{Repos: []string{"org/a"}, Prefixes: []string{"org-b-"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// Dumping does not load entries into memory
+	assert.Equal(t, 0, src.memcache.ItemCount())
+
+	dst, err := NewDisk(Config{Path: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, dst.Initialize())
+
+	n, err = Load(dst, bytes.NewReader(buf.Bytes()), KeyFilter{Prefixes: []string{"org-a-"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	bl := dst.Get("org-a-12-timeline", created)
+	require.NotNil(t, bl)
+	assert.Equal(t, title, bl.Issues[0].GetTitle())
+	assert.True(t, bl.Created.Equal(created))
+	assert.Nil(t, dst.Get("org-b-open-prs", time.Time{}))
+
+	st, err := Stats(src, KeyFilter{})
+	require.NoError(t, err)
+	require.Len(t, st, 3)
+	assert.Equal(t, "org-a", st[0].Repo)
+	assert.Equal(t, 2, st[0].Keys)
+	assert.Equal(t, "other-project", st[2].Repo)
+}
+
+func TestKeyRepo(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "kubernetes-minikube-open-issues", want: "kubernetes-minikube"},
+		{key: "kubernetes-minikube-all-prs-within-24.0h", want: "kubernetes-minikube"},
+		{key: "kubernetes-minikube-123-timeline", want: "kubernetes-minikube"},
+		{key: "kubernetes-minikube-123-pr-comments", want: "kubernetes-minikube"},
+		{key: "kubernetes-minikube-tools-7-pr", want: "kubernetes-minikube-tools"},
+		{key: "org-open-source-closed-issues", want: "org-open-source"},
+		{key: "org-project-2-12-issue-comments", want: "org-project-2"},
+		{key: "my-page-collection", want: ""},
+		{key: "open-issues", want: ""},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, keyRepo(tc.key), tc.key)
+	}
+}
+
+func TestKeyFilterRepos(t *testing.T) {
+	f := KeyFilter{Repos: []string{"kubernetes/minikube", "org/open-source"}}
+
+	assert.True(t, f.Match("kubernetes-minikube-open-issues"))
+	assert.True(t, f.Match("org-open-source-12-timeline"))
+	assert.False(t, f.Match("kubernetes-minikube-tools-open-issues"))
+	assert.False(t, f.Match("org-open-12-timeline"))
+	assert.True(t, KeyFilter{}.Match("anything"))
+}
+
+func TestMemoryList(t *testing.T) {
+	c, err := NewMemory(Config{})
+	require.NoError(t, err)
+	_, err = List(c)
+	assert.Error(t, err)
+}
@@ -26,6 +26,14 @@ import (
 	"k8s.io/klog/v2"
 )
 
+var (
+	// cloudMySQLDial connects to a Cloud SQL MySQL instance through the proxy
+	cloudMySQLDial = cmysql.DialCfg
+
+	// cloudPostgresDriver is the database/sql driver which connects to Cloud SQL Postgres instances through the proxy
+	cloudPostgresDriver = "cloudsqlpostgres"
+)
+
 // NewCloudSQL returns a new Google Cloud SQL store (MySQL)
 func NewCloudSQL(cfg Config) (Cacher, error) {
 	// This heuristic may be totally wrong. My apologies.
@@ -51,13 +59,13 @@ func newCloudMySQL(cfg Config) (*MySQL, error) {
 	mcfg.ParseTime = true
 	klog.Infof("mcfg: %#v", mcfg)
 
-	db, err := cmysql.DialCfg(mcfg)
+	db, err := cloudMySQLDial(mcfg)
 	if err != nil {
 		return nil, fmt.Errorf("cloudmysql dialcfg: %w", err)
 	}
 
-	dbx := sqlx.NewDb(db, "mysql")
-	return &MySQL{db: dbx}, nil
+	// The path is not kept, as it includes the password
+	return newMySQL(sqlx.NewDb(db, "mysql"), "", cfg), nil
 }
 
 func newCloudPostgres(cfg Config) (*Postgres, error) {
@@ -67,11 +75,11 @@ func newCloudPostgres(cfg Config) (*Postgres, error) {
 	}
 
 	// See https://github.com/GoogleCloudPlatform/cloudsql-proxy/blob/7e668d9ad0ba579372f5142f149a18c38d14a9d0/proxy/dialers/postgres/hook_test.go#L30
-	dbx, err := sqlx.Open("cloudsqlpostgres", cfg.Path)
+	dbx, err := sqlx.Open(cloudPostgresDriver, cfg.Path)
 	if err != nil {
 		return nil, fmt.Errorf("cloudsqlpostgres open: %w", err)
 	}
 
 	klog.Infof("opened cloudsqlpostgres db at %s", cfg.Path)
-	return &Postgres{db: dbx}, nil
+	return newPostgres(dbx, "", cfg), nil
 }
@@ -0,0 +1,111 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQL is a database/sql driver which accepts every statement, and returns no rows
+type fakeSQL struct {
+	mu    sync.Mutex
+	stmts []string
+}
+
+func (f *fakeSQL) Open(string) (driver.Conn, error) { return &fakeConn{f: f}, nil }
+
+func (f *fakeSQL) executed(prefix string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.stmts {
+		if strings.HasPrefix(strings.TrimSpace(s), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeConn struct{ f *fakeSQL }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.f.mu.Lock()
+	c.f.stmts = append(c.f.stmts, query)
+	c.f.mu.Unlock()
+	return fakeStmt{}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return []string{"v"} }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+var (
+	fakeDriver   = &fakeSQL{}
+	registerFake sync.Once
+)
+
+func TestCloudSQLInitialize(t *testing.T) {
+	registerFake.Do(func() { sql.Register("fakesql", fakeDriver) })
+
+	defer func(dial func(*mysql.Config) (*sql.DB, error), driver string) {
+		cloudMySQLDial, cloudPostgresDriver = dial, driver
+	}(cloudMySQLDial, cloudPostgresDriver)
+	cloudMySQLDial = func(*mysql.Config) (*sql.DB, error) { return sql.Open("fakesql", "") }
+	cloudPostgresDriver = "fakesql"
+
+	tests := []struct {
+		path   string
+		schema string
+	}{
+		{path: "user:password@tcp(project/us-central1/instance)/db", schema: mysqlSchema},
+		{path: "host=project:us-central1:instance user=postgres password=pw", schema: pgSchema},
+	}
+
+	for _, tc := range tests {
+		c, err := NewCloudSQL(Config{Path: tc.path})
+		require.NoError(t, err)
+		require.NoError(t, c.Initialize(), tc.path)
+		assert.True(t, fakeDriver.executed(strings.TrimSpace(tc.schema)), tc.path)
+
+		require.NoError(t, c.Set("org-project-open-issues", &Blob{Created: time.Now()}))
+		assert.NotNil(t, c.Get("org-project-open-issues", time.Time{}))
+		require.NoError(t, c.(io.Closer).Close())
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// compactable is implemented by backends which can list and remove their stored entries
+type compactable interface {
+	lister
+	remove(key string) error
+	lastRead(e Entry) time.Time
+}
+
+// readPersister is implemented by backends which store when entries were last read
+type readPersister interface {
+	flushReads() error
+}
+
+// readFlushInterval is how often read times are persisted, by backends which store them
+const readFlushInterval = time.Minute
+
+// accessLog tracks when entries were last read or written by this process.
+//
+// Backends which store read times use it to buffer reads, so that they may be written in batches
+// rather than on every in-memory hit.
+type accessLog struct {
+	started time.Time
+	reads   sync.Map
+
+	persist func(map[string]time.Time) error
+	flushMu sync.Mutex
+	once    sync.Once
+	done    chan struct{}
+}
+
+func newAccessLog() *accessLog {
+	return &accessLog{started: time.Now(), done: make(chan struct{})}
+}
+
+func (a *accessLog) touch(key string) {
+	a.reads.Store(key, time.Now())
+}
+
+func (a *accessLog) forget(key string) {
+	a.reads.Delete(key)
+}
+
+// lastRead returns when an entry was last used. As reads are not persisted, entries
+// which have not been used by this process are considered read when it started.
+func (a *accessLog) lastRead(key string, saved time.Time) time.Time {
+	if x, ok := a.reads.Load(key); ok {
+		return x.(time.Time)
+	}
+
+	if saved.After(a.started) {
+		return saved
+	}
+	return a.started
+}
+
+// latest returns when an entry was last used, given the read time which was persisted for it
+func (a *accessLog) latest(key string, persisted time.Time) time.Time {
+	if x, ok := a.reads.Load(key); ok && x.(time.Time).After(persisted) {
+		return x.(time.Time)
+	}
+	return persisted
+}
+
+// persistEvery persists buffered reads with fn every interval, until stop is called
+func (a *accessLog) persistEvery(interval time.Duration, fn func(map[string]time.Time) error) {
+	a.once.Do(func() {
+		a.persist = fn
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-a.done:
+					return
+				case <-ticker.C:
+					if err := a.flush(); err != nil {
+						klog.Errorf("persist reads: %v", err)
+					}
+				}
+			}
+		}()
+	})
+}
+
+// flush persists the reads buffered since the last flush. Reads which could not be
+// persisted are kept, to be retried by the next flush.
+func (a *accessLog) flush() error {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	if a.persist == nil {
+		return nil
+	}
+
+	pending := map[string]time.Time{}
+	a.reads.Range(func(k, _ interface{}) bool {
+		if x, ok := a.reads.LoadAndDelete(k); ok {
+			pending[k.(string)] = x.(time.Time)
+		}
+		return true
+	})
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := a.persist(pending); err != nil {
+		for k, t := range pending {
+			a.reads.LoadOrStore(k, t)
+		}
+		return err
+	}
+
+	klog.V(1).Infof("persisted %d reads", len(pending))
+	return nil
+}
+
+// stop stops persisting reads, flushing any which are buffered
+func (a *accessLog) stop() error {
+	select {
+	case <-a.done:
+		return nil
+	default:
+		close(a.done)
+	}
+	return a.flush()
+}
+
+// CompactConfig is compactor configuration
+type CompactConfig struct {
+	// MaxUnread is how long an entry may go without being read before it is removed (0 to disable)
+	MaxUnread time.Duration
+	// MaxBytes is the maximum total size of stored entries, least recently read are removed first (0 to disable)
+	MaxBytes int64
+	// Interval is how often to compact
+	Interval time.Duration
+}
+
+// CompactStats are cumulative compaction statistics
+type CompactStats struct {
+	Runs           int
+	Expired        int
+	Evicted        int
+	BytesReclaimed int64
+
+	Entries int
+	Bytes   int64
+	LastRun time.Time
+}
+
+func (s CompactStats) String() string {
+	return fmt.Sprintf("%d entries (%d bytes): %d expired, %d evicted, %d bytes reclaimed over %d runs",
+		s.Entries, s.Bytes, s.Expired, s.Evicted, s.BytesReclaimed, s.Runs)
+}
+
+// Compactor removes entries which are no longer read, and keeps a cache within its size limit
+type Compactor struct {
+	c     compactable
+	name  string
+	cfg   CompactConfig
+	mu    sync.Mutex
+	stats CompactStats
+}
+
+// NewCompactor returns a compactor for a cache, or an error if the backend does not support compaction
+func NewCompactor(c Cacher, cfg CompactConfig) (*Compactor, error) {
+	cc, ok := c.(compactable)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support compaction", c)
+	}
+
+	if cfg.Interval == 0 {
+		cfg.Interval = time.Hour
+	}
+
+	return &Compactor{c: cc, name: c.String(), cfg: cfg}, nil
+}
+
+// Compact runs a single compaction pass
+func (cp *Compactor) Compact() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	// Reads which are only known to this process must be visible to the listing
+	if rp, ok := cp.c.(readPersister); ok {
+		if err := rp.flushReads(); err != nil {
+			klog.Errorf("%s: flush reads: %v", cp.name, err)
+		}
+	}
+
+	es, err := cp.c.entries()
+	if err != nil {
+		return fmt.Errorf("entries: %w", err)
+	}
+
+	type candidate struct {
+		Entry
+		read time.Time
+	}
+
+	cs := []candidate{}
+	var total int64
+	for _, e := range es {
+		cs = append(cs, candidate{Entry: e, read: cp.c.lastRead(e)})
+		total += e.Size
+	}
+
+	// Least recently read first
+	sort.Slice(cs, func(i, j int) bool { return cs[i].read.Before(cs[j].read) })
+
+	kept := 0
+	for _, e := range cs {
+		expired := cp.cfg.MaxUnread > 0 && time.Since(e.read) > cp.cfg.MaxUnread
+		evicted := !expired && cp.cfg.MaxBytes > 0 && total > cp.cfg.MaxBytes
+
+		if !expired && !evicted {
+			kept++
+			continue
+		}
+
+		if err := cp.c.remove(e.Key); err != nil {
+			klog.Errorf("remove %s: %v", e.Key, err)
+			kept++
+			continue
+		}
+
+		klog.V(1).Infof("compacted %s (%d bytes, last read %s)", e.Key, e.Size, e.read)
+		total -= e.Size
+		cp.stats.BytesReclaimed += e.Size
+		if expired {
+			cp.stats.Expired++
+		} else {
+			cp.stats.Evicted++
+		}
+	}
+
+	cp.stats.Runs++
+	cp.stats.Entries = kept
+	cp.stats.Bytes = total
+	cp.stats.LastRun = time.Now()
+
+	klog.Infof("%s compaction: %s", cp.name, cp.stats)
+	return nil
+}
+
+// Stats returns cumulative compaction statistics
+func (cp *Compactor) Stats() CompactStats {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.stats
+}
+
+// Loop compacts periodically until the context is cancelled
+func (cp *Compactor) Loop(ctx context.Context) {
+	ticker := time.NewTicker(cp.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := cp.Compact(); err != nil {
+			klog.Errorf("%s compaction failed: %v", cp.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAt records when an entry was last read
+type readAt func(t *testing.T, key string, read time.Time)
+
+// compactFixture stores entries of roughly equal size, last read in the order given
+func compactFixture(t *testing.T, c Cacher, setRead readAt, keys ...string) {
+	body := strings.Repeat("x", 4096)
+	now := time.Now()
+
+	for i, k := range keys {
+		require.NoError(t, c.Set(k, &Blob{Issues: []*provider.Issue{{Body: &body}}}))
+		setRead(t, k, now.Add(time.Duration(i-len(keys))*time.Hour))
+	}
+}
+
+// sqlReadAt stores a read time within the persist2 table
+func sqlReadAt(c *SQLite) readAt {
+	return func(t *testing.T, key string, read time.Time) {
+		_, err := c.db.Exec(`UPDATE persist2 SET last_read = ? WHERE k = ?`, read.UTC(), key)
+		require.NoError(t, err)
+	}
+}
+
+func testCompaction(t *testing.T, c Cacher, setRead readAt) {
+	require.NoError(t, c.Initialize())
+	compactFixture(t, c, setRead, "oldest", "older", "old", "new")
+
+	cp, err := NewCompactor(c, CompactConfig{MaxUnread: 150 * time.Minute})
+	require.NoError(t, err)
+	require.NoError(t, cp.Compact())
+
+	st := cp.Stats()
+	assert.Equal(t, 2, st.Expired)
+	assert.Equal(t, 2, st.Entries)
+	assert.Greater(t, st.BytesReclaimed, int64(4096))
+	assert.Nil(t, c.Get("oldest", time.Time{}))
+	assert.NotNil(t, c.Get("old", time.Time{}))
+
+	// Reading "old" makes "new" the least recently read entry
+	cp, err = NewCompactor(c, CompactConfig{MaxBytes: st.Bytes - 1})
+	require.NoError(t, err)
+	require.NoError(t, cp.Compact())
+
+	st = cp.Stats()
+	assert.Equal(t, 1, st.Evicted)
+	assert.Equal(t, 1, st.Entries)
+	assert.Nil(t, c.Get("new", time.Time{}))
+	assert.NotNil(t, c.Get("old", time.Time{}))
+}
+
+func TestDiskCompaction(t *testing.T) {
+	c, err := NewDisk(Config{Path: t.TempDir()})
+	require.NoError(t, err)
+	testCompaction(t, c, func(t *testing.T, key string, read time.Time) {
+		// Pretend the process has been running for a while
+		c.reads.started = time.Now().Add(-48 * time.Hour)
+		c.reads.reads.Store(key, read)
+	})
+}
+
+func TestSQLiteCompaction(t *testing.T) {
+	c, err := NewSQLite(Config{Path: filepath.Join(t.TempDir(), "cache.db")})
+	require.NoError(t, err)
+	defer c.Close()
+	testCompaction(t, c, sqlReadAt(c))
+}
+
+func TestSQLiteReadsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c, err := NewSQLite(Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+	compactFixture(t, c, sqlReadAt(c), "unread", "read")
+	require.NoError(t, c.Close())
+
+	// A read served from memory is persisted when the cache is closed
+	c, err = NewSQLite(Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+	require.NotNil(t, c.Get("read", time.Time{}))
+	require.NotNil(t, c.Get("read", time.Time{}))
+	require.NoError(t, c.Close())
+
+	restarted, err := NewSQLite(Config{Path: path})
+	require.NoError(t, err)
+	defer restarted.Close()
+	require.NoError(t, restarted.Initialize())
+
+	es, err := restarted.entries()
+	require.NoError(t, err)
+	require.Len(t, es, 2)
+	for _, e := range es {
+		if e.Key == "read" {
+			assert.WithinDuration(t, time.Now(), e.Read, time.Minute)
+		}
+	}
+
+	cp, err := NewCompactor(restarted, CompactConfig{MaxUnread: 90 * time.Minute})
+	require.NoError(t, err)
+	require.NoError(t, cp.Compact())
+	assert.Equal(t, 1, cp.Stats().Expired)
+	assert.Nil(t, restarted.Get("unread", time.Time{}))
+	assert.NotNil(t, restarted.Get("read", time.Time{}))
+}
+
+func TestSQLiteReadColumnAdded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c, err := NewSQLite(Config{Path: path})
+	require.NoError(t, err)
+	defer c.Close()
+
+	// A table created by an older release
+	_, err = c.db.Exec(`CREATE TABLE persist2 (id INTEGER PRIMARY KEY AUTOINCREMENT, saved TIMESTAMP, k TEXT NOT NULL UNIQUE, v BLOB)`)
+	require.NoError(t, err)
+	b, err := c.codec.encode(&Blob{})
+	require.NoError(t, err)
+	_, err = c.db.Exec(`INSERT INTO persist2 (k, v, saved) VALUES (?, ?, ?)`, "old", b, time.Now().Add(-time.Hour).UTC())
+	require.NoError(t, err)
+
+	require.NoError(t, c.Initialize())
+	es, err := c.entries()
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, es[0].Saved, es[0].Read)
+
+	require.NotNil(t, c.Get("old", time.Time{}))
+	require.NoError(t, c.flushReads())
+	es, err = c.entries()
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), es[0].Read, time.Minute)
+}
+
+func TestMemoryCompaction(t *testing.T) {
+	c, err := NewMemory(Config{})
+	require.NoError(t, err)
+
+	_, err = NewCompactor(c, CompactConfig{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/persist/persisttest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	persisttest.Run(t, func(t *testing.T) persist.Cacher {
+		c, err := persist.NewMemory(persist.Config{})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		return c
+	}, persisttest.Options{})
+}
+
+func TestDiskConformance(t *testing.T) {
+	dir := t.TempDir()
+	persisttest.Run(t, func(t *testing.T) persist.Cacher {
+		c, err := persist.NewDisk(persist.Config{Path: dir})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		return c
+	}, persisttest.Options{Persistent: true})
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	persisttest.Run(t, func(t *testing.T) persist.Cacher {
+		c, err := persist.NewSQLite(persist.Config{Path: path})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}, persisttest.Options{Persistent: true})
+}
+
+// The server backed SQL stores require a running database, which CI provides. Locally, for example:
+//
+//	PERSISTTEST_MYSQL="root:rootz@tcp(127.0.0.1:3306)/teaparty" go test ./pkg/persist/
+func TestMySQLConformance(t *testing.T) {
+	path := os.Getenv("PERSISTTEST_MYSQL")
+	if path == "" {
+		t.Skip("PERSISTTEST_MYSQL is unset")
+	}
+
+	persisttest.Run(t, func(t *testing.T) persist.Cacher {
+		c, err := persist.NewMySQL(persist.Config{Path: path})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		return c
+	}, persisttest.Options{Persistent: true, WriteDelay: 5 * time.Second})
+}
+
+// As with MySQL, this requires a running database, for example:
+//
+//	PERSISTTEST_POSTGRES="dbname=tp sslmode=disable" go test ./pkg/persist/
+func TestPostgresConformance(t *testing.T) {
+	path := os.Getenv("PERSISTTEST_POSTGRES")
+	if path == "" {
+		t.Skip("PERSISTTEST_POSTGRES is unset")
+	}
+
+	persisttest.Run(t, func(t *testing.T) persist.Cacher {
+		c, err := persist.NewPostgres(persist.Config{Path: path})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		return c
+	}, persisttest.Options{Persistent: true})
+}
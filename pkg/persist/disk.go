@@ -16,8 +16,6 @@
 package persist
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -33,11 +31,15 @@ type Disk struct {
 	subdir   string
 	memcache *cache.Cache
 	dv       *diskv.Diskv
+	migrated *migrationTally
+	reads    *accessLog
+	codec    codec
+	metrics  *cacheStats
 }
 
 // NewDisk returns a new disk cache
 func NewDisk(cfg Config) (*Disk, error) {
-	return &Disk{path: cfg.Path, subdir: cfg.Program}, nil
+	return &Disk{path: cfg.Path, subdir: cfg.Program, codec: newCodec(cfg), metrics: newCacheStats()}, nil
 }
 
 func (d *Disk) String() string {
@@ -60,33 +62,63 @@ func (d *Disk) Initialize() error {
 	klog.Infof("cache dir is %s", d.path)
 
 	d.memcache = createMem()
+	d.reads = newAccessLog()
 	d.dv = diskv.New(diskv.Options{
 		BasePath:     d.path,
 		CacheSizeMax: 1024 * 1024 * 1024,
 	})
 
+	d.migrated = &migrationTally{}
 	return nil
 }
 
+// MigrationReport returns what happened to the stored entries read since Initialize
+func (d *Disk) MigrationReport() MigrationReport {
+	return d.migrated.report()
+}
+
 // Set stores a thing into memory
 func (d *Disk) Set(key string, bl *Blob) error {
 	setMem(d.memcache, key, bl)
-	var bs bytes.Buffer
-	enc := gob.NewEncoder(&bs)
-	err := enc.Encode(bl)
+	return d.store(key, bl)
+}
+
+// Store writes a thing to disk, without keeping it in memory
+func (d *Disk) Store(key string, bl *Blob) error {
+	// An older copy in memory would otherwise be read instead
+	d.memcache.Delete(key)
+	return d.store(key, bl)
+}
+
+// store writes a thing to disk
+func (d *Disk) store(key string, bl *Blob) error {
+	if bl.Created.IsZero() {
+		bl.Created = time.Now()
+	}
+
+	d.reads.touch(key)
+	b, err := d.codec.encode(bl)
 	if err != nil {
-		return fmt.Errorf("encode: %v", err)
+		return err
 	}
+	d.metrics.stored(key, len(b))
 
-	return d.dv.Write(key, bs.Bytes())
+	return d.dv.Write(key, b)
 }
 
 // Get returns a thing older than a timestamp
 func (d *Disk) Get(key string, t time.Time) *Blob {
-	if b := getMem(d.memcache, key, t); b != nil {
+	b, res := getMem(d.memcache, key, t)
+	d.metrics.record(key, MemoryLayer, res)
+	if b != nil {
+		d.reads.touch(key)
 		return b
 	}
 
+	start := time.Now()
+	res, size := Miss, 0
+	defer func() { d.metrics.persistent(key, res, time.Since(start), size) }()
+
 	if !d.dv.Has(key) {
 		klog.Warningf("%s is a complete cache miss", key)
 		return nil
@@ -94,25 +126,77 @@ func (d *Disk) Get(key string, t time.Time) *Blob {
 
 	klog.Warningf("%s was not in memory, resorting to disk cache", key)
 
-	var bl Blob
 	val, err := d.dv.Read(key)
 	if err != nil {
 		klog.Errorf("disk read failed for %q: %v", key, err)
 		return nil
 	}
 
-	enc := gob.NewDecoder(bytes.NewBuffer(val))
-	err = enc.Decode(&bl)
+	bl, outcome, err := d.codec.decode(val)
+	d.migrated.add(outcome)
 	if err != nil {
 		klog.Errorf("decode failed for %q: %v", key, err)
+		// Entries which can no longer be decoded are removed, so that they are not read again
+		if err := d.remove(key); err != nil {
+			klog.Errorf("remove %s: %v", key, err)
+		}
 		return nil
 	}
 
 	if bl.Created.Before(t) {
 		klog.Warningf("found %s on disk, but it was older than %s", key, t)
+		res = Stale
 		return nil
 	}
 
-	setMem(d.memcache, key, &bl)
-	return &bl
+	res, size = Hit, len(val)
+	setMem(d.memcache, key, bl)
+	d.reads.touch(key)
+
+	// Rewrite entries which were migrated, or encrypted with an older key
+	if outcome == Migrated {
+		if err := d.Set(key, bl); err != nil {
+			klog.Errorf("rewrite %s: %v", key, err)
+		}
+	}
+	return bl
+}
+
+func (d *Disk) entries() ([]Entry, error) {
+	cancel := make(chan struct{})
+	defer close(cancel)
+
+	es := []Entry{}
+	for key := range d.dv.Keys(cancel) {
+		fi, err := os.Stat(filepath.Join(d.path, key))
+		if err != nil {
+			return nil, fmt.Errorf("stat: %w", err)
+		}
+		es = append(es, Entry{Key: key, Size: fi.Size(), Saved: fi.ModTime()})
+	}
+	return es, nil
+}
+
+func (d *Disk) readStored(key string) (*Blob, error) {
+	val, err := d.dv.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	bl, _, err := d.codec.decode(val)
+	return bl, err
+}
+
+func (d *Disk) remove(key string) error {
+	d.memcache.Delete(key)
+	d.reads.forget(key)
+	return d.dv.Erase(key)
+}
+
+func (d *Disk) lastRead(e Entry) time.Time {
+	return d.reads.lastRead(e.Key, e.Saved)
+}
+
+func (d *Disk) stats() *cacheStats {
+	return d.metrics
 }
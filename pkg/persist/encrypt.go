@@ -0,0 +1,292 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// sealMagic prefixes every encrypted blob
+var sealMagic = []byte("TPSEAL\x00")
+
+// dataKeyBytes is the size of the AES-256 keys which blobs are encrypted with
+const dataKeyBytes = 32
+
+// KeyProvider supplies the key encryption keys which protect stored blobs. Each blob is encrypted
+// with a data key, which is itself encrypted ("wrapped") by the provider, so implementations may
+// keep keys locally or call out to a key management service.
+type KeyProvider interface {
+	// Primary returns the ID of the key which new blobs are encrypted with
+	Primary() string
+	// Wrap encrypts a data key with the named key
+	Wrap(id string, dek []byte) ([]byte, error)
+	// Unwrap decrypts a data key which was encrypted with the named key
+	Unwrap(id string, wrapped []byte) ([]byte, error)
+}
+
+// KeyFile is a KeyProvider for keys stored within a local file
+type KeyFile struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyFile reads keys from a file. Each line contains a key ID and a base64 encoded 32-byte key,
+// separated by whitespace. The first key is the primary: to rotate keys, add a new key to the top
+// of the file, and keep the older keys around until every blob has been re-encrypted.
+func NewKeyFile(path string) (*KeyFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	kf := &KeyFile{keys: map[string]cipher.AEAD{}}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a key ID and key", path, n)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: decode: %w", path, n, err)
+		}
+
+		if len(key) != dataKeyBytes {
+			return nil, fmt.Errorf("%s:%d: key is %d bytes, expected %d", path, n, len(key), dataKeyBytes)
+		}
+
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+
+		if _, ok := kf.keys[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key ID %q", path, n, fields[0])
+		}
+
+		if kf.primary == "" {
+			kf.primary = fields[0]
+		}
+		kf.keys[fields[0]] = aead
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	if kf.primary == "" {
+		return nil, fmt.Errorf("%s: no keys found", path)
+	}
+
+	return kf, nil
+}
+
+// Primary returns the ID of the first key in the file
+func (kf *KeyFile) Primary() string {
+	return kf.primary
+}
+
+// Wrap encrypts a data key
+func (kf *KeyFile) Wrap(id string, dek []byte) ([]byte, error) {
+	aead, ok := kf.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", id)
+	}
+	return sealGCM(aead, dek, []byte(id))
+}
+
+// Unwrap decrypts a data key
+func (kf *KeyFile) Unwrap(id string, wrapped []byte) ([]byte, error) {
+	aead, ok := kf.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", id)
+	}
+	return openGCM(aead, wrapped, []byte(id))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealGCM encrypts plaintext, returning the nonce followed by the ciphertext
+func sealGCM(aead cipher.AEAD, plain []byte, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plain, ad), nil
+}
+
+func openGCM(aead cipher.AEAD, data []byte, ad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], ad)
+}
+
+// sealer encrypts blobs with a data key, which is generated once per process and primary key
+type sealer struct {
+	keys KeyProvider
+
+	mu      sync.Mutex
+	primary string
+	dek     cipher.AEAD
+	wrapped []byte
+
+	// data keys which have already been unwrapped, by wrapped key
+	unwrapped map[string]cipher.AEAD
+}
+
+func newSealer(keys KeyProvider) *sealer {
+	return &sealer{keys: keys, unwrapped: map[string]cipher.AEAD{}}
+}
+
+// dataKey returns the data key for new blobs, generating one if the primary key has changed
+func (s *sealer) dataKey() (string, cipher.AEAD, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.keys.Primary()
+	if id == s.primary && s.dek != nil {
+		return s.primary, s.dek, s.wrapped, nil
+	}
+
+	dek := make([]byte, dataKeyBytes)
+	if _, err := rand.Read(dek); err != nil {
+		return "", nil, nil, fmt.Errorf("data key: %w", err)
+	}
+
+	wrapped, err := s.keys.Wrap(id, dek)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("wrap: %w", err)
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	s.primary, s.dek, s.wrapped = id, aead, wrapped
+	s.unwrapped[id+"/"+string(wrapped)] = aead
+	return id, aead, wrapped, nil
+}
+
+func (s *sealer) unwrap(id string, wrapped []byte) (cipher.AEAD, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ck := id + "/" + string(wrapped)
+	if aead, ok := s.unwrapped[ck]; ok {
+		return aead, nil
+	}
+
+	dek, err := s.keys.Unwrap(id, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap with %q: %w", id, err)
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	s.unwrapped[ck] = aead
+	return aead, nil
+}
+
+func appendBytes(b *bytes.Buffer, data []byte) {
+	v := make([]byte, binary.MaxVarintLen64)
+	b.Write(v[:binary.PutUvarint(v, uint64(len(data)))])
+	b.Write(data)
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	l, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < l {
+		return nil, nil, fmt.Errorf("truncated header")
+	}
+	return data[n : n+int(l)], data[n+int(l):], nil
+}
+
+// seal encrypts a blob envelope. The header, which holds the key ID and wrapped data key,
+// is authenticated along with the ciphertext.
+func (s *sealer) seal(plain []byte) ([]byte, error) {
+	id, aead, wrapped, err := s.dataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := new(bytes.Buffer)
+	hdr.Write(sealMagic)
+	appendBytes(hdr, []byte(id))
+	appendBytes(hdr, wrapped)
+
+	ct, err := sealGCM(aead, plain, hdr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return append(hdr.Bytes(), ct...), nil
+}
+
+// open decrypts a sealed blob, returning the envelope and the ID of the key it was encrypted with
+func (s *sealer) open(data []byte) ([]byte, string, error) {
+	rest := data[len(sealMagic):]
+	id, rest, err := readBytes(rest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	wrapped, rest, err := readBytes(rest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	aead, err := s.unwrap(string(id), wrapped)
+	if err != nil {
+		return nil, string(id), err
+	}
+
+	plain, err := openGCM(aead, rest, data[:len(data)-len(rest)])
+	if err != nil {
+		return nil, string(id), fmt.Errorf("decrypt: %w", err)
+	}
+	return plain, string(id), nil
+}
+
+// isSealed returns true if a stored blob is encrypted
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealMagic)
+}
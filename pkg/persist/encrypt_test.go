@@ -0,0 +1,175 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyLine returns a key file line for a random key
+func keyLine(t *testing.T, id string) string {
+	k := make([]byte, dataKeyBytes)
+	_, err := rand.Read(k)
+	require.NoError(t, err)
+	return fmt.Sprintf("%s %s", id, base64.StdEncoding.EncodeToString(k))
+}
+
+// writeKeyFile writes the given key lines to a file, the first being primary
+func writeKeyFile(t *testing.T, path string, lines ...string) *KeyFile {
+	content := "# test keys\n" + strings.Join(lines, "\n")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	kf, err := NewKeyFile(path)
+	require.NoError(t, err)
+	return kf
+}
+
+// sealedWith returns the ID of the key a stored blob is encrypted with
+func sealedWith(t *testing.T, data []byte) string {
+	require.True(t, isSealed(data))
+	id, _, err := readBytes(data[len(sealMagic):])
+	require.NoError(t, err)
+	return string(id)
+}
+
+func storedValue(t *testing.T, c *SQLite, key string) []byte {
+	var v []byte
+	require.NoError(t, c.db.Get(&v, `SELECT v FROM persist2 WHERE k = ?`, key))
+	return v
+}
+
+func TestNewKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	kf := writeKeyFile(t, filepath.Join(dir, "keys"), keyLine(t, "2024"), keyLine(t, "2023"))
+	assert.Equal(t, "2024", kf.Primary())
+
+	wrapped, err := kf.Wrap("2023", []byte("data key"))
+	require.NoError(t, err)
+	dek, err := kf.Unwrap("2023", wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, "data key", string(dek))
+
+	_, err = kf.Unwrap("2024", wrapped)
+	assert.Error(t, err)
+
+	for desc, content := range map[string]string{
+		"empty":     "# nothing here\n",
+		"short key": "a " + base64.StdEncoding.EncodeToString([]byte("short")),
+		"no key":    "a",
+		"duplicate": fmt.Sprintf("a %[1]s\na %[1]s", base64.StdEncoding.EncodeToString(make([]byte, dataKeyBytes))),
+	} {
+		path := filepath.Join(dir, "bad")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := NewKeyFile(path)
+		assert.Error(t, err, desc)
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	keys := writeKeyFile(t, filepath.Join(dir, "keys"), keyLine(t, "a"))
+	path := filepath.Join(dir, "cache.db")
+
+	c, err := NewSQLite(Config{Path: path, Keys: keys})
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Initialize())
+
+	body := "private issue body"
+	require.NoError(t, c.Set("issue", &Blob{Issues: []*provider.Issue{{Body: &body}}}))
+
+	stored := storedValue(t, c, "issue")
+	assert.Equal(t, "a", sealedWith(t, stored))
+	assert.False(t, bytes.Contains(stored, []byte(body)))
+
+	// Starting again with the same keys reads the entry back
+	require.NoError(t, c.Initialize())
+	bl := c.Get("issue", time.Time{})
+	require.NotNil(t, bl)
+	assert.Equal(t, body, bl.Issues[0].GetBody())
+	assert.Equal(t, MigrationReport{Kept: 1}, c.MigrationReport())
+
+	// Tampering is detected
+	stored[len(stored)-1] ^= 0xff
+	_, _, err = c.codec.decode(stored)
+	assert.Error(t, err)
+
+	// Without keys, encrypted entries can not be used
+	plain, err := NewSQLite(Config{Path: path})
+	require.NoError(t, err)
+	defer plain.Close()
+	require.NoError(t, plain.Initialize())
+	assert.Nil(t, plain.Get("issue", time.Time{}))
+	assert.Equal(t, MigrationReport{Dropped: 1}, plain.MigrationReport())
+
+	var n int
+	require.NoError(t, plain.db.Get(&n, `SELECT COUNT(*) FROM persist2 WHERE k = ?`, "issue"))
+	assert.Equal(t, 0, n)
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.db")
+
+	// An unencrypted cache is encrypted as it is read once keys are configured
+	plain, err := NewSQLite(Config{Path: path})
+	require.NoError(t, err)
+	defer plain.Close()
+	require.NoError(t, plain.Initialize())
+	require.NoError(t, plain.Set("plain", &Blob{}))
+
+	oldKey := keyLine(t, "old")
+	old, err := NewSQLite(Config{Path: path, Keys: writeKeyFile(t, filepath.Join(dir, "old"), oldKey)})
+	require.NoError(t, err)
+	defer old.Close()
+	require.NoError(t, old.Initialize())
+	assert.False(t, isSealed(storedValue(t, old, "plain")))
+	require.NotNil(t, old.Get("plain", time.Time{}))
+	assert.Equal(t, MigrationReport{Migrated: 1}, old.MigrationReport())
+	assert.Equal(t, "old", sealedWith(t, storedValue(t, old, "plain")))
+
+	// Rotate: the new key file keeps the old key for decryption
+	rotated := writeKeyFile(t, filepath.Join(dir, "rotated"), keyLine(t, "new"), oldKey)
+	assert.Equal(t, "new", rotated.Primary())
+
+	c, err := NewSQLite(Config{Path: path, Keys: rotated})
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Initialize())
+
+	// Entries written with the old key are re-encrypted when read
+	require.NoError(t, old.Set("lazy", &Blob{}))
+	assert.Equal(t, "old", sealedWith(t, storedValue(t, c, "lazy")))
+
+	require.NotNil(t, c.Get("lazy", time.Time{}))
+	assert.Equal(t, "new", sealedWith(t, storedValue(t, c, "lazy")))
+
+	// Entries which have not been read keep their old key
+	assert.Equal(t, "old", sealedWith(t, storedValue(t, c, "plain")))
+	require.NotNil(t, c.Get("plain", time.Time{}))
+	assert.Equal(t, "new", sealedWith(t, storedValue(t, c, "plain")))
+	assert.Equal(t, MigrationReport{Migrated: 2}, c.MigrationReport())
+}
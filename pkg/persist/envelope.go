@@ -0,0 +1,254 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"sync"
+)
+
+const (
+	// LegacyBlobVersion is the version of blobs stored before the envelope existed
+	LegacyBlobVersion = 1
+
+	// BlobVersion is the current version of the Blob schema. Bump it, and register a
+	// migration from the previous version, whenever a change breaks decoding of old data.
+	BlobVersion = 2
+)
+
+// envelopeMagic prefixes every versioned blob. Legacy blobs are plain gob streams, which never start with it.
+var envelopeMagic = []byte("TPBLOB\x00")
+
+// Migration converts the payload of a blob from one version into the next. The payload is
+// the blob alone, without its envelope, in the encoding it was stored with.
+type Migration func(payload []byte, enc Encoding) ([]byte, error)
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = map[int]Migration{
+		// The envelope was added without changing the payload
+		LegacyBlobVersion: func(payload []byte, _ Encoding) ([]byte, error) { return payload, nil },
+	}
+)
+
+// RegisterMigration registers a migration from version "from" to version "from+1"
+func RegisterMigration(from int, m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	migrations[from] = m
+}
+
+// Outcome is what happened to a stored entry when it was decoded
+type Outcome int
+
+const (
+	// Kept entries were already at the current version
+	Kept Outcome = iota
+	// Migrated entries were converted from an older version
+	Migrated
+	// Dropped entries could not be used, and should be removed
+	Dropped
+)
+
+// MigrationReport summarizes what happened to the stored entries of a Cacher
+type MigrationReport struct {
+	Kept     int
+	Migrated int
+	Dropped  int
+}
+
+func (r *MigrationReport) add(o Outcome) {
+	switch o {
+	case Kept:
+		r.Kept++
+	case Migrated:
+		r.Migrated++
+	case Dropped:
+		r.Dropped++
+	}
+}
+
+func (r MigrationReport) String() string {
+	return fmt.Sprintf("%d kept, %d migrated, %d dropped", r.Kept, r.Migrated, r.Dropped)
+}
+
+// migrationTally counts what happened to stored entries as they were read.
+//
+// Entries are migrated lazily: rewriting every entry at startup would delay it, and rewrite
+// the entire cache on the first deployment of a new blob version.
+type migrationTally struct {
+	mu sync.Mutex
+	r  MigrationReport
+}
+
+func (t *migrationTally) add(o Outcome) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.r.add(o)
+}
+
+func (t *migrationTally) report() MigrationReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.r
+}
+
+// encodeBlob returns the versioned envelope for a blob
+func encodeBlob(bl *Blob) ([]byte, error) {
+	b := new(bytes.Buffer)
+	b.Write(envelopeMagic)
+
+	v := make([]byte, binary.MaxVarintLen64)
+	b.Write(v[:binary.PutUvarint(v, BlobVersion)])
+
+	if err := gob.NewEncoder(b).Encode(bl); err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// unwrap returns the version and payload of a stored blob
+func unwrap(data []byte) (int, []byte, error) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		return LegacyBlobVersion, data, nil
+	}
+
+	rest := data[len(envelopeMagic):]
+	v, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("invalid envelope version")
+	}
+	return int(v), rest[n:], nil
+}
+
+// decodeBlob decodes a stored blob, migrating it to the current version if necessary
+func decodeBlob(data []byte) (*Blob, Outcome, error) {
+	version, payload, err := unwrap(data)
+	if err != nil {
+		return nil, Dropped, err
+	}
+
+	payload, outcome, err := migratePayload(version, payload, Gob)
+	if err != nil {
+		return nil, Dropped, err
+	}
+
+	var bl Blob
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&bl); err != nil {
+		return nil, Dropped, fmt.Errorf("decode version %d: %w", version, err)
+	}
+
+	return &bl, outcome, nil
+}
+
+// migratePayload runs the registered migrations from a version up to the current one
+func migratePayload(version int, payload []byte, enc Encoding) ([]byte, Outcome, error) {
+	if version > BlobVersion {
+		return nil, Dropped, fmt.Errorf("blob version %d is newer than %d", version, BlobVersion)
+	}
+
+	outcome := Kept
+	for v := version; v < BlobVersion; v++ {
+		migrationsMu.RLock()
+		m := migrations[v]
+		migrationsMu.RUnlock()
+
+		if m == nil {
+			return nil, Dropped, fmt.Errorf("no migration from blob version %d", v)
+		}
+
+		var err error
+		payload, err = m(payload, enc)
+		if err != nil {
+			return nil, Dropped, fmt.Errorf("migrate from version %d: %w", v, err)
+		}
+		outcome = Migrated
+	}
+	return payload, outcome, nil
+}
+
+// codec converts blobs to and from the form they are stored in by a backend
+type codec struct {
+	format Format
+
+	// sealer encrypts stored blobs, or nil if encryption is disabled
+	sealer *sealer
+}
+
+func newCodec(cfg Config) codec {
+	c := codec{format: cfg.Format}
+	if c.format.Encoding == "" {
+		c.format = DefaultFormat
+	}
+
+	if cfg.Keys != nil {
+		c.sealer = newSealer(cfg.Keys)
+	}
+	return c
+}
+
+// encode returns the stored form of a blob
+func (c codec) encode(bl *Blob) ([]byte, error) {
+	b, err := serialize(bl, c.format)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.sealer == nil {
+		return b, nil
+	}
+
+	sealed, err := c.sealer.seal(b)
+	if err != nil {
+		return nil, fmt.Errorf("seal: %w", err)
+	}
+	return sealed, nil
+}
+
+// decode decodes a stored blob. Blobs which are not stored in the configured format, or not
+// encrypted with the primary key, are reported as Migrated so that they are rewritten.
+func (c codec) decode(data []byte) (*Blob, Outcome, error) {
+	current := c.sealer == nil
+
+	if isSealed(data) {
+		if c.sealer == nil {
+			return nil, Dropped, fmt.Errorf("blob is encrypted, but no keys are configured")
+		}
+
+		plain, id, err := c.sealer.open(data)
+		if err != nil {
+			return nil, Dropped, err
+		}
+		data = plain
+		current = id == c.sealer.keys.Primary()
+	}
+
+	bl, outcome, f, err := deserialize(data)
+	if err != nil {
+		return nil, outcome, err
+	}
+
+	if f != c.format {
+		current = false
+	}
+
+	if !current {
+		outcome = Migrated
+	}
+	return bl, outcome, nil
+}
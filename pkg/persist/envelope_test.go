@@ -0,0 +1,126 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func legacyBlob(t *testing.T, title string) []byte {
+	b := new(bytes.Buffer)
+	require.NoError(t, gob.NewEncoder(b).Encode(&Blob{Created: time.Now(), Issues: []*provider.Issue{{Title: &title}}}))
+	return b.Bytes()
+}
+
+func futureBlob(t *testing.T) []byte {
+	b := new(bytes.Buffer)
+	b.Write(envelopeMagic)
+	v := make([]byte, binary.MaxVarintLen64)
+	b.Write(v[:binary.PutUvarint(v, BlobVersion+1)])
+	return b.Bytes()
+}
+
+func TestDecodeBlob(t *testing.T) {
+	title := "current"
+	current, err := encodeBlob(&Blob{Issues: []*provider.Issue{{Title: &title}}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc    string
+		in      []byte
+		outcome Outcome
+		title   string
+	}{
+		{desc: "current", in: current, outcome: Kept, title: "current"},
+		{desc: "legacy", in: legacyBlob(t, "legacy"), outcome: Migrated, title: "legacy"},
+		{desc: "future", in: futureBlob(t), outcome: Dropped},
+		{desc: "garbage", in: []byte("garbage"), outcome: Dropped},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			bl, outcome, err := decodeBlob(tc.in)
+			assert.Equal(t, tc.outcome, outcome)
+			if tc.outcome == Dropped {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.title, bl.Issues[0].GetTitle())
+		})
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	old := migrations[LegacyBlobVersion]
+	defer RegisterMigration(LegacyBlobVersion, old)
+
+	RegisterMigration(LegacyBlobVersion, func(payload []byte, _ Encoding) ([]byte, error) {
+		return nil, fmt.Errorf("field renamed")
+	})
+
+	_, outcome, err := decodeBlob(legacyBlob(t, "legacy"))
+	assert.Equal(t, Dropped, outcome)
+	assert.Error(t, err)
+}
+
+func TestLazyMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c, err := NewSQLite(Config{Path: path})
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Initialize())
+
+	require.NoError(t, c.Set("current", &Blob{}))
+	for k, v := range map[string][]byte{"legacy": legacyBlob(t, "legacy"), "future": futureBlob(t), "garbage": []byte("garbage")} {
+		_, err := c.db.Exec(`INSERT INTO persist2 (k, v, saved) VALUES (?, ?, ?)`, k, v, time.Now())
+		require.NoError(t, err)
+	}
+
+	// Nothing is read or rewritten at startup
+	require.NoError(t, c.Initialize())
+	assert.Equal(t, MigrationReport{}, c.MigrationReport())
+	es, err := c.entries()
+	require.NoError(t, err)
+	assert.Len(t, es, 4)
+
+	assert.NotNil(t, c.Get("current", time.Time{}))
+	bl := c.Get("legacy", time.Time{})
+	require.NotNil(t, bl)
+	assert.Equal(t, "legacy", bl.Issues[0].GetTitle())
+	assert.Nil(t, c.Get("future", time.Time{}))
+	assert.Nil(t, c.Get("garbage", time.Time{}))
+	assert.Equal(t, MigrationReport{Kept: 1, Migrated: 1, Dropped: 2}, c.MigrationReport())
+
+	// Entries which could not be decoded are removed
+	es, err = c.entries()
+	require.NoError(t, err)
+	assert.Len(t, es, 2)
+
+	// Migrated entries are rewritten, so they are only migrated once
+	require.NoError(t, c.Initialize())
+	require.NotNil(t, c.Get("legacy", time.Time{}))
+	assert.Equal(t, MigrationReport{Kept: 1}, c.MigrationReport())
+}
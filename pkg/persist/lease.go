@@ -0,0 +1,92 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Leaser is implemented by backends which can be shared between replicas, allowing them to elect a leader.
+//
+// Leases expire based on the clock of the replica which took them, so replica clocks are assumed
+// to be within a few seconds of each other.
+type Leaser interface {
+	// AcquireLease takes or renews a named lease for holder until ttl elapses, returning whoever now holds it
+	AcquireLease(name string, holder string, ttl time.Duration) (string, error)
+	// ReleaseLease gives up a named lease, if holder has it
+	ReleaseLease(name string, holder string) error
+}
+
+// leaseQueries are the dialect specific statements used to manage the leases table
+type leaseQueries struct {
+	// ensure creates an expired lease if there is none. Args: name
+	ensure string
+	// claim takes a lease which is expired or already held by the holder. Args: holder, expires, name, holder, now
+	claim string
+	// holder returns who holds a lease. Args: name
+	holder string
+	// release expires a lease held by the holder. Args: name, holder
+	release string
+}
+
+var pgLeaseQueries = leaseQueries{
+	ensure:  `INSERT INTO leases (name, holder, expires) VALUES ($1, '', 0) ON CONFLICT (name) DO NOTHING`,
+	claim:   `UPDATE leases SET holder = $1, expires = $2 WHERE name = $3 AND (holder = $4 OR expires < $5)`,
+	holder:  `SELECT holder FROM leases WHERE name = $1`,
+	release: `UPDATE leases SET holder = '', expires = 0 WHERE name = $1 AND holder = $2`,
+}
+
+var mysqlLeaseQueries = leaseQueries{
+	ensure:  `INSERT IGNORE INTO leases (name, holder, expires) VALUES (?, '', 0)`,
+	claim:   `UPDATE leases SET holder = ?, expires = ? WHERE name = ? AND (holder = ? OR expires < ?)`,
+	holder:  `SELECT holder FROM leases WHERE name = ?`,
+	release: `UPDATE leases SET holder = '', expires = 0 WHERE name = ? AND holder = ?`,
+}
+
+var sqliteLeaseQueries = leaseQueries{
+	ensure:  `INSERT OR IGNORE INTO leases (name, holder, expires) VALUES (?, '', 0)`,
+	claim:   mysqlLeaseQueries.claim,
+	holder:  mysqlLeaseQueries.holder,
+	release: mysqlLeaseQueries.release,
+}
+
+// acquireSQLLease takes or renews a lease within the leases table, returning whoever holds it.
+//
+// The claim is a single conditional UPDATE, so concurrent replicas cannot both take an expired lease.
+func acquireSQLLease(db *sqlx.DB, q leaseQueries, name string, holder string, ttl time.Duration) (string, error) {
+	if _, err := db.Exec(q.ensure, name); err != nil {
+		return "", fmt.Errorf("ensure: %w", err)
+	}
+
+	now := time.Now()
+	if _, err := db.Exec(q.claim, holder, now.Add(ttl).UnixNano(), name, holder, now.UnixNano()); err != nil {
+		return "", fmt.Errorf("claim: %w", err)
+	}
+
+	var current string
+	if err := db.Get(&current, q.holder, name); err != nil {
+		return "", fmt.Errorf("holder: %w", err)
+	}
+	return current, nil
+}
+
+// releaseSQLLease expires a lease within the leases table, if holder has it
+func releaseSQLLease(db *sqlx.DB, q leaseQueries, name string, holder string) error {
+	_, err := db.Exec(q.release, name, holder)
+	return err
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLLease(t *testing.T) {
+	c, err := NewSQLite(Config{Path: filepath.Join(t.TempDir(), "cache.db")})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+	defer c.Close()
+
+	var l Leaser = c
+
+	got, err := l.AcquireLease("updater", "a", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "a", got)
+
+	// Held leases can be renewed, but not taken
+	got, err = l.AcquireLease("updater", "b", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "a", got)
+
+	got, err = l.AcquireLease("updater", "a", time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "a", got)
+
+	// Expired leases can be taken
+	time.Sleep(5 * time.Millisecond)
+	got, err = l.AcquireLease("updater", "b", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "b", got)
+
+	// Released leases can be taken immediately, but only by releasing them as their holder
+	require.NoError(t, l.ReleaseLease("updater", "a"))
+	got, err = l.AcquireLease("updater", "a", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "b", got)
+
+	require.NoError(t, l.ReleaseLease("updater", "b"))
+	got, err = l.AcquireLease("updater", "a", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "a", got)
+
+	// Leases are independent
+	got, err = l.AcquireLease("other", "b", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "b", got)
+}
@@ -28,12 +28,13 @@ var (
 )
 
 type Memory struct {
-	cache *cache.Cache
+	cache   *cache.Cache
+	metrics *cacheStats
 }
 
 // NewMemory returns a new Memory cache
 func NewMemory(cfg Config) (*Memory, error) {
-	return &Memory{}, nil
+	return &Memory{metrics: newCacheStats()}, nil
 }
 
 func (m *Memory) String() string {
@@ -53,7 +54,13 @@ func (m *Memory) Set(key string, t *Blob) error {
 
 // Get returns a thing older than a timestamp
 func (m *Memory) Get(key string, t time.Time) *Blob {
-	return getMem(m.cache, key, t)
+	b, r := getMem(m.cache, key, t)
+	m.metrics.record(key, MemoryLayer, r)
+	return b
+}
+
+func (m *Memory) stats() *cacheStats {
+	return m.metrics
 }
 
 func createMem() *cache.Cache {
@@ -69,12 +76,13 @@ func setMem(c *cache.Cache, key string, th *Blob) {
 	c.Set(key, th, 0)
 }
 
-func getMem(c *cache.Cache, key string, t time.Time) *Blob {
+// getMem returns a thing from the in-memory cache, and whether it was a hit, miss, or stale
+func getMem(c *cache.Cache, key string, t time.Time) (*Blob, Lookup) {
 	x, ok := c.Get(key)
 
 	if !ok {
 		klog.V(1).Infof("%s is not within in-memory cache!", key)
-		return nil
+		return nil, Miss
 	}
 
 	th, ok := x.(*Blob)
@@ -87,8 +95,8 @@ func getMem(c *cache.Cache, key string, t time.Time) *Blob {
 	}
 
 	if th.Created.Before(t) {
-		return nil
+		return nil, Stale
 	}
 
-	return th
+	return th, Hit
 }
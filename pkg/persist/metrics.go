@@ -0,0 +1,276 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layer is the part of a cache which answered a lookup
+type Layer string
+
+const (
+	// MemoryLayer is the in-memory cache
+	MemoryLayer Layer = "memory"
+	// PersistentLayer is the backend the in-memory cache is persisted to
+	PersistentLayer Layer = "persistent"
+)
+
+// Lookup is the result of looking up a key within a layer
+type Lookup int
+
+const (
+	// Hit means that a fresh enough entry was found
+	Hit Lookup = iota
+	// Miss means that no usable entry was found
+	Miss
+	// Stale means that an entry was found, but it was older than requested
+	Stale
+)
+
+// topKeys is how many of the slowest and biggest keys are tracked
+var topKeys = 25
+
+// keyFamilies maps key suffixes to the kind of data they hold, most specific first
+var keyFamilies = []struct {
+	suffix string
+	family string
+}{
+	{"-issue-comments", "comments"},
+	{"-pr-comments", "comments"},
+	{"-pr-reviews", "reviews"},
+	{"-timeline", "timeline"},
+	{"-issues", "issues"},
+	{"-prs", "pulls"},
+	{"-pr", "pulls"},
+	{"-collection", "collections"},
+}
+
+// withinSuffix starts the suffix of keys for partial searches, such as "org-project-open-issues-within-2.0h"
+var withinSuffix = "-within-"
+
+// KeyFamily returns the kind of data a key holds: issues, pulls, comments, timeline, reviews, collections or other
+func KeyFamily(key string) string {
+	if i := strings.LastIndex(key, withinSuffix); i > 0 {
+		key = key[:i]
+	}
+
+	for _, kf := range keyFamilies {
+		if strings.HasSuffix(key, kf.suffix) {
+			return kf.family
+		}
+	}
+	return "other"
+}
+
+// LayerCounts are lookup counts for a key family within a layer
+type LayerCounts struct {
+	Family string
+	Layer  Layer
+	Hits   int64
+	Misses int64
+	Stale  int64
+}
+
+// HitRate returns the fraction of lookups which were hits
+func (lc LayerCounts) HitRate() float64 {
+	total := lc.Hits + lc.Misses + lc.Stale
+	if total == 0 {
+		return 0
+	}
+	return float64(lc.Hits) / float64(total)
+}
+
+// KeyTiming is how long a persistent lookup took
+type KeyTiming struct {
+	Key      string
+	Duration time.Duration
+	When     time.Time
+}
+
+// KeySize is the stored size of an entry
+type KeySize struct {
+	Key   string
+	Bytes int
+}
+
+// Metrics is a snapshot of cache lookup statistics
+type Metrics struct {
+	Cache   string
+	Since   time.Time
+	Counts  []LayerCounts
+	Slowest []KeyTiming
+	Biggest []KeySize
+}
+
+type familyLayer struct {
+	family string
+	layer  Layer
+}
+
+// cacheStats collects lookup statistics for a Cacher
+type cacheStats struct {
+	mu      sync.Mutex
+	started time.Time
+	counts  map[familyLayer]*LayerCounts
+	slowest map[string]KeyTiming
+	biggest map[string]KeySize
+}
+
+func newCacheStats() *cacheStats {
+	return &cacheStats{
+		started: time.Now(),
+		counts:  map[familyLayer]*LayerCounts{},
+		slowest: map[string]KeyTiming{},
+		biggest: map[string]KeySize{},
+	}
+}
+
+// record counts a lookup within a layer
+func (s *cacheStats) record(key string, l Layer, r Lookup) {
+	fl := familyLayer{family: KeyFamily(key), layer: l}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lc := s.counts[fl]
+	if lc == nil {
+		lc = &LayerCounts{Family: fl.family, Layer: l}
+		s.counts[fl] = lc
+	}
+
+	switch r {
+	case Hit:
+		lc.Hits++
+	case Miss:
+		lc.Misses++
+	case Stale:
+		lc.Stale++
+	}
+}
+
+// persistent counts a lookup within the persistent layer, tracking how long it took and how big the entry was
+func (s *cacheStats) persistent(key string, r Lookup, d time.Duration, size int) {
+	s.record(key, PersistentLayer, r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if kt, ok := s.slowest[key]; !ok || d > kt.Duration {
+		s.slowest[key] = KeyTiming{Key: key, Duration: d, When: time.Now()}
+		if len(s.slowest) >= topKeys*2 {
+			for _, kt := range slowestFirst(s.slowest)[topKeys:] {
+				delete(s.slowest, kt.Key)
+			}
+		}
+	}
+
+	if size > 0 {
+		s.sizeLocked(key, size)
+	}
+}
+
+// stored tracks the size of an entry written to the persistent layer
+func (s *cacheStats) stored(key string, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizeLocked(key, size)
+}
+
+func (s *cacheStats) sizeLocked(key string, size int) {
+	s.biggest[key] = KeySize{Key: key, Bytes: size}
+	if len(s.biggest) >= topKeys*2 {
+		for _, ks := range biggestFirst(s.biggest)[topKeys:] {
+			delete(s.biggest, ks.Key)
+		}
+	}
+}
+
+func slowestFirst(m map[string]KeyTiming) []KeyTiming {
+	kts := []KeyTiming{}
+	for _, kt := range m {
+		kts = append(kts, kt)
+	}
+
+	sort.Slice(kts, func(i, j int) bool {
+		if kts[i].Duration != kts[j].Duration {
+			return kts[i].Duration > kts[j].Duration
+		}
+		return kts[i].Key < kts[j].Key
+	})
+	return kts
+}
+
+func biggestFirst(m map[string]KeySize) []KeySize {
+	kss := []KeySize{}
+	for _, ks := range m {
+		kss = append(kss, ks)
+	}
+
+	sort.Slice(kss, func(i, j int) bool {
+		if kss[i].Bytes != kss[j].Bytes {
+			return kss[i].Bytes > kss[j].Bytes
+		}
+		return kss[i].Key < kss[j].Key
+	})
+	return kss
+}
+
+func (s *cacheStats) snapshot(name string) *Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := &Metrics{Cache: name, Since: s.started}
+	for _, lc := range s.counts {
+		m.Counts = append(m.Counts, *lc)
+	}
+
+	sort.Slice(m.Counts, func(i, j int) bool {
+		if m.Counts[i].Family != m.Counts[j].Family {
+			return m.Counts[i].Family < m.Counts[j].Family
+		}
+		return m.Counts[i].Layer < m.Counts[j].Layer
+	})
+
+	m.Slowest = slowestFirst(s.slowest)
+	if len(m.Slowest) > topKeys {
+		m.Slowest = m.Slowest[:topKeys]
+	}
+
+	m.Biggest = biggestFirst(s.biggest)
+	if len(m.Biggest) > topKeys {
+		m.Biggest = m.Biggest[:topKeys]
+	}
+
+	return m
+}
+
+// instrumented is implemented by backends which collect lookup statistics
+type instrumented interface {
+	stats() *cacheStats
+}
+
+// MetricsFor returns a snapshot of the lookup statistics for a cache
+func MetricsFor(c Cacher) (*Metrics, error) {
+	in, ok := c.(instrumented)
+	if !ok {
+		return nil, fmt.Errorf("%s does not collect metrics", c)
+	}
+	return in.stats().snapshot(c.String()), nil
+}
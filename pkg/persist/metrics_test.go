@@ -0,0 +1,95 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyFamily(t *testing.T) {
+	tests := map[string]string{
+		"kubernetes-minikube-open-issues":                "issues",
+		"kubernetes-minikube-closed-issues-within-72.0h": "issues",
+		"kubernetes-minikube-open-prs":                   "pulls",
+		"kubernetes-minikube-123-pr":                     "pulls",
+		"kubernetes-minikube-123-pr-comments":            "comments",
+		"kubernetes-minikube-123-issue-comments":         "comments",
+		"kubernetes-minikube-123-pr-reviews":             "reviews",
+		"kubernetes-minikube-123-timeline":               "timeline",
+		"weekly-collection":                              "collections",
+		"something-else":                                 "other",
+	}
+
+	for key, want := range tests {
+		assert.Equal(t, want, KeyFamily(key), key)
+	}
+}
+
+func counts(t *testing.T, c Cacher, family string, l Layer) LayerCounts {
+	m, err := MetricsFor(c)
+	require.NoError(t, err)
+	for _, lc := range m.Counts {
+		if lc.Family == family && lc.Layer == l {
+			return lc
+		}
+	}
+	return LayerCounts{Family: family, Layer: l}
+}
+
+func TestMetrics(t *testing.T) {
+	c, err := NewDisk(Config{Path: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+
+	created := time.Now().Add(-time.Hour)
+	require.NoError(t, c.Set("org-proj-1-timeline", &Blob{Created: created}))
+	require.NotNil(t, c.Get("org-proj-1-timeline", created))
+
+	// Restarting empties the in-memory cache, so lookups fall through to disk
+	require.NoError(t, c.Initialize())
+	require.NotNil(t, c.Get("org-proj-1-timeline", created))
+	assert.Nil(t, c.Get("org-proj-1-timeline", time.Now()))
+	assert.Nil(t, c.Get("org-proj-open-issues", time.Time{}))
+
+	assert.Equal(t, LayerCounts{Family: "timeline", Layer: MemoryLayer, Hits: 1, Misses: 1, Stale: 1}, counts(t, c, "timeline", MemoryLayer))
+	assert.Equal(t, LayerCounts{Family: "timeline", Layer: PersistentLayer, Hits: 1, Stale: 1}, counts(t, c, "timeline", PersistentLayer))
+	assert.Equal(t, LayerCounts{Family: "issues", Layer: PersistentLayer, Misses: 1}, counts(t, c, "issues", PersistentLayer))
+
+	m, err := MetricsFor(c)
+	require.NoError(t, err)
+	require.Len(t, m.Slowest, 2)
+	require.Len(t, m.Biggest, 1)
+	assert.Equal(t, "org-proj-1-timeline", m.Biggest[0].Key)
+	assert.Greater(t, m.Biggest[0].Bytes, 0)
+}
+
+func TestMetricsTopKeys(t *testing.T) {
+	s := newCacheStats()
+	for i := 0; i < topKeys*4; i++ {
+		s.persistent(fmt.Sprintf("key-%d", i), Hit, time.Duration(i)*time.Millisecond, i+1)
+	}
+
+	m := s.snapshot("test")
+	require.Len(t, m.Slowest, topKeys)
+	require.Len(t, m.Biggest, topKeys)
+	assert.Equal(t, fmt.Sprintf("key-%d", topKeys*4-1), m.Slowest[0].Key)
+	assert.Equal(t, topKeys*4, m.Biggest[0].Bytes)
+	assert.Less(t, len(s.slowest), topKeys*2)
+}
@@ -16,10 +16,10 @@
 package persist
 
 import (
-	"bytes"
 	"database/sql"
-	"encoding/gob"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -33,22 +33,154 @@ CREATE TABLE IF NOT EXISTS persist2 (
 	saved TIMESTAMP DEFAULT '1970-01-01 00:00:01',
 	k VARCHAR(255) NOT NULL,
 	v MEDIUMBLOB,
+	last_read TIMESTAMP NULL DEFAULT NULL,
 	UNIQUE KEY unique_k (k),
-	INDEX saved_idx (saved)
+	INDEX saved_idx (saved),
+	INDEX read_idx (last_read)
+);`
+
+// mysqlLeaseSchema is executed separately, as the driver runs one statement at a time
+var mysqlLeaseSchema = `
+CREATE TABLE IF NOT EXISTS leases (
+	name VARCHAR(255) PRIMARY KEY,
+	holder VARCHAR(255) NOT NULL,
+	expires BIGINT NOT NULL
 );`
 
 // sqlItem maps to schema
 type sqlItem struct {
-	ID    int64     `db:"id"`
-	Saved time.Time `db:"saved"`
-	Key   string    `db:"k"`
-	Value []byte    `db:"v"`
+	ID    int64        `db:"id"`
+	Saved time.Time    `db:"saved"`
+	Key   string       `db:"k"`
+	Value []byte       `db:"v"`
+	Read  sql.NullTime `db:"last_read"`
+}
+
+// sqlQueries are the dialect specific statements used to manage stored entries
+type sqlQueries struct {
+	delete string
+	// addRead adds the last_read column to tables created by older releases
+	addRead []string
+}
+
+// readBatchSize is the maximum number of read times persisted per transaction
+const readBatchSize = 500
+
+// sqlEntries lists the entries within the persist2 table. Entries which have not been
+// read since the last_read column was added are considered read when they were saved.
+func sqlEntries(db *sqlx.DB) ([]Entry, error) {
+	rows, err := db.Queryx(`SELECT k, saved, last_read, LENGTH(v) FROM persist2`)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	es := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var saved, read sql.NullTime
+		if err := rows.Scan(&e.Key, &saved, &read, &e.Size); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		e.Saved = saved.Time
+		e.Read = saved.Time
+		if read.Valid {
+			e.Read = read.Time
+		}
+		es = append(es, e)
+	}
+	return es, rows.Err()
+}
+
+// sqlStored reads an entry from the persist2 table
+func sqlStored(db *sqlx.DB, c codec, key string) (*Blob, error) {
+	var v []byte
+	if err := db.Get(&v, db.Rebind(`SELECT v FROM persist2 WHERE k = ?`), key); err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+
+	bl, _, err := c.decode(v)
+	return bl, err
+}
+
+// addSQLReadColumn adds the last_read column to an existing persist2 table, if it is missing
+func addSQLReadColumn(db *sqlx.DB, q sqlQueries) error {
+	if _, err := db.Exec(`SELECT last_read FROM persist2 LIMIT 1`); err == nil {
+		return nil
+	}
+
+	klog.Infof("adding last_read column to persist2")
+	for _, s := range q.addRead {
+		if _, err := db.Exec(s); err != nil {
+			return fmt.Errorf("%s: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// persistSQLReads stores read times within the persist2 table, in batches
+func persistSQLReads(db *sqlx.DB, reads map[string]time.Time) error {
+	keys := []string{}
+	for k := range reads {
+		keys = append(keys, k)
+	}
+
+	// Entries rewritten since they were read already have a later read time
+	update := db.Rebind(`UPDATE persist2 SET last_read = ? WHERE k = ? AND (last_read IS NULL OR last_read < ?)`)
+	for start := 0; start < len(keys); start += readBatchSize {
+		end := start + readBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return fmt.Errorf("begin: %w", err)
+		}
+
+		for _, k := range keys[start:end] {
+			t := reads[k].UTC()
+			if _, err := tx.Exec(update, t, k, t); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("update %s: %w", k, err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+	}
+	return nil
+}
+
+var mysqlQueries = sqlQueries{
+	delete: `DELETE FROM persist2 WHERE k = ?`,
+	addRead: []string{
+		`ALTER TABLE persist2 ADD COLUMN last_read TIMESTAMP NULL DEFAULT NULL`,
+		`CREATE INDEX read_idx ON persist2 (last_read)`,
+	},
 }
 
 type MySQL struct {
 	memcache *cache.Cache
 	db       *sqlx.DB
 	path     string
+	migrated *migrationTally
+	reads    *accessLog
+	codec    codec
+	metrics  *cacheStats
+
+	// writes which have not yet completed
+	pending sync.WaitGroup
+	// key -> *keyWrites, so that writes to a key are applied in order
+	writes sync.Map
+}
+
+// keyWrites orders the background writes of a key
+type keyWrites struct {
+	mu sync.Mutex
+	// issued is the sequence number of the latest write
+	issued atomic.Uint64
 }
 
 // NewMySQL returns a new MySQL cache
@@ -57,13 +189,18 @@ func NewMySQL(cfg Config) (*MySQL, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newMySQL(dbx, cfg.Path, cfg), nil
+}
 
-	m := &MySQL{
-		db:   dbx,
-		path: cfg.Path,
+// newMySQL returns a MySQL cache for a connected database, which path describes
+func newMySQL(dbx *sqlx.DB, path string, cfg Config) *MySQL {
+	return &MySQL{
+		db:      dbx,
+		path:    path,
+		reads:   newAccessLog(),
+		codec:   newCodec(cfg),
+		metrics: newCacheStats(),
 	}
-
-	return m, nil
 }
 
 func (m *MySQL) String() string {
@@ -72,14 +209,29 @@ func (m *MySQL) String() string {
 
 func (m *MySQL) Initialize() error {
 	m.memcache = createMem()
+	m.migrated = &migrationTally{}
 
 	if _, err := m.db.Exec(mysqlSchema); err != nil {
 		return fmt.Errorf("exec schema: %w", err)
 	}
 
+	if _, err := m.db.Exec(mysqlLeaseSchema); err != nil {
+		return fmt.Errorf("exec lease schema: %w", err)
+	}
+
+	if err := addSQLReadColumn(m.db, mysqlQueries); err != nil {
+		return fmt.Errorf("add read column: %w", err)
+	}
+
+	m.reads.persistEvery(readFlushInterval, func(rs map[string]time.Time) error { return persistSQLReads(m.db, rs) })
 	return nil
 }
 
+// MigrationReport returns what happened to the stored entries read since Initialize
+func (m *MySQL) MigrationReport() MigrationReport {
+	return m.migrated.report()
+}
+
 // Set stores a thing
 func (m *MySQL) Set(key string, th *Blob) error {
 	start := time.Now()
@@ -87,19 +239,51 @@ func (m *MySQL) Set(key string, th *Blob) error {
 		klog.Infof("set(%q) took %s", key, time.Since(start))
 	}()
 
+	// The read time is stored along with the entry
 	setMem(m.memcache, key, th)
+	return m.store(key, th)
+}
+
+// Store writes a thing to the database in the background, without keeping it in memory
+func (m *MySQL) Store(key string, th *Blob) error {
+	// An older copy in memory would otherwise be read instead
+	m.memcache.Delete(key)
+	return m.store(key, th)
+}
 
+// store writes a thing to the database in the background
+func (m *MySQL) store(key string, th *Blob) error {
+	if th.Created.IsZero() {
+		th.Created = time.Now()
+	}
+
+	x, _ := m.writes.LoadOrStore(key, &keyWrites{})
+	w := x.(*keyWrites)
+	seq := w.issued.Add(1)
+
+	m.pending.Add(1)
 	go func() {
-		b := new(bytes.Buffer)
-		ge := gob.NewEncoder(b)
+		defer m.pending.Done()
 
-		if err := ge.Encode(th); err != nil {
-			klog.Errorf("encode: %w", err)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		// A later write of the key has already been applied, or is about to be
+		if w.issued.Load() != seq {
+			return
 		}
 
-		_, err := m.db.Exec(`
-			INSERT INTO persist2 (k, v, saved) VALUES (?, ?, ?)
-			ON DUPLICATE KEY UPDATE k=VALUES(k), v=VALUES(v)`, key, b.Bytes(), time.Now())
+		b, err := m.codec.encode(th)
+		if err != nil {
+			klog.Errorf("encode: %v", err)
+			return
+		}
+		m.metrics.stored(key, len(b))
+
+		now := time.Now().UTC()
+		_, err = m.db.Exec(`
+			INSERT INTO persist2 (k, v, saved, last_read) VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE k=VALUES(k), v=VALUES(v), saved=VALUES(saved), last_read=VALUES(last_read)`, key, b, now, now)
 
 		if err != nil {
 			klog.Errorf("insert failed: %v", err)
@@ -112,10 +296,16 @@ func (m *MySQL) Set(key string, th *Blob) error {
 func (m *MySQL) Get(key string, t time.Time) *Blob {
 	start := time.Now()
 
-	if b := getMem(m.memcache, key, t); b != nil {
+	b, res := getMem(m.memcache, key, t)
+	m.metrics.record(key, MemoryLayer, res)
+	if b != nil {
+		m.reads.touch(key)
 		return b
 	}
 
+	res, size := Miss, 0
+	defer func() { m.metrics.persistent(key, res, time.Since(start), size) }()
+
 	klog.Infof("%s was not in memory, resorting to SQL cache", key)
 
 	go func() {
@@ -134,18 +324,78 @@ func (m *MySQL) Get(key string, t time.Time) *Blob {
 		return nil
 	}
 
-	var bl Blob
-	gd := gob.NewDecoder(bytes.NewBuffer(mi.Value))
-	if err := gd.Decode(&bl); err != nil {
+	bl, outcome, err := m.codec.decode(mi.Value)
+	m.migrated.add(outcome)
+	if err != nil {
 		klog.Errorf("decode failed for %s (saved %s, bytes: %d): %v", mi.Key, mi.Saved, len(mi.Value), err)
+		// Entries which can no longer be decoded are removed, so that they are not read again
+		if err := m.remove(key); err != nil {
+			klog.Errorf("remove %s: %v", key, err)
+		}
 		return nil
 	}
 
 	if bl.Created.Before(t) {
 		klog.Warningf("found %s in db, but it was older than %s", key, t)
+		res = Stale
 		return nil
 	}
 
-	setMem(m.memcache, key, &bl)
-	return &bl
+	res, size = Hit, len(mi.Value)
+	setMem(m.memcache, key, bl)
+	m.reads.touch(key)
+
+	// Rewrite entries which were migrated, or encrypted with an older key
+	if outcome == Migrated {
+		if err := m.Set(key, bl); err != nil {
+			klog.Errorf("rewrite %s: %v", key, err)
+		}
+	}
+	return bl
+}
+
+// Close waits for pending writes to complete, and closes the underlying database
+func (m *MySQL) Close() error {
+	m.pending.Wait()
+	if err := m.reads.stop(); err != nil {
+		klog.Errorf("persist reads: %v", err)
+	}
+	return m.db.Close()
+}
+
+func (m *MySQL) entries() ([]Entry, error) {
+	return sqlEntries(m.db)
+}
+
+func (m *MySQL) readStored(key string) (*Blob, error) {
+	return sqlStored(m.db, m.codec, key)
+}
+
+func (m *MySQL) remove(key string) error {
+	m.memcache.Delete(key)
+	m.reads.forget(key)
+	_, err := m.db.Exec(mysqlQueries.delete, key)
+	return err
+}
+
+func (m *MySQL) lastRead(e Entry) time.Time {
+	return m.reads.latest(e.Key, e.Read)
+}
+
+func (m *MySQL) flushReads() error {
+	return m.reads.flush()
+}
+
+// AcquireLease takes or renews a named lease for holder, returning whoever now holds it
+func (m *MySQL) AcquireLease(name string, holder string, ttl time.Duration) (string, error) {
+	return acquireSQLLease(m.db, mysqlLeaseQueries, name, holder, ttl)
+}
+
+// ReleaseLease gives up a named lease, if holder has it
+func (m *MySQL) ReleaseLease(name string, holder string) error {
+	return releaseSQLLease(m.db, mysqlLeaseQueries, name, holder)
+}
+
+func (m *MySQL) stats() *cacheStats {
+	return m.metrics
 }
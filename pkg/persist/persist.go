@@ -32,11 +32,22 @@ type Config struct {
 	Program string
 	Type    string
 	Path    string
+
+	// Format is how blobs are stored (default: gob)
+	Format Format
+
+	// Keys enables encryption of persisted blobs, if set
+	Keys KeyProvider
 }
 
 type Blob struct {
 	Created time.Time
 
+	// Cursor is the latest update time of the listed items, from which incremental syncs continue
+	Cursor time.Time
+	// Listed is when the items were last listed in full, rather than synced incrementally
+	Listed time.Time
+
 	// Provider neutral fields, used by triage-party
 	PullRequests        []*provider.PullRequest
 	Issues              []*provider.Issue
@@ -45,6 +56,9 @@ type Blob struct {
 	Timeline            []*provider.Timeline
 	Reviews             []*provider.PullRequestReview
 
+	// Data is opaque data stored by other packages, such as encoded collection results
+	Data []byte
+
 	// Provider specific fields, used by other tramps
 	GHPullRequest         *github.PullRequest
 	GHCommitFiles         []*github.CommitFile
@@ -65,6 +79,12 @@ type Cacher interface {
 	Initialize() error
 }
 
+// Storer is implemented by backends with a persistent layer, which can store entries without keeping them in memory
+type Storer interface {
+	// Store persists an entry, dropping rather than updating any copy held in memory
+	Store(string, *Blob) error
+}
+
 func New(cfg Config) (Cacher, error) {
 	gob.Register(&Blob{})
 	switch cfg.Type {
@@ -74,6 +94,12 @@ func New(cfg Config) (Cacher, error) {
 		return NewCloudSQL(cfg)
 	case "postgres":
 		return NewPostgres(cfg)
+	case "sqlite":
+		return NewSQLite(cfg)
+	case "redis", "valkey":
+		return NewRedis(cfg)
+	case "s3":
+		return NewS3(cfg)
 	case "disk", "":
 		return NewDisk(cfg)
 	case "memory":
@@ -84,7 +110,7 @@ func New(cfg Config) (Cacher, error) {
 }
 
 // FromEnv is shared magic between binaries
-func FromEnv(program string, backend string, path string) (Cacher, error) {
+func FromEnv(program string, backend string, path string, format string, keyFile string) (Cacher, error) {
 	if backend == "" {
 		backend = os.Getenv("PERSIST_BACKEND")
 	}
@@ -96,15 +122,39 @@ func FromEnv(program string, backend string, path string) (Cacher, error) {
 		path = os.Getenv("PERSIST_PATH")
 	}
 
+	if format == "" {
+		format = os.Getenv("PERSIST_FORMAT")
+	}
+
+	f, err := ParseFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("format: %w", err)
+	}
+
+	if keyFile == "" {
+		keyFile = os.Getenv("PERSIST_KEY_FILE")
+	}
+
 	if program == "" {
 		program = "triage-party"
 	}
 
-	c, err := New(Config{
+	cfg := Config{
 		Program: program,
 		Type:    backend,
 		Path:    path,
-	})
+		Format:  f,
+	}
+
+	if keyFile != "" {
+		kf, err := NewKeyFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("key file: %w", err)
+		}
+		cfg.Keys = kf
+	}
+
+	c, err := New(cfg)
 
 	if err != nil {
 		return nil, fmt.Errorf("new from %s: %s: %w", backend, path, err)
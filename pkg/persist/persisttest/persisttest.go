@@ -0,0 +1,301 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package persisttest provides a conformance suite for persist.Cacher implementations
+package persisttest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/provider"
+)
+
+// Opener returns a new, uninitialized Cacher. Calling it twice should return two
+// instances which share the same persistent storage, as if the process had restarted.
+type Opener func(t *testing.T) persist.Cacher
+
+// Options describe what a backend is expected to support
+type Options struct {
+	// Persistent backends must return what a previous instance stored
+	Persistent bool
+
+	// WriteDelay is how long a write-behind backend may take to persist a Set
+	WriteDelay time.Duration
+
+	// LargeBlobBytes is the approximate size of the blob used for the large blob test
+	LargeBlobBytes int
+}
+
+// Run runs the conformance suite against a Cacher.
+//
+// Staleness is judged by the Created time stored within each blob, at full precision, rather than by when a
+// backend wrote it: SQL "saved" columns vary in precision and time zone handling between databases.
+func Run(t *testing.T, open Opener, o Options) {
+	if o.LargeBlobBytes == 0 {
+		o.LargeBlobBytes = 4 * 1024 * 1024
+	}
+
+	// Keys are unique per run, so that shared databases may be reused
+	prefix := fmt.Sprintf("persisttest-%d-", time.Now().UnixNano())
+
+	t.Run("Missing", func(t *testing.T) { testMissing(t, open, prefix) })
+	t.Run("Staleness", func(t *testing.T) { testStaleness(t, open, prefix) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, open, prefix) })
+	t.Run("LargeBlob", func(t *testing.T) { testLargeBlob(t, open, prefix, o.LargeBlobBytes) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, open, prefix) })
+
+	if o.Persistent {
+		t.Run("Restart", func(t *testing.T) { testRestart(t, open, prefix, o.WriteDelay) })
+		t.Run("Store", func(t *testing.T) { testStore(t, open, prefix, o.WriteDelay) })
+	}
+}
+
+func initialized(t *testing.T, open Opener) persist.Cacher {
+	t.Helper()
+
+	c := open(t)
+	if err := c.Initialize(); err != nil {
+		t.Fatalf("initialize %s: %v", c, err)
+	}
+	return c
+}
+
+func issueBlob(created time.Time, titles ...string) *persist.Blob {
+	bl := &persist.Blob{Created: created}
+	for i := range titles {
+		bl.Issues = append(bl.Issues, &provider.Issue{Title: &titles[i]})
+	}
+	return bl
+}
+
+func titles(bl *persist.Blob) []string {
+	ts := []string{}
+	for _, i := range bl.Issues {
+		ts = append(ts, i.GetTitle())
+	}
+	return ts
+}
+
+func checkTitles(t *testing.T, key string, bl *persist.Blob, want ...string) {
+	t.Helper()
+
+	if bl == nil {
+		t.Fatalf("Get(%q) = nil, want %v", key, want)
+	}
+
+	got := titles(bl)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Get(%q) titles = %v, want %v", key, got, want)
+	}
+}
+
+func testMissing(t *testing.T, open Opener, prefix string) {
+	c := initialized(t, open)
+
+	if bl := c.Get(prefix+"missing", time.Time{}); bl != nil {
+		t.Errorf("Get(missing) = %+v, want nil", bl)
+	}
+}
+
+func testStaleness(t *testing.T, open Opener, prefix string) {
+	c := initialized(t, open)
+	key := prefix + "stale"
+
+	// Sub-second precision must survive, even where the database only stores whole seconds
+	created := time.Now().Add(-time.Hour).Truncate(time.Second).Add(123456789)
+	if err := c.Set(key, issueBlob(created, "stale")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	tests := []struct {
+		desc  string
+		after time.Time
+		found bool
+	}{
+		{desc: "zero time", after: time.Time{}, found: true},
+		{desc: "before creation", after: created.Add(-time.Minute), found: true},
+		{desc: "at creation", after: created, found: true},
+		{desc: "a nanosecond after creation", after: created.Add(time.Nanosecond), found: false},
+		{desc: "after creation", after: created.Add(time.Second), found: false},
+	}
+
+	for _, tc := range tests {
+		bl := c.Get(key, tc.after)
+		if tc.found && bl == nil {
+			t.Errorf("%s: Get(%q, %s) = nil, want blob created at %s", tc.desc, key, tc.after, created)
+		}
+		if !tc.found && bl != nil {
+			t.Errorf("%s: Get(%q, %s) = blob created at %s, want nil", tc.desc, key, tc.after, bl.Created)
+		}
+	}
+
+	// Blobs without a creation time are considered to be created when stored
+	key = prefix + "unset"
+	before := time.Now()
+	if err := c.Set(key, issueBlob(time.Time{}, "unset")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	bl := c.Get(key, before)
+	if bl == nil {
+		t.Fatalf("Get(%q, %s) = nil, want blob", key, before)
+	}
+
+	if bl.Created.Before(before) {
+		t.Errorf("Created = %s, want after %s", bl.Created, before)
+	}
+}
+
+func testOverwrite(t *testing.T, open Opener, prefix string) {
+	c := initialized(t, open)
+	key := prefix + "overwrite"
+
+	now := time.Now()
+	if err := c.Set(key, issueBlob(now.Add(-time.Minute), "old")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	if err := c.Set(key, issueBlob(now, "new")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	checkTitles(t, key, c.Get(key, now), "new")
+}
+
+func testLargeBlob(t *testing.T, open Opener, prefix string, size int) {
+	c := initialized(t, open)
+	key := prefix + "large"
+
+	body := strings.Repeat("lorem ipsum dolor sit amet ", 160)
+	ts := []string{}
+	bl := &persist.Blob{Created: time.Now()}
+
+	for n := 0; n*len(body) < size; n++ {
+		title := fmt.Sprintf("issue %d", n)
+		ts = append(ts, title)
+		bl.Issues = append(bl.Issues, &provider.Issue{Title: &ts[n], Body: &body})
+	}
+
+	if err := c.Set(key, bl); err != nil {
+		t.Fatalf("set %d issues: %v", len(bl.Issues), err)
+	}
+
+	checkTitles(t, key, c.Get(key, time.Time{}), ts...)
+}
+
+func testConcurrent(t *testing.T, open Opener, prefix string) {
+	c := initialized(t, open)
+
+	workers := 8
+	ops := 10
+	keys := 4
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*ops)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				key := fmt.Sprintf("%sconcurrent-%d", prefix, (w+i)%keys)
+				if err := c.Set(key, issueBlob(time.Now(), fmt.Sprintf("worker %d op %d", w, i))); err != nil {
+					errs <- fmt.Errorf("set %s: %w", key, err)
+					continue
+				}
+
+				if bl := c.Get(key, time.Time{}); bl == nil {
+					errs <- fmt.Errorf("get %s: nil after set", key)
+				} else if len(bl.Issues) != 1 {
+					errs <- fmt.Errorf("get %s: %d issues, want 1", key, len(bl.Issues))
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func testRestart(t *testing.T, open Opener, prefix string, delay time.Duration) {
+	c := initialized(t, open)
+
+	created := time.Now().Add(-time.Hour).Truncate(time.Second).Add(123456789)
+	keys := []string{prefix + "restart-a", prefix + "restart-b"}
+	for _, k := range keys {
+		if err := c.Set(k, issueBlob(created, k)); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+
+	restarted := initialized(t, open)
+
+	deadline := time.Now().Add(delay)
+	for _, k := range keys {
+		bl := restarted.Get(k, created)
+		for bl == nil && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			bl = restarted.Get(k, created)
+		}
+
+		checkTitles(t, k, bl, k)
+		if bl != nil && !bl.Created.Equal(created) {
+			t.Errorf("Created = %s, want %s", bl.Created, created)
+		}
+
+		// Staleness must also be enforced for data which only exists in the persistent layer
+		if stale := open(t); stale.Initialize() == nil {
+			if bl := stale.Get(k, created.Add(time.Nanosecond)); bl != nil {
+				t.Errorf("Get(%q) after restart returned blob created at %s, want nil", k, bl.Created)
+			}
+		}
+	}
+}
+
+func testStore(t *testing.T, open Opener, prefix string, delay time.Duration) {
+	c := initialized(t, open)
+	key := prefix + "store"
+
+	st, ok := c.(persist.Storer)
+	if !ok {
+		t.Fatalf("%s is persistent, but does not implement persist.Storer", c)
+	}
+
+	now := time.Now()
+	if err := c.Set(key, issueBlob(now.Add(-time.Minute), "old")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	// Stored entries replace the in-memory copy, which must not be read instead
+	if err := st.Store(key, issueBlob(now, "new")); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	deadline := time.Now().Add(delay)
+	bl := c.Get(key, now)
+	for bl == nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		bl = c.Get(key, now)
+	}
+	checkTitles(t, key, bl, "new")
+}
@@ -16,9 +16,7 @@
 package persist
 
 import (
-	"bytes"
 	"database/sql"
-	"encoding/gob"
 	"fmt"
 	"time"
 
@@ -33,16 +31,34 @@ CREATE TABLE IF NOT EXISTS persist2 (
 	id SERIAL PRIMARY KEY,
 	saved TIMESTAMP DEFAULT '1970-01-01 00:00:01',
 	k VARCHAR UNIQUE,
-	v BYTEA
+	v BYTEA,
+	last_read TIMESTAMP
 );
 
 CREATE INDEX IF NOT EXISTS saved_idx ON persist2 (saved);
+
+CREATE TABLE IF NOT EXISTS leases (
+	name VARCHAR PRIMARY KEY,
+	holder VARCHAR NOT NULL,
+	expires BIGINT NOT NULL
+);
 `
 
+var pgQueries = sqlQueries{
+	delete: `DELETE FROM persist2 WHERE k = $1`,
+	addRead: []string{
+		`ALTER TABLE persist2 ADD COLUMN IF NOT EXISTS last_read TIMESTAMP`,
+	},
+}
+
 type Postgres struct {
 	memcache *cache.Cache
 	db       *sqlx.DB
 	path     string
+	migrated *migrationTally
+	reads    *accessLog
+	codec    codec
+	metrics  *cacheStats
 }
 
 // NewPostgres returns a new Postgres cache
@@ -51,13 +67,18 @@ func NewPostgres(cfg Config) (*Postgres, error) {
 	if err != nil {
 		return nil, fmt.Errorf("connect: %w", err)
 	}
+	return newPostgres(dbx, cfg.Path, cfg), nil
+}
 
-	m := &Postgres{
-		db:   dbx,
-		path: cfg.Path,
+// newPostgres returns a Postgres cache for a connected database, which path describes
+func newPostgres(dbx *sqlx.DB, path string, cfg Config) *Postgres {
+	return &Postgres{
+		db:      dbx,
+		path:    path,
+		reads:   newAccessLog(),
+		codec:   newCodec(cfg),
+		metrics: newCacheStats(),
 	}
-
-	return m, nil
 }
 
 func (m *Postgres) String() string {
@@ -66,30 +87,61 @@ func (m *Postgres) String() string {
 
 func (m *Postgres) Initialize() error {
 	m.memcache = createMem()
+	m.migrated = &migrationTally{}
 
 	klog.Infof("schema: %s", pgSchema)
 	if _, err := m.db.Exec(pgSchema); err != nil {
 		return fmt.Errorf("exec schema: %w", err)
 	}
 
+	if err := addSQLReadColumn(m.db, pgQueries); err != nil {
+		return fmt.Errorf("add read column: %w", err)
+	}
+
+	if _, err := m.db.Exec(`CREATE INDEX IF NOT EXISTS read_idx ON persist2 (last_read)`); err != nil {
+		return fmt.Errorf("create read index: %w", err)
+	}
+
+	m.reads.persistEvery(readFlushInterval, func(rs map[string]time.Time) error { return persistSQLReads(m.db, rs) })
 	return nil
 }
 
+// MigrationReport returns what happened to the stored entries read since Initialize
+func (m *Postgres) MigrationReport() MigrationReport {
+	return m.migrated.report()
+}
+
 // Set stores a thing
 func (m *Postgres) Set(key string, th *Blob) error {
+	// The read time is stored along with the entry
 	setMem(m.memcache, key, th)
+	return m.store(key, th)
+}
 
-	b := new(bytes.Buffer)
-	ge := gob.NewEncoder(b)
+// Store writes a thing to the database, without keeping it in memory
+func (m *Postgres) Store(key string, th *Blob) error {
+	// An older copy in memory would otherwise be read instead
+	m.memcache.Delete(key)
+	return m.store(key, th)
+}
+
+// store writes a thing to the database
+func (m *Postgres) store(key string, th *Blob) error {
+	if th.Created.IsZero() {
+		th.Created = time.Now()
+	}
 
-	if err := ge.Encode(th); err != nil {
-		return fmt.Errorf("encode: %w", err)
+	b, err := m.codec.encode(th)
+	if err != nil {
+		return err
 	}
+	m.metrics.stored(key, len(b))
 
-	_, err := m.db.Exec(`
-			INSERT INTO persist2 (k, v, saved) VALUES ($1, $2, $3)
+	now := time.Now().UTC()
+	_, err = m.db.Exec(`
+			INSERT INTO persist2 (k, v, saved, last_read) VALUES ($1, $2, $3, $4)
 			ON CONFLICT (k)
-			DO UPDATE SET v=EXCLUDED.v, saved=EXCLUDED.saved`, key, b.Bytes(), time.Now())
+			DO UPDATE SET v=EXCLUDED.v, saved=EXCLUDED.saved, last_read=EXCLUDED.last_read`, key, b, now, now)
 
 	return err
 }
@@ -98,10 +150,16 @@ func (m *Postgres) Set(key string, th *Blob) error {
 func (m *Postgres) Get(key string, t time.Time) *Blob {
 	start := time.Now()
 
-	if b := getMem(m.memcache, key, t); b != nil {
+	b, res := getMem(m.memcache, key, t)
+	m.metrics.record(key, MemoryLayer, res)
+	if b != nil {
+		m.reads.touch(key)
 		return b
 	}
 
+	res, size := Miss, 0
+	defer func() { m.metrics.persistent(key, res, time.Since(start), size) }()
+
 	klog.Infof("%s was not in memory, resorting to SQL cache", key)
 
 	go func() {
@@ -120,18 +178,77 @@ func (m *Postgres) Get(key string, t time.Time) *Blob {
 		return nil
 	}
 
-	var bl Blob
-	gd := gob.NewDecoder(bytes.NewBuffer(mi.Value))
-	if err := gd.Decode(&bl); err != nil {
+	bl, outcome, err := m.codec.decode(mi.Value)
+	m.migrated.add(outcome)
+	if err != nil {
 		klog.Errorf("decode failed for %s (saved %s, bytes: %d): %v", mi.Key, mi.Saved, len(mi.Value), err)
+		// Entries which can no longer be decoded are removed, so that they are not read again
+		if err := m.remove(key); err != nil {
+			klog.Errorf("remove %s: %v", key, err)
+		}
 		return nil
 	}
 
 	if bl.Created.Before(t) {
 		klog.Warningf("found %s in db, but it was older than %s", key, t)
+		res = Stale
 		return nil
 	}
 
-	setMem(m.memcache, key, &bl)
-	return &bl
+	res, size = Hit, len(mi.Value)
+	setMem(m.memcache, key, bl)
+	m.reads.touch(key)
+
+	// Rewrite entries which were migrated, or encrypted with an older key
+	if outcome == Migrated {
+		if err := m.Set(key, bl); err != nil {
+			klog.Errorf("rewrite %s: %v", key, err)
+		}
+	}
+	return bl
+}
+
+// Close closes the underlying database
+func (m *Postgres) Close() error {
+	if err := m.reads.stop(); err != nil {
+		klog.Errorf("persist reads: %v", err)
+	}
+	return m.db.Close()
+}
+
+func (m *Postgres) entries() ([]Entry, error) {
+	return sqlEntries(m.db)
+}
+
+func (m *Postgres) readStored(key string) (*Blob, error) {
+	return sqlStored(m.db, m.codec, key)
+}
+
+func (m *Postgres) remove(key string) error {
+	m.memcache.Delete(key)
+	m.reads.forget(key)
+	_, err := m.db.Exec(pgQueries.delete, key)
+	return err
+}
+
+func (m *Postgres) lastRead(e Entry) time.Time {
+	return m.reads.latest(e.Key, e.Read)
+}
+
+func (m *Postgres) flushReads() error {
+	return m.reads.flush()
+}
+
+// AcquireLease takes or renews a named lease for holder, returning whoever now holds it
+func (m *Postgres) AcquireLease(name string, holder string, ttl time.Duration) (string, error) {
+	return acquireSQLLease(m.db, pgLeaseQueries, name, holder, ttl)
+}
+
+// ReleaseLease gives up a named lease, if holder has it
+func (m *Postgres) ReleaseLease(name string, holder string) error {
+	return releaseSQLLease(m.db, pgLeaseQueries, name, holder)
+}
+
+func (m *Postgres) stats() *cacheStats {
+	return m.metrics
 }
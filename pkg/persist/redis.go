@@ -0,0 +1,298 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package persist provides a persistence layer for the in-memory cache
+package persist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/redis/go-redis/v9"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// redisMinTTL is the shortest time we will ask Redis to hold onto something
+	redisMinTTL = 5 * time.Minute
+
+	// redisInvalidateParam is the URL parameter which enables pub/sub invalidation
+	redisInvalidateParam = "invalidate"
+)
+
+// Redis is a cache backed by a Redis-protocol server, such as Redis or Valkey
+type Redis struct {
+	memcache *cache.Cache
+	client   *redis.Client
+	path     string
+	prefix   string
+
+	// pub/sub invalidation of peer memcaches
+	invalidate bool
+	channel    string
+	instance   string
+	pubsub     *redis.PubSub
+
+	migrated *migrationTally
+	codec    codec
+
+	metrics *cacheStats
+}
+
+// NewRedis returns a new Redis cache. Path is a redis:// URL or host:port address.
+// Adding "invalidate=true" to the URL enables pub/sub invalidation between replicas.
+func NewRedis(cfg Config) (*Redis, error) {
+	path := cfg.Path
+	if path == "" {
+		path = "redis://127.0.0.1:6379/0"
+	}
+	if !strings.Contains(path, "://") {
+		path = "redis://" + path
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	// go-redis rejects parameters it does not know about
+	q := u.Query()
+	invalidate := q.Get(redisInvalidateParam) == "true"
+	q.Del(redisInvalidateParam)
+	u.RawQuery = q.Encode()
+
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("instance id: %w", err)
+	}
+
+	program := cfg.Program
+	if program == "" {
+		program = "triage-party"
+	}
+
+	return &Redis{
+		client:     redis.NewClient(opts),
+		path:       opts.Addr,
+		prefix:     program + ":",
+		invalidate: invalidate,
+		channel:    program + ":invalidate",
+		instance:   hex.EncodeToString(id),
+		codec:      newCodec(cfg),
+		metrics:    newCacheStats(),
+	}, nil
+}
+
+func (r *Redis) String() string {
+	return fmt.Sprintf("redis://%s", r.path)
+}
+
+func (r *Redis) Initialize() error {
+	r.memcache = createMem()
+	r.migrated = &migrationTally{}
+
+	ctx := context.Background()
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+
+	if !r.invalidate {
+		return nil
+	}
+
+	r.pubsub = r.client.Subscribe(ctx, r.channel)
+	// Wait for the subscription to be confirmed, so that no invalidations are missed
+	if _, err := r.pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	go r.listen(r.pubsub.Channel())
+	return nil
+}
+
+func (r *Redis) entries() ([]Entry, error) {
+	ctx := context.Background()
+	es := []Entry{}
+
+	iter := r.client.Scan(ctx, 0, r.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		size, err := r.client.StrLen(ctx, iter.Val()).Result()
+		if err != nil {
+			return nil, fmt.Errorf("strlen: %w", err)
+		}
+		es = append(es, Entry{Key: strings.TrimPrefix(iter.Val(), r.prefix), Size: size})
+	}
+	return es, iter.Err()
+}
+
+func (r *Redis) readStored(key string) (*Blob, error) {
+	val, err := r.client.Get(context.Background(), r.prefix+key).Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+
+	bl, _, err := r.codec.decode(val)
+	return bl, err
+}
+
+// MigrationReport returns what happened to the stored entries read since Initialize
+func (r *Redis) MigrationReport() MigrationReport {
+	return r.migrated.report()
+}
+
+// listen drops keys from the in-memory cache when a peer has updated them
+func (r *Redis) listen(ch <-chan *redis.Message) {
+	for msg := range ch {
+		parts := strings.SplitN(msg.Payload, " ", 2)
+		if len(parts) != 2 {
+			klog.Warningf("unexpected invalidation message: %q", msg.Payload)
+			continue
+		}
+
+		if parts[0] == r.instance {
+			continue
+		}
+
+		klog.V(1).Infof("%s was updated by %s, dropping from in-memory cache", parts[1], parts[0])
+		r.memcache.Delete(parts[1])
+	}
+}
+
+// redisTTL returns how long Redis should hold a blob, based on when it was created
+func redisTTL(created time.Time) time.Duration {
+	ttl := memExpiration - time.Since(created)
+	if ttl < redisMinTTL {
+		return redisMinTTL
+	}
+	return ttl
+}
+
+// Set stores a thing
+func (r *Redis) Set(key string, th *Blob) error {
+	setMem(r.memcache, key, th)
+	return r.store(key, th)
+}
+
+// Store writes a thing to Redis, notifying peers if enabled, without keeping it in memory
+func (r *Redis) Store(key string, th *Blob) error {
+	// An older copy in memory would otherwise be read instead
+	r.memcache.Delete(key)
+	return r.store(key, th)
+}
+
+// store writes a thing to Redis, notifying peers if enabled
+func (r *Redis) store(key string, th *Blob) error {
+	if th.Created.IsZero() {
+		th.Created = time.Now()
+	}
+
+	b, err := r.codec.encode(th)
+	if err != nil {
+		return err
+	}
+	r.metrics.stored(key, len(b))
+
+	ctx := context.Background()
+	if err := r.client.Set(ctx, r.prefix+key, b, redisTTL(th.Created)).Err(); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+
+	if !r.invalidate {
+		return nil
+	}
+
+	if err := r.client.Publish(ctx, r.channel, r.instance+" "+key).Err(); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	return nil
+}
+
+// Get returns a Item older than a timestamp
+func (r *Redis) Get(key string, t time.Time) *Blob {
+	b, res := getMem(r.memcache, key, t)
+	r.metrics.record(key, MemoryLayer, res)
+	if b != nil {
+		return b
+	}
+
+	start := time.Now()
+	res, size := Miss, 0
+	defer func() { r.metrics.persistent(key, res, time.Since(start), size) }()
+
+	klog.Infof("%s was not in memory, resorting to Redis cache", key)
+
+	val, err := r.client.Get(context.Background(), r.prefix+key).Bytes()
+	if err == redis.Nil {
+		klog.Warningf("%s was not found in Redis cache", key)
+		return nil
+	}
+
+	if err != nil {
+		klog.Errorf("get: %v", err)
+		return nil
+	}
+
+	bl, outcome, err := r.codec.decode(val)
+	r.migrated.add(outcome)
+	if err != nil {
+		klog.Errorf("decode failed for %s (bytes: %d): %v", key, len(val), err)
+		// Entries which can no longer be decoded are removed, so that they are not read again
+		if err := r.client.Del(context.Background(), r.prefix+key).Err(); err != nil {
+			klog.Errorf("del %s: %v", key, err)
+		}
+		return nil
+	}
+
+	if bl.Created.Before(t) {
+		klog.Warningf("found %s in Redis, but it was older than %s", key, t)
+		res = Stale
+		return nil
+	}
+
+	res, size = Hit, len(val)
+	setMem(r.memcache, key, bl)
+
+	// Rewrite entries which were migrated, or encrypted with an older key
+	if outcome == Migrated {
+		if err := r.Set(key, bl); err != nil {
+			klog.Errorf("rewrite %s: %v", key, err)
+		}
+	}
+	return bl
+}
+
+// Close closes the subscription and client
+func (r *Redis) Close() error {
+	if r.pubsub != nil {
+		if err := r.pubsub.Close(); err != nil {
+			return fmt.Errorf("pubsub close: %w", err)
+		}
+	}
+	return r.client.Close()
+}
+
+func (r *Redis) stats() *cacheStats {
+	return r.metrics
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/persist/persisttest"
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedis(t *testing.T, path string) *persist.Redis {
+	c, err := persist.NewRedis(persist.Config{Program: "test", Path: path})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRedisConformance(t *testing.T) {
+	mr := miniredis.RunT(t)
+	persisttest.Run(t, func(t *testing.T) persist.Cacher {
+		return newRedis(t, mr.Addr())
+	}, persisttest.Options{Persistent: true})
+}
+
+func TestRedisTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newRedis(t, mr.Addr())
+	require.NoError(t, c.Initialize())
+
+	require.NoError(t, c.Set("fresh", &persist.Blob{Created: time.Now()}))
+	require.NoError(t, c.Set("old", &persist.Blob{Created: time.Now().Add(-24 * time.Hour)}))
+	require.NoError(t, c.Set("ancient", &persist.Blob{Created: time.Now().Add(-365 * 24 * time.Hour)}))
+
+	fresh := mr.TTL("test:fresh")
+	old := mr.TTL("test:old")
+	assert.Greater(t, fresh, old)
+	assert.InDelta(t, float64(23*time.Hour), float64(fresh-old), float64(2*time.Hour))
+	assert.Equal(t, 5*time.Minute, mr.TTL("test:ancient"))
+}
+
+func TestRedisInvalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newRedis(t, "redis://"+mr.Addr()+"/0?invalidate=true")
+	b := newRedis(t, "redis://"+mr.Addr()+"/0?invalidate=true")
+	require.NoError(t, a.Initialize())
+	require.NoError(t, b.Initialize())
+
+	v1, v2 := "v1", "v2"
+	require.NoError(t, a.Set("issues", &persist.Blob{Issues: []*provider.Issue{{Title: &v1}}}))
+
+	// b now has v1 within its in-memory cache
+	got := b.Get("issues", time.Time{})
+	require.NotNil(t, got)
+	assert.Equal(t, v1, got.Issues[0].GetTitle())
+
+	require.NoError(t, a.Set("issues", &persist.Blob{Issues: []*provider.Issue{{Title: &v2}}}))
+
+	assert.Eventually(t, func() bool {
+		got := b.Get("issues", time.Time{})
+		return got != nil && got.Issues[0].GetTitle() == v2
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,426 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package persist provides a persistence layer for the in-memory cache
+package persist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/patrickmn/go-cache"
+	"k8s.io/klog/v2"
+)
+
+// s3Suffix returns the suffix of objects stored by a codec, and whether they are gzip compressed.
+// zstd compressed and encrypted payloads are stored as is, as gzip would gain nothing.
+func s3Suffix(c codec) (string, bool) {
+	s := "." + string(c.format.Encoding)
+	if c.format.Zstd {
+		s += ".zst"
+	}
+
+	if c.sealer != nil {
+		return s + ".sealed", false
+	}
+
+	if c.format.Zstd {
+		return s, false
+	}
+	return s + ".gz", true
+}
+
+// s3Suffixes are the suffixes of objects in every format, which may be read whatever the current format is
+var s3Suffixes = func() []string {
+	ss := []string{}
+	for _, e := range []Encoding{Gob, JSON} {
+		for _, z := range []bool{false, true} {
+			for _, sealed := range []*sealer{nil, {}} {
+				s, _ := s3Suffix(codec{format: Format{Encoding: e, Zstd: z}, sealer: sealed})
+				ss = append(ss, s)
+			}
+		}
+	}
+	return ss
+}()
+
+// s3Key returns the key for an object name relative to the prefix, if it has a known suffix
+func s3Key(name string) (string, bool) {
+	for _, s := range s3Suffixes {
+		if strings.HasSuffix(name, s) {
+			return strings.TrimSuffix(name, s), true
+		}
+	}
+	return "", false
+}
+
+// S3 is a cache backed by S3-compatible object storage, such as AWS S3, MinIO or Google Cloud Storage.
+//
+// Object stores may be eventually consistent, so the in-memory cache is authoritative for
+// anything this process has seen: object storage is only consulted for unknown keys.
+type S3 struct {
+	memcache *cache.Cache
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	path     string
+	migrated *migrationTally
+	reads    *accessLog
+	codec    codec
+	metrics  *cacheStats
+}
+
+// NewS3 returns a new S3 cache. Path is a URL such as "https://s3.amazonaws.com/bucket".
+// Credentials may be part of the URL, or taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func NewS3(cfg Config) (*S3, error) {
+	u, err := url.Parse(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%q: expected an http or https URL", cfg.Path)
+	}
+
+	bucket := strings.Trim(u.Path, "/")
+	if bucket == "" || strings.Contains(bucket, "/") {
+		return nil, fmt.Errorf("%q: expected a bucket name as the URL path", cfg.Path)
+	}
+
+	key := os.Getenv("AWS_ACCESS_KEY_ID")
+	secret := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if u.User != nil {
+		key = u.User.Username()
+		secret, _ = u.User.Password()
+	}
+
+	region := u.Query().Get("region")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(key, secret, ""),
+		Secure: u.Scheme == "https",
+		Region: region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+
+	program := cfg.Program
+	if program == "" {
+		program = "triage-party"
+	}
+
+	return &S3{
+		client:  client,
+		bucket:  bucket,
+		prefix:  program + "/",
+		path:    fmt.Sprintf("%s/%s", u.Host, bucket),
+		codec:   newCodec(cfg),
+		metrics: newCacheStats(),
+	}, nil
+}
+
+func (s *S3) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.path, s.prefix)
+}
+
+func (s *S3) Initialize() error {
+	s.memcache = createMem()
+	s.reads = newAccessLog()
+	s.migrated = &migrationTally{}
+
+	ctx := context.Background()
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("bucket exists: %w", err)
+	}
+
+	if !exists {
+		klog.Infof("creating bucket %s", s.bucket)
+		if err := s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{}); err != nil {
+			return fmt.Errorf("make bucket: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// MigrationReport returns what happened to the stored entries read since Initialize
+func (s *S3) MigrationReport() MigrationReport {
+	return s.migrated.report()
+}
+
+// object returns the name an entry is stored under in the current format
+func (s *S3) object(key string) string {
+	suffix, _ := s3Suffix(s.codec)
+	return s.prefix + key + suffix
+}
+
+// objects returns the names an entry is stored under, in any format
+func (s *S3) objects(key string) ([]string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	names := []string{}
+	for o := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix + key + "."}) {
+		if o.Err != nil {
+			return nil, fmt.Errorf("list: %w", o.Err)
+		}
+
+		if k, ok := s3Key(strings.TrimPrefix(o.Key, s.prefix)); ok && k == key {
+			names = append(names, o.Key)
+		}
+	}
+	return names, nil
+}
+
+// Set stores a thing
+func (s *S3) Set(key string, th *Blob) error {
+	setMem(s.memcache, key, th)
+	return s.store(key, th)
+}
+
+// Store writes a thing to object storage, without keeping it in memory
+func (s *S3) Store(key string, th *Blob) error {
+	// An older copy in memory would otherwise be read instead
+	s.memcache.Delete(key)
+	return s.store(key, th)
+}
+
+// store writes a thing to object storage
+func (s *S3) store(key string, th *Blob) error {
+	if th.Created.IsZero() {
+		th.Created = time.Now()
+	}
+
+	s.reads.touch(key)
+
+	b, err := s.codec.encode(th)
+	if err != nil {
+		return err
+	}
+	s.metrics.stored(key, len(b))
+
+	var buf bytes.Buffer
+	if _, gz := s3Suffix(s.codec); gz {
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(b); err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		if err := gw.Close(); err != nil {
+			return fmt.Errorf("gzip close: %w", err)
+		}
+	} else {
+		buf.Write(b)
+	}
+
+	_, err = s.client.PutObject(context.Background(), s.bucket, s.object(key), &buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+	return nil
+}
+
+// Get returns a Item older than a timestamp
+func (s *S3) Get(key string, t time.Time) *Blob {
+	// The in-memory cache is authoritative, even for stale entries
+	b, res := getMem(s.memcache, key, t)
+	s.metrics.record(key, MemoryLayer, res)
+	if res != Miss {
+		if b != nil {
+			s.reads.touch(key)
+		}
+		return b
+	}
+
+	start := time.Now()
+	size := 0
+	defer func() { s.metrics.persistent(key, res, time.Since(start), size) }()
+
+	klog.Infof("%s was not in memory, resorting to object storage", key)
+
+	val, name, err := s.fetch(key)
+	if errors.Is(err, errNoSuchObject) {
+		klog.Warningf("%s was not found in object storage", key)
+		return nil
+	}
+
+	if err != nil {
+		klog.Errorf("fetch %s: %v", key, err)
+		return nil
+	}
+
+	bl, outcome, err := s.codec.decode(val)
+	s.migrated.add(outcome)
+	if err != nil {
+		klog.Errorf("decode failed for %s (bytes: %d): %v", key, len(val), err)
+		// Entries which can no longer be decoded are removed, so that they are not read again
+		if err := s.remove(key); err != nil {
+			klog.Errorf("remove %s: %v", key, err)
+		}
+		return nil
+	}
+
+	if bl.Created.Before(t) {
+		klog.Warningf("found %s in object storage, but it was older than %s", key, t)
+		res = Stale
+		return nil
+	}
+
+	res, size = Hit, len(val)
+	setMem(s.memcache, key, bl)
+	s.reads.touch(key)
+
+	// Rewrite entries which were migrated, encrypted with an older key, or stored under another format's name
+	if outcome == Migrated || name != s.object(key) {
+		if err := s.Set(key, bl); err != nil {
+			klog.Errorf("rewrite %s: %v", key, err)
+			return bl
+		}
+	}
+
+	if name != s.object(key) {
+		if err := s.client.RemoveObject(context.Background(), s.bucket, name, minio.RemoveObjectOptions{}); err != nil {
+			klog.Errorf("remove %s: %v", name, err)
+		}
+	}
+	return bl
+}
+
+// errNoSuchObject is returned by fetch for keys which are not stored
+var errNoSuchObject = errors.New("no such object")
+
+// fetch returns the stored bytes of an entry, and the name it was stored under. Entries
+// stored in another format are found by listing, should the current name not exist.
+func (s *S3) fetch(key string) ([]byte, string, error) {
+	name := s.object(key)
+	val, err := s.read(name)
+	if !errors.Is(err, errNoSuchObject) {
+		return val, name, err
+	}
+
+	names, err := s.objects(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(names) == 0 {
+		return nil, "", errNoSuchObject
+	}
+
+	val, err = s.read(names[0])
+	return val, names[0], err
+}
+
+// read returns the contents of an object, decompressed if necessary
+func (s *S3) read(name string) ([]byte, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	defer obj.Close()
+
+	// Errors such as missing objects are only returned once the object is read
+	var r io.Reader = obj
+	if strings.HasSuffix(name, ".gz") {
+		gr, err := gzip.NewReader(obj)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil, errNoSuchObject
+			}
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		r = gr
+	}
+
+	val, err := io.ReadAll(r)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, errNoSuchObject
+		}
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return val, nil
+}
+
+func (s *S3) readStored(key string) (*Blob, error) {
+	val, _, err := s.fetch(key)
+	if err != nil {
+		return nil, err
+	}
+
+	bl, _, err := s.codec.decode(val)
+	return bl, err
+}
+
+func (s *S3) entries() ([]Entry, error) {
+	// Cancelling stops the listing, should it be abandoned early
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	es := []Entry{}
+	for o := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if o.Err != nil {
+			return nil, fmt.Errorf("list: %w", o.Err)
+		}
+
+		key, ok := s3Key(strings.TrimPrefix(o.Key, s.prefix))
+		if !ok {
+			continue
+		}
+		es = append(es, Entry{Key: key, Size: o.Size, Saved: o.LastModified})
+	}
+	return es, nil
+}
+
+// remove removes an entry, whichever formats it is stored in
+func (s *S3) remove(key string) error {
+	s.memcache.Delete(key)
+	s.reads.forget(key)
+
+	names, err := s.objects(key)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := s.client.RemoveObject(context.Background(), s.bucket, name, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *S3) lastRead(e Entry) time.Time {
+	return s.reads.lastRead(e.Key, e.Saved)
+}
+
+func (s *S3) stats() *cacheStats {
+	return s.metrics
+}
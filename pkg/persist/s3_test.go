@@ -0,0 +1,279 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/persist/persisttest"
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeObject struct {
+	data     []byte
+	modified time.Time
+}
+
+// fakeS3 is a minimal path-style S3 stand-in, implementing just enough of the API for the S3 backend
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]fakeObject
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	f := &fakeS3{buckets: map[string]map[string]fakeObject{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv.URL
+}
+
+func (f *fakeS3) object(bucket, name string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.buckets[bucket][name]
+	return o.data, ok
+}
+
+func (f *fakeS3) put(bucket, name string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buckets[bucket][name] = fakeObject{data: data, modified: time.Now()}
+}
+
+func s3Error(w http.ResponseWriter, code int, name string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", name, name)
+}
+
+// readChunked decodes an aws-chunked request body, as sent by signed streaming uploads
+func readChunked(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var out bytes.Buffer
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return out.Bytes(), nil
+		}
+
+		if _, err := io.CopyN(&out, br, size); err != nil {
+			return nil, err
+		}
+
+		if _, err := br.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type listResult struct {
+	XMLName     xml.Name      `xml:"ListBucketResult"`
+	Name        string        `xml:"Name"`
+	Prefix      string        `xml:"Prefix"`
+	KeyCount    int           `xml:"KeyCount"`
+	IsTruncated bool          `xml:"IsTruncated"`
+	Contents    []listContent `xml:"Contents"`
+}
+
+type listContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int    `xml:"Size"`
+	ETag         string `xml:"ETag"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	name := ""
+	if len(parts) == 2 {
+		name = parts[1]
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, exists := f.buckets[bucket]
+
+	switch {
+	case name == "" && r.Method == http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case name == "" && r.Method == http.MethodPut:
+		if !exists {
+			f.buckets[bucket] = map[string]fakeObject{}
+		}
+	case !exists:
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+	case name == "" && r.Method == http.MethodGet:
+		prefix := r.URL.Query().Get("prefix")
+		res := listResult{Name: bucket, Prefix: prefix}
+		for k, o := range objects {
+			if strings.HasPrefix(k, prefix) {
+				res.Contents = append(res.Contents, listContent{Key: k, Size: len(o.data), LastModified: o.modified.UTC().Format(time.RFC3339Nano), ETag: `"etag"`})
+			}
+		}
+		sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+		res.KeyCount = len(res.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(res)
+	case r.Method == http.MethodPut:
+		var data []byte
+		var err error
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data, err = readChunked(r.Body)
+		} else {
+			data, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		objects[name] = fakeObject{data: data, modified: time.Now()}
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		o, ok := objects[name]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", o.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+		if r.Method == http.MethodGet {
+			w.Write(o.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func newS3(t *testing.T, url string) *persist.S3 {
+	return newS3Format(t, url, persist.Format{})
+}
+
+func newS3Format(t *testing.T, url string, f persist.Format) *persist.S3 {
+	url = strings.Replace(url, "http://", "http://key:secret@", 1) + "/cache"
+	c, err := persist.NewS3(persist.Config{Program: "test", Path: url, Format: f})
+	require.NoError(t, err)
+	return c
+}
+
+func TestS3Conformance(t *testing.T) {
+	_, url := newFakeS3(t)
+	persisttest.Run(t, func(t *testing.T) persist.Cacher {
+		return newS3(t, url)
+	}, persisttest.Options{Persistent: true})
+}
+
+func TestS3MemoryAuthoritative(t *testing.T) {
+	f, url := newFakeS3(t)
+	a := newS3(t, url)
+	b := newS3(t, url)
+	require.NoError(t, a.Initialize())
+	require.NoError(t, b.Initialize())
+
+	v1, v2 := "v1", "v2"
+	require.NoError(t, a.Set("issues", &persist.Blob{Created: time.Now(), Issues: []*provider.Issue{{Title: &v1}}}))
+
+	stored, ok := f.object("cache", "test/issues.gob.gz")
+	require.True(t, ok)
+
+	// b has never seen this key, so it is read from object storage
+	got := b.Get("issues", time.Time{})
+	require.NotNil(t, got)
+	assert.Equal(t, v1, got.Issues[0].GetTitle())
+
+	require.NoError(t, b.Set("issues", &persist.Blob{Created: time.Now(), Issues: []*provider.Issue{{Title: &v2}}}))
+
+	// Simulate a lagging replica returning the older object: both processes keep what they know
+	f.put("cache", "test/issues.gob.gz", stored)
+	got = b.Get("issues", time.Time{})
+	require.NotNil(t, got)
+	assert.Equal(t, v2, got.Issues[0].GetTitle())
+
+	got = a.Get("issues", time.Time{})
+	require.NotNil(t, got)
+	assert.Equal(t, v1, got.Issues[0].GetTitle())
+
+	es, err := persist.List(a)
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, "issues", es[0].Key)
+}
+
+func TestS3FormatChange(t *testing.T) {
+	f, url := newFakeS3(t)
+	old := newS3(t, url)
+	require.NoError(t, old.Initialize())
+
+	title := "stored as gob"
+	require.NoError(t, old.Set("issues", &persist.Blob{Created: time.Now(), Issues: []*provider.Issue{{Title: &title}}}))
+	_, ok := f.object("cache", "test/issues.gob.gz")
+	require.True(t, ok)
+
+	c := newS3Format(t, url, persist.Format{Encoding: persist.JSON, Zstd: true})
+	require.NoError(t, c.Initialize())
+
+	// Entries stored in another format are found, and moved to the current name
+	got := c.Get("issues", time.Time{})
+	require.NotNil(t, got)
+	assert.Equal(t, title, got.Issues[0].GetTitle())
+
+	_, ok = f.object("cache", "test/issues.gob.gz")
+	assert.False(t, ok)
+
+	// zstd frames are not gzip compressed
+	stored, ok := f.object("cache", "test/issues.json.zst")
+	require.True(t, ok)
+	assert.True(t, bytes.HasPrefix(stored, []byte{0x28, 0xb5, 0x2f, 0xfd}))
+
+	es, err := persist.List(c)
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, "issues", es[0].Key)
+
+	restarted := newS3Format(t, url, persist.Format{Encoding: persist.JSON, Zstd: true})
+	require.NoError(t, restarted.Initialize())
+	assert.NotNil(t, restarted.Get("issues", time.Time{}))
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoding is how a blob is serialized
+type Encoding string
+
+const (
+	// Gob is the native Go encoding: compact and fast, but tied to Go type layouts
+	Gob Encoding = "gob"
+	// JSON is slower and larger, but can be inspected with standard tools and tolerates schema changes
+	JSON Encoding = "json"
+)
+
+// Format describes how blobs are stored
+type Format struct {
+	Encoding Encoding
+	// Zstd compresses the encoded blob as a standard zstd frame
+	Zstd bool
+}
+
+// DefaultFormat is the format used when none is configured
+var DefaultFormat = Format{Encoding: Gob}
+
+// ParseFormat parses a format such as "gob", "json" or "json+zstd"
+func ParseFormat(s string) (Format, error) {
+	if s == "" {
+		return DefaultFormat, nil
+	}
+
+	parts := strings.Split(s, "+")
+	f := Format{Encoding: Encoding(parts[0])}
+	if f.Encoding != Gob && f.Encoding != JSON {
+		return f, fmt.Errorf("unknown encoding: %q", parts[0])
+	}
+
+	for _, p := range parts[1:] {
+		if p != "zstd" {
+			return f, fmt.Errorf("unknown compression: %q", p)
+		}
+		f.Zstd = true
+	}
+	return f, nil
+}
+
+func (f Format) String() string {
+	if f.Zstd {
+		return string(f.Encoding) + "+zstd"
+	}
+	return string(f.Encoding)
+}
+
+// zstdMagic is the start of every zstd frame
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+)
+
+// zstdCoders returns the shared zstd encoder and decoder, which are safe for concurrent use
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEnc, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDec, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEnc, zstdDec, zstdErr
+}
+
+// jsonPrefix is the start of every JSON envelope, which legacy gob streams never start with
+var jsonPrefix = []byte(`{"version":`)
+
+// jsonEnvelope is the JSON equivalent of the versioned envelope
+type jsonEnvelope struct {
+	Version int   `json:"version"`
+	Blob    *Blob `json:"blob"`
+}
+
+// serialize encodes a blob in the given format
+func serialize(bl *Blob, f Format) ([]byte, error) {
+	var b []byte
+	var err error
+
+	switch f.Encoding {
+	case JSON:
+		b, err = json.Marshal(jsonEnvelope{Version: BlobVersion, Blob: bl})
+		if err != nil {
+			return nil, fmt.Errorf("json: %w", err)
+		}
+	default:
+		b, err = encodeBlob(bl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !f.Zstd {
+		return b, nil
+	}
+
+	enc, _, err := zstdCoders()
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	return enc.EncodeAll(b, nil), nil
+}
+
+// deserialize decodes a blob in any supported format, returning the format it was stored in
+func deserialize(data []byte) (*Blob, Outcome, Format, error) {
+	f := Format{Encoding: Gob}
+
+	if bytes.HasPrefix(data, zstdMagic) {
+		_, dec, err := zstdCoders()
+		if err != nil {
+			return nil, Dropped, f, fmt.Errorf("zstd: %w", err)
+		}
+
+		data, err = dec.DecodeAll(data, nil)
+		if err != nil {
+			return nil, Dropped, f, fmt.Errorf("zstd: %w", err)
+		}
+		f.Zstd = true
+	}
+
+	if !bytes.HasPrefix(data, jsonPrefix) {
+		bl, outcome, err := decodeBlob(data)
+		return bl, outcome, f, err
+	}
+
+	// Older versions go through the same migrations as gob blobs, for changes JSON does not tolerate
+	f.Encoding = JSON
+	var env struct {
+		Version int             `json:"version"`
+		Blob    json.RawMessage `json:"blob"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, Dropped, f, fmt.Errorf("json: %w", err)
+	}
+
+	if len(env.Blob) == 0 || string(env.Blob) == "null" {
+		return nil, Dropped, f, fmt.Errorf("json: no blob")
+	}
+
+	payload, outcome, err := migratePayload(env.Version, env.Blob, JSON)
+	if err != nil {
+		return nil, Dropped, f, err
+	}
+
+	var bl Blob
+	if err := json.Unmarshal(payload, &bl); err != nil {
+		return nil, Dropped, f, fmt.Errorf("json: %w", err)
+	}
+	return &bl, outcome, f, nil
+}
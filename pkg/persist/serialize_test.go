@@ -0,0 +1,222 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var formats = []Format{
+	{Encoding: Gob},
+	{Encoding: Gob, Zstd: true},
+	{Encoding: JSON},
+	{Encoding: JSON, Zstd: true},
+}
+
+// body returns an issue body, including a log excerpt which varies between issues
+func body(i int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Steps to reproduce the issue:**\n\n1. minikube start --driver=docker\n2. observe issue #%d\n\n```\n", i)
+	for j := 0; j < 10; j++ {
+		fmt.Fprintf(&sb, "I0601 12:%02d:%02d.%06d %5d start.go:%d] waiting for %d components: pod %x\n", j, i%60, i*j*7919%1000000, 4000+i, 100+j*i%400, j+i%5, i*j*40503%65536)
+	}
+	sb.WriteString("```\n")
+	return sb.String()
+}
+
+// issuesBlob returns a blob resembling a page of GitHub issues
+func issuesBlob(n int) *Blob {
+	str := func(s string) *string { return &s }
+	num := func(i int) *int { return &i }
+
+	created := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	labels := []*provider.Label{
+		{Name: str("kind/bug"), Color: str("e11d21")},
+		{Name: str("priority/important-soon"), Color: str("eb6420")},
+		{Name: str("lifecycle/stale"), Color: str("795548")},
+	}
+
+	bl := &Blob{Created: created}
+	for i := 0; i < n; i++ {
+		login := fmt.Sprintf("user%d", i%97)
+		updated := created.Add(time.Duration(i) * time.Hour)
+		bl.Issues = append(bl.Issues, &provider.Issue{
+			Number:            num(i),
+			State:             str("open"),
+			Title:             str(fmt.Sprintf("minikube start fails with exit code %d on driver docker", i)),
+			Body:              str(body(i)),
+			AuthorAssociation: str("CONTRIBUTOR"),
+			User:              &provider.User{Login: &login, HTMLURL: str("https://github.com/" + login)},
+			Labels:            labels[:i%len(labels)+1],
+			Comments:          num(i % 20),
+			CreatedAt:         &created,
+			UpdatedAt:         &updated,
+			HTMLURL:           str(fmt.Sprintf("https://github.com/kubernetes/minikube/issues/%d", i)),
+			Reactions:         &provider.Reactions{TotalCount: num(i % 7)},
+		})
+	}
+	return bl
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range formats {
+		got, err := ParseFormat(f.String())
+		require.NoError(t, err)
+		assert.Equal(t, f, got)
+	}
+
+	got, err := ParseFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultFormat, got)
+
+	for _, s := range []string{"protobuf", "json+gzip", "+zstd"} {
+		_, err := ParseFormat(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	in := issuesBlob(3)
+
+	for _, f := range formats {
+		t.Run(f.String(), func(t *testing.T) {
+			b, err := serialize(in, f)
+			require.NoError(t, err)
+			assert.Equal(t, f.Zstd, bytes.HasPrefix(b, zstdMagic))
+
+			out, outcome, got, err := deserialize(b)
+			require.NoError(t, err)
+			assert.Equal(t, Kept, outcome)
+			assert.Equal(t, f, got)
+			assert.True(t, in.Created.Equal(out.Created))
+			require.Len(t, out.Issues, 3)
+			assert.Equal(t, in.Issues[2].GetBody(), out.Issues[2].GetBody())
+			assert.Equal(t, in.Issues[2].Labels[2].GetName(), out.Issues[2].Labels[2].GetName())
+		})
+	}
+}
+
+func TestJSONInspectable(t *testing.T) {
+	b, err := serialize(issuesBlob(1), Format{Encoding: JSON})
+	require.NoError(t, err)
+
+	var doc struct {
+		Version int
+		Blob    struct {
+			Issues []struct {
+				Title string `json:"title"`
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(b, &doc))
+	assert.Equal(t, BlobVersion, doc.Version)
+	assert.Contains(t, doc.Blob.Issues[0].Title, "minikube start fails")
+}
+
+func TestJSONMigration(t *testing.T) {
+	old := migrations[LegacyBlobVersion]
+	defer RegisterMigration(LegacyBlobVersion, old)
+
+	// A field which was renamed between versions
+	RegisterMigration(LegacyBlobVersion, func(payload []byte, enc Encoding) ([]byte, error) {
+		if enc != JSON {
+			return nil, fmt.Errorf("unexpected encoding: %s", enc)
+		}
+		return bytes.ReplaceAll(payload, []byte(`"name"`), []byte(`"title"`)), nil
+	})
+
+	stored := []byte(`{"version":1,"blob":{"Issues":[{"name":"renamed"}]}}`)
+	bl, outcome, f, err := deserialize(stored)
+	require.NoError(t, err)
+	assert.Equal(t, Migrated, outcome)
+	assert.Equal(t, JSON, f.Encoding)
+	assert.Equal(t, "renamed", bl.Issues[0].GetTitle())
+
+	RegisterMigration(LegacyBlobVersion, func(payload []byte, _ Encoding) ([]byte, error) {
+		return nil, fmt.Errorf("field removed")
+	})
+
+	_, outcome, _, err = deserialize(stored)
+	assert.Equal(t, Dropped, outcome)
+	assert.Error(t, err)
+}
+
+func TestFormatChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	old, err := NewSQLite(Config{Path: path})
+	require.NoError(t, err)
+	defer old.Close()
+	require.NoError(t, old.Initialize())
+	require.NoError(t, old.Set("issues", issuesBlob(2)))
+
+	f := Format{Encoding: JSON, Zstd: true}
+	c, err := NewSQLite(Config{Path: path, Format: f})
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Initialize())
+
+	bl := c.Get("issues", time.Time{})
+	require.NotNil(t, bl)
+	assert.Len(t, bl.Issues, 2)
+	assert.Equal(t, MigrationReport{Migrated: 1}, c.MigrationReport())
+
+	// The entry is rewritten in the new format once read
+	_, _, got, err := deserialize(storedValue(t, c, "issues"))
+	require.NoError(t, err)
+	assert.Equal(t, f, got)
+}
+
+// BenchmarkSerialize compares the size and speed of each format, for example:
+//
+//	go test -run=NONE -bench=Serialize ./pkg/persist/
+func BenchmarkSerialize(b *testing.B) {
+	bl := issuesBlob(1000)
+
+	for _, f := range formats {
+		data, err := serialize(bl, f)
+		if err != nil {
+			b.Fatalf("serialize: %v", err)
+		}
+
+		b.Run("encode/"+f.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := serialize(bl, f); err != nil {
+					b.Fatalf("serialize: %v", err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "bytes")
+		})
+
+		b.Run("decode/"+f.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := deserialize(data); err != nil {
+					b.Fatalf("deserialize: %v", err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "bytes")
+		})
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package persist provides a persistence layer for the in-memory cache
+package persist
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/patrickmn/go-cache"
+	"k8s.io/klog/v2"
+
+	// pure-Go driver, so that we can build without cgo
+	_ "modernc.org/sqlite"
+)
+
+var sqliteSchema = `
+CREATE TABLE IF NOT EXISTS persist2 (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	saved TIMESTAMP DEFAULT '1970-01-01 00:00:01',
+	k TEXT NOT NULL UNIQUE,
+	v BLOB,
+	last_read TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS saved_idx ON persist2 (saved);
+
+CREATE TABLE IF NOT EXISTS leases (
+	name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	expires INTEGER NOT NULL
+);
+`
+
+var sqliteQueries = sqlQueries{
+	delete: `DELETE FROM persist2 WHERE k = ?`,
+	addRead: []string{
+		`ALTER TABLE persist2 ADD COLUMN last_read TIMESTAMP`,
+	},
+}
+
+// sqlitePragmas are applied to every connection: WAL allows readers to proceed while writing
+var sqlitePragmas = "_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)&_pragma=synchronous(NORMAL)"
+
+type SQLite struct {
+	memcache *cache.Cache
+	db       *sqlx.DB
+	path     string
+	migrated *migrationTally
+	reads    *accessLog
+	codec    codec
+	metrics  *cacheStats
+}
+
+// NewSQLite returns a new SQLite cache
+func NewSQLite(cfg Config) (*SQLite, error) {
+	path := cfg.Path
+	if path == "" {
+		root, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("cache dir: %w", err)
+		}
+		path = filepath.Join(root, cfg.Program, "cache.db")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	dbx, err := sqlx.Connect("sqlite", fmt.Sprintf("file:%s?%s", path, sqlitePragmas))
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	m := &SQLite{
+		db:      dbx,
+		path:    path,
+		reads:   newAccessLog(),
+		codec:   newCodec(cfg),
+		metrics: newCacheStats(),
+	}
+
+	return m, nil
+}
+
+func (m *SQLite) String() string {
+	return fmt.Sprintf("sqlite://%s", m.path)
+}
+
+func (m *SQLite) Initialize() error {
+	m.memcache = createMem()
+	m.migrated = &migrationTally{}
+
+	if _, err := m.db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("exec schema: %w", err)
+	}
+
+	if err := addSQLReadColumn(m.db, sqliteQueries); err != nil {
+		return fmt.Errorf("add read column: %w", err)
+	}
+
+	if _, err := m.db.Exec(`CREATE INDEX IF NOT EXISTS read_idx ON persist2 (last_read)`); err != nil {
+		return fmt.Errorf("create read index: %w", err)
+	}
+
+	m.reads.persistEvery(readFlushInterval, func(rs map[string]time.Time) error { return persistSQLReads(m.db, rs) })
+	return nil
+}
+
+// MigrationReport returns what happened to the stored entries read since Initialize
+func (m *SQLite) MigrationReport() MigrationReport {
+	return m.migrated.report()
+}
+
+// Set stores a thing
+func (m *SQLite) Set(key string, th *Blob) error {
+	// The read time is stored along with the entry
+	setMem(m.memcache, key, th)
+	return m.store(key, th)
+}
+
+// Store writes a thing to the database, without keeping it in memory
+func (m *SQLite) Store(key string, th *Blob) error {
+	// An older copy in memory would otherwise be read instead
+	m.memcache.Delete(key)
+	return m.store(key, th)
+}
+
+// store writes a thing to the database
+func (m *SQLite) store(key string, th *Blob) error {
+	if th.Created.IsZero() {
+		th.Created = time.Now()
+	}
+
+	b, err := m.codec.encode(th)
+	if err != nil {
+		return err
+	}
+	m.metrics.stored(key, len(b))
+
+	now := time.Now().UTC()
+	_, err = m.db.Exec(`
+			INSERT INTO persist2 (k, v, saved, last_read) VALUES (?, ?, ?, ?)
+			ON CONFLICT (k)
+			DO UPDATE SET v=excluded.v, saved=excluded.saved, last_read=excluded.last_read`, key, b, now, now)
+
+	return err
+}
+
+// Get returns a Item older than a timestamp
+func (m *SQLite) Get(key string, t time.Time) *Blob {
+	b, res := getMem(m.memcache, key, t)
+	m.metrics.record(key, MemoryLayer, res)
+	if b != nil {
+		m.reads.touch(key)
+		return b
+	}
+
+	start := time.Now()
+	res, size := Miss, 0
+	defer func() { m.metrics.persistent(key, res, time.Since(start), size) }()
+
+	klog.Infof("%s was not in memory, resorting to SQLite cache", key)
+
+	var mi sqlItem
+	err := m.db.Get(&mi, `SELECT * FROM persist2 WHERE k = ? LIMIT 1`, key)
+	if err == sql.ErrNoRows {
+		klog.Warningf("%s was not found in SQLite cache", key)
+		return nil
+	}
+
+	if err != nil {
+		klog.Errorf("query: %v", err)
+		return nil
+	}
+
+	bl, outcome, err := m.codec.decode(mi.Value)
+	m.migrated.add(outcome)
+	if err != nil {
+		klog.Errorf("decode failed for %s (saved %s, bytes: %d): %v", mi.Key, mi.Saved, len(mi.Value), err)
+		// Entries which can no longer be decoded are removed, so that they are not read again
+		if err := m.remove(key); err != nil {
+			klog.Errorf("remove %s: %v", key, err)
+		}
+		return nil
+	}
+
+	if bl.Created.Before(t) {
+		klog.Warningf("found %s in db, but it was older than %s", key, t)
+		res = Stale
+		return nil
+	}
+
+	res, size = Hit, len(mi.Value)
+	setMem(m.memcache, key, bl)
+	m.reads.touch(key)
+
+	// Rewrite entries which were migrated, or encrypted with an older key
+	if outcome == Migrated {
+		if err := m.Set(key, bl); err != nil {
+			klog.Errorf("rewrite %s: %v", key, err)
+		}
+	}
+	return bl
+}
+
+// Close closes the underlying database
+func (m *SQLite) Close() error {
+	if err := m.reads.stop(); err != nil {
+		klog.Errorf("persist reads: %v", err)
+	}
+	return m.db.Close()
+}
+
+func (m *SQLite) entries() ([]Entry, error) {
+	return sqlEntries(m.db)
+}
+
+func (m *SQLite) readStored(key string) (*Blob, error) {
+	return sqlStored(m.db, m.codec, key)
+}
+
+func (m *SQLite) remove(key string) error {
+	m.memcache.Delete(key)
+	m.reads.forget(key)
+	_, err := m.db.Exec(sqliteQueries.delete, key)
+	return err
+}
+
+func (m *SQLite) lastRead(e Entry) time.Time {
+	return m.reads.latest(e.Key, e.Read)
+}
+
+func (m *SQLite) flushReads() error {
+	return m.reads.flush()
+}
+
+// AcquireLease takes or renews a named lease for holder, returning whoever now holds it
+func (m *SQLite) AcquireLease(name string, holder string, ttl time.Duration) (string, error) {
+	return acquireSQLLease(m.db, sqliteLeaseQueries, name, holder, ttl)
+}
+
+// ReleaseLease gives up a named lease, if holder has it
+func (m *SQLite) ReleaseLease(name string, holder string) error {
+	return releaseSQLLease(m.db, sqliteLeaseQueries, name, holder)
+}
+
+func (m *SQLite) stats() *cacheStats {
+	return m.metrics
+}
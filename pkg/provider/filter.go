@@ -53,6 +53,12 @@ type Filter struct {
 	ClosedComments     string `yaml:"comments-while-closed,omitempty"`
 	ClosedCommenters   string `yaml:"commenters-while-closed,omitempty"`
 	State              string `yaml:"state,omitempty"`
+	AuthorMergedPRs    string `yaml:"author-merged-prs,omitempty"`
+	AuthorIsBot        string `yaml:"author-is-bot,omitempty"`
+	LinkedPRState      string `yaml:"linked-pr-state,omitempty"`
+	LinkedPRCount      string `yaml:"linked-pr-count,omitempty"`
+	LinkedIssueCount   string `yaml:"linked-issue-count,omitempty"`
+	ClusterSize        string `yaml:"cluster-size,omitempty"`
 }
 
 // LoadLabelRegex loads a new label regex
@@ -36,16 +36,29 @@ func NewGitLab(token string) (Provider, error) {
 	return &GitLabProvider{client: cl}, nil
 }
 
-func (p *GitLabProvider) getListProjectIssuesOptions(sp SearchParams) *gitlab.ListProjectIssuesOptions {
-	var state *string
-	if sp.IssueListByRepoOptions.State == constants.OpenState {
+// getListState returns the GitLab state to list, or nil to list every state
+func getListState(state string) *string {
+	switch state {
+	case "", constants.AllState:
+		return nil
+	case constants.OpenState:
 		s := constants.OpenedState
-		state = &s
+		return &s
+	default:
+		return &state
+	}
+}
+
+func (p *GitLabProvider) getListProjectIssuesOptions(sp SearchParams) *gitlab.ListProjectIssuesOptions {
+	// As with GitHub, Since is when an issue was last updated: incremental syncs depend on it
+	var since *time.Time
+	if !sp.IssueListByRepoOptions.Since.IsZero() {
+		since = &sp.IssueListByRepoOptions.Since
 	}
 	return &gitlab.ListProjectIssuesOptions{
 		ListOptions:  p.getListOptions(sp.IssueListByRepoOptions.ListOptions),
-		State:        state,
-		CreatedAfter: &sp.IssueListByRepoOptions.Since,
+		State:        getListState(sp.IssueListByRepoOptions.State),
+		UpdatedAfter: since,
 	}
 }
 
@@ -206,7 +219,7 @@ func (p *GitLabProvider) getListProjectMergeRequestsOptions(sp SearchParams) *gi
 		ListOptions: p.getListOptions(sp.PullRequestListOptions.ListOptions),
 		Sort:        &sp.PullRequestListOptions.Direction,
 		OrderBy:     &orderBy,
-		State:       &sp.State,
+		State:       getListState(sp.PullRequestListOptions.State),
 	}
 }
 
@@ -16,6 +16,9 @@ package provider
 
 import (
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGitLab_GetResponse(t *testing.T) {
@@ -52,3 +55,37 @@ func TestGitLab_GetPullRequestReviews(t *testing.T) {
 	p := GitLabProvider{}
 	p.getPullRequestReviews(nil)
 }
+
+func TestGitLab_ListStates(t *testing.T) {
+	p := GitLabProvider{}
+	since := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{state: "open", want: "opened"},
+		{state: "opened", want: "opened"},
+		{state: "closed", want: "closed"},
+		{state: "all"},
+	}
+
+	for _, tc := range tests {
+		sp := SearchParams{
+			IssueListByRepoOptions: IssueListByRepoOptions{State: tc.state, Since: since},
+			PullRequestListOptions: PullRequestListOptions{State: tc.state},
+		}
+
+		io := p.getListProjectIssuesOptions(sp)
+		mo := p.getListProjectMergeRequestsOptions(sp)
+		if tc.want == "" {
+			assert.Nil(t, io.State, tc.state)
+			assert.Nil(t, mo.State, tc.state)
+		} else {
+			assert.Equal(t, tc.want, *io.State, tc.state)
+			assert.Equal(t, tc.want, *mo.State, tc.state)
+		}
+		assert.Equal(t, since, *io.UpdatedAfter)
+		assert.Nil(t, io.CreatedAfter)
+	}
+}
@@ -172,6 +172,14 @@ func (p *PullRequest) GetMerged() bool {
 	return *p.Merged
 }
 
+// GetMergedAt returns the MergedAt field if it's non-nil, zero value otherwise.
+func (p *PullRequest) GetMergedAt() time.Time {
+	if p == nil || p.MergedAt == nil {
+		return time.Time{}
+	}
+	return *p.MergedAt
+}
+
 // GetMergedBy returns the MergedBy field.
 func (p *PullRequest) GetMergedBy() *User {
 	if p == nil {
@@ -0,0 +1,88 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package site
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Clusters shows groups of similar items, ordered by total demand.
+func (h *Handlers) Clusters() http.HandlerFunc {
+	fmap := template.FuncMap{
+		"toJS":          toJS,
+		"toDays":        toDays,
+		"HumanDuration": humanDuration,
+		"RoughTime":     roughTime,
+		"UnixNano":      unixNano,
+		"Avatar":        avatar,
+	}
+
+	t := template.Must(template.New("clusters").Funcs(fmap).ParseFiles(
+		filepath.Join(h.baseDir, "clusters.tmpl"),
+		filepath.Join(h.baseDir, "base.tmpl"),
+	))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		defer func() {
+			klog.Infof("Served clusters request within %s", time.Since(start))
+		}()
+
+		sts, err := h.party.ListCollections()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("list collections: %v", err), 500)
+			klog.Errorf("collections: %v", err)
+			return
+		}
+
+		categories, err := h.party.ListCategories()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("list categories: %v", err), 500)
+			klog.Errorf("categories: %v", err)
+			return
+		}
+
+		p := &Page{
+			Version:     VERSION,
+			SiteName:    h.siteName,
+			Title:       "Duplicate clusters",
+			Collections: sts,
+			Categories:  categories,
+			Clusters:    h.party.Clusters(),
+			Status:      h.updater.Status(),
+		}
+
+		for _, c := range p.Clusters {
+			p.Total += len(c.Items)
+		}
+
+		if len(p.Clusters) == 0 {
+			p.Notification = template.HTML(fmt.Sprintf("No clusters of similar items found yet (%d items examined).", h.party.ConversationsTotal()))
+		}
+
+		err = t.ExecuteTemplate(w, "base", p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("clusters page: %v", err), 500)
+			klog.Errorf("tmpl: %v", err)
+			return
+		}
+	}
+}
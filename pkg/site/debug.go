@@ -0,0 +1,140 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package site
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/google/triage-party/pkg/persist"
+	"k8s.io/klog/v2"
+)
+
+// DebugCache shows cache hit rates, along with the slowest and biggest keys.
+func (h *Handlers) DebugCache() http.HandlerFunc {
+	fmap := template.FuncMap{
+		"toDays":    toDays,
+		"RoughTime": roughTime,
+		"Bytes":     func(n int) string { return humanize.Bytes(uint64(n)) },
+		"Percent":   func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+		"Duration":  func(d time.Duration) string { return d.Round(time.Microsecond).String() },
+	}
+
+	t := template.Must(template.New("debug_cache").Funcs(fmap).ParseFiles(
+		filepath.Join(h.baseDir, "debug_cache.tmpl"),
+		filepath.Join(h.baseDir, "base.tmpl"),
+	))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		defer func() {
+			klog.Infof("Served cache debug request within %s", time.Since(start))
+		}()
+
+		sts, err := h.party.ListCollections()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("list collections: %v", err), 500)
+			klog.Errorf("collections: %v", err)
+			return
+		}
+
+		categories, err := h.party.ListCategories()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("list categories: %v", err), 500)
+			klog.Errorf("categories: %v", err)
+			return
+		}
+
+		p := &Page{
+			Version:     VERSION,
+			SiteName:    h.siteName,
+			Title:       "Cache",
+			Collections: sts,
+			Categories:  categories,
+			Status:      h.updater.Status(),
+		}
+
+		if h.cache == nil {
+			p.Notification = template.HTML("No cache is configured.")
+		} else if m, err := persist.MetricsFor(h.cache); err != nil {
+			p.Notification = template.HTML(template.HTMLEscapeString(err.Error()))
+		} else {
+			p.CacheMetrics = m
+		}
+
+		err = t.ExecuteTemplate(w, "base", p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cache debug page: %v", err), 500)
+			klog.Errorf("tmpl: %v", err)
+			return
+		}
+	}
+}
+
+// DebugAnalyzer shows the size, queue depth and throughput of the shared analyzer pool.
+func (h *Handlers) DebugAnalyzer() http.HandlerFunc {
+	fmap := template.FuncMap{
+		"Duration": func(d time.Duration) string { return d.Round(time.Microsecond).String() },
+		"Rate":     func(f float64) string { return fmt.Sprintf("%.1f/s", f) },
+	}
+
+	t := template.Must(template.New("debug_analyzer").Funcs(fmap).ParseFiles(
+		filepath.Join(h.baseDir, "debug_analyzer.tmpl"),
+		filepath.Join(h.baseDir, "base.tmpl"),
+	))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		defer func() {
+			klog.Infof("Served analyzer debug request within %s", time.Since(start))
+		}()
+
+		sts, err := h.party.ListCollections()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("list collections: %v", err), 500)
+			klog.Errorf("collections: %v", err)
+			return
+		}
+
+		categories, err := h.party.ListCategories()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("list categories: %v", err), 500)
+			klog.Errorf("categories: %v", err)
+			return
+		}
+
+		m := h.party.AnalyzerMetrics()
+		p := &Page{
+			Version:         VERSION,
+			SiteName:        h.siteName,
+			Title:           "Analyzer",
+			Collections:     sts,
+			Categories:      categories,
+			Status:          h.updater.Status(),
+			AnalyzerMetrics: &m,
+		}
+
+		err = t.ExecuteTemplate(w, "base", p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("analyzer debug page: %v", err), 500)
+			klog.Errorf("tmpl: %v", err)
+			return
+		}
+	}
+}
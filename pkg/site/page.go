@@ -100,11 +100,21 @@ func (h *Handlers) collectionPage(ctx context.Context, id string, refresh bool)
 
 	if result.RuleResults == nil {
 		p.Notification = template.HTML(fmt.Sprintf("No cached data found - performing initial data download (%d issues examined) ...", h.party.ConversationsTotal()))
+	} else if result.Restored {
+		p.Notification = template.HTML(fmt.Sprintf("Showing data saved before Triage Party restarted, which may be up to %s old. Refreshing data in the background ...", humanDuration(time.Since(result.OldestInput))))
+		p.Stale = true
 	} else if p.ResultAge > h.warnAge {
 		p.Notification = template.HTML(fmt.Sprintf(`Refreshing data in the background. Displayed data may be up to %s old. Use <a href="https://en.wikipedia.org/wiki/Wikipedia:Bypass_your_cache#Bypassing_cache">Shift-Reload</a> to force a data refresh at any time.`, humanDuration(time.Since(result.OldestInput))))
 		p.Stale = true
 	}
 
+	if len(result.Warnings) > 0 {
+		p.Warning = template.HTML(fmt.Sprintf("Some data could not be fetched, so results may be incomplete: %s", template.HTMLEscapeString(result.Warnings[0].String())))
+		if len(result.Warnings) > 1 {
+			p.Warning += template.HTML(fmt.Sprintf(" (and %d more)", len(result.Warnings)-1))
+		}
+	}
+
 	if result.Collection != nil && result.Collection.Velocity != "" {
 		p.VelocityStats = h.updater.Lookup(ctx, result.Collection.Velocity, false)
 	} else {
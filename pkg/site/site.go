@@ -31,6 +31,7 @@ import (
 	"github.com/google/triage-party/pkg/provider"
 
 	"github.com/google/triage-party/pkg/hubbub"
+	"github.com/google/triage-party/pkg/persist"
 	"github.com/google/triage-party/pkg/triage"
 	"github.com/google/triage-party/pkg/updater"
 
@@ -76,6 +77,7 @@ type Config struct {
 	WarnAge       time.Duration
 	Updater       *updater.Updater
 	Party         *triage.Party
+	Cache         persist.Cacher
 }
 
 func New(c *Config) *Handlers {
@@ -83,6 +85,7 @@ func New(c *Config) *Handlers {
 		baseDir:   c.BaseDirectory,
 		updater:   c.Updater,
 		party:     c.Party,
+		cache:     c.Cache,
 		siteName:  c.Name,
 		warnAge:   c.WarnAge,
 		startTime: time.Now(),
@@ -94,6 +97,7 @@ type Handlers struct {
 	baseDir   string
 	updater   *updater.Updater
 	party     *triage.Party
+	cache     persist.Cacher
 	siteName  string
 	warnAge   time.Duration
 	startTime time.Time
@@ -145,6 +149,9 @@ type Page struct {
 	Categories  []string
 
 	Swimlanes            []*Swimlane
+	Clusters             []*hubbub.Cluster
+	CacheMetrics         *persist.Metrics
+	AnalyzerMetrics      *hubbub.AnalyzerMetrics
 	CollectionResult     *triage.CollectionResult
 	SelectorVar          string
 	SelectorOptions      []Choice
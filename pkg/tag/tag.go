@@ -35,6 +35,11 @@ var (
 	Merged        = Tag{ID: "merged", Desc: "PR was merged"}
 	Draft         = Tag{ID: "draft", Desc: "Draft PR"}
 
+	// Contributor history tags
+	FirstTimeContributor = Tag{ID: "first-time-contributor", Desc: "The author has not previously opened an issue or PR in this repository"}
+	FirstTimePR          = Tag{ID: "first-time-pr", Desc: "The author has not previously opened a PR in this repository"}
+	ReturningContributor = Tag{ID: "returning-contributor", Desc: "The author is not a member, but has previously opened an issue or PR in this repository"}
+
 	// Comment-based tags
 	Commented       = Tag{ID: "commented", Desc: "A project member has commented on this", NeedsComments: true}
 	Send            = Tag{ID: "send", Desc: "A project member commented more recently than the author", NeedsComments: true}
@@ -50,6 +55,7 @@ var (
 	XrefNewCommits          = Tag{ID: "pr-new-commits", Desc: "PR has commits since the last review", NeedsTimeline: true}
 	XrefPushedAfterApproval = Tag{ID: "pr-pushed-after-approval", Desc: "PR was pushed to after approval", NeedsTimeline: true}
 	XrefUnreviewed          = Tag{ID: "pr-unreviewed", Desc: "PR has never been reviewed", NeedsTimeline: true}
+	FixMergedIssueOpen      = Tag{ID: "fix-merged-issue-open", Desc: "A PR referencing this issue was merged, but the issue is still open", NeedsTimeline: true}
 
 	// Review-based tags
 	Approved            = Tag{ID: "approved", Desc: "Last review was an approval", NeedsReviews: true}
@@ -70,6 +76,9 @@ var Tags = map[Tag]bool{
 	Similar:                 true,
 	Merged:                  true,
 	Draft:                   true,
+	FirstTimeContributor:    true,
+	FirstTimePR:             true,
+	ReturningContributor:    true,
 	Commented:               true,
 	Send:                    true,
 	Recv:                    true,
@@ -88,6 +97,7 @@ var Tags = map[Tag]bool{
 	XrefNewCommits:          true,
 	XrefPushedAfterApproval: true,
 	XrefUnreviewed:          true,
+	FixMergedIssueOpen:      true,
 }
 
 func RoleLast(role string) Tag {
@@ -37,6 +37,9 @@ type Collection struct {
 	Hidden       bool     `yaml:"hidden,omitempty"`
 	UsedForStats bool     `yaml:"used_for_statistics,omitempty"`
 
+	// Refresh overrides when the collection is refreshed in the background
+	Refresh *Schedule `yaml:"refresh,omitempty"`
+
 	// Kanban option
 	Display  string `yaml:"display"`
 	Overflow int    `yaml:"overflow"`
@@ -54,6 +57,12 @@ type CollectionResult struct {
 
 	RuleResults []*RuleResult
 
+	// Warnings describe data which could not be fetched for any rule, so results may be incomplete
+	Warnings []hubbub.Warning
+
+	// Restored is set on results saved before a restart, which are served until they are first refreshed
+	Restored bool
+
 	Total             int
 	TotalPullRequests int
 	TotalIssues       int
@@ -78,6 +87,10 @@ func (p *Party) ExecuteCollection(ctx context.Context, s Collection, newerThan t
 	oldest := time.Now()
 
 	for _, tid := range s.RuleIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("collection %q: %w", s.ID, err)
+		}
+
 		if seenRule[tid] {
 			klog.Errorf("collection %q has a duplicate rule: %q - ignoring", s.ID, tid)
 			continue
@@ -134,6 +147,7 @@ func SummarizeCollectionResult(s *Collection, os []*RuleResult) *CollectionResul
 		}
 
 		r.RuleResults = append(r.RuleResults, oc)
+		r.Warnings = hubbub.AppendWarnings(r.Warnings, oc.Warnings...)
 
 		r.TotalAgeDays += oc.TotalAgeDays
 		r.TotalCurrentHoldDays += oc.TotalCurrentHoldDays
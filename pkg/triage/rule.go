@@ -36,6 +36,9 @@ type Rule struct {
 	Repos      []string          `yaml:"repos,omitempty"`
 	Type       string            `yaml:"type,omitempty"`
 	Filters    []provider.Filter `yaml:"filters"`
+
+	// FailClosed fails the rule, rather than showing partial results, if any data could not be fetched
+	FailClosed bool `yaml:"fail-closed,omitempty"`
 }
 
 type RuleResult struct {
@@ -53,6 +56,9 @@ type RuleResult struct {
 
 	Duplicates map[string]bool
 
+	// Warnings describe data which could not be fetched, so Items may be incomplete
+	Warnings []hubbub.Warning
+
 	// OldestInput is the timestamp of the oldest input data
 	OldestInput time.Time
 
@@ -124,6 +130,7 @@ func SummarizeRuleResult(t Rule, cs []*hubbub.Conversation, seen map[string]*Rul
 func (p *Party) ExecuteRule(ctx context.Context, sp provider.SearchParams, t Rule, seen map[string]*Rule, s *Collection) (*RuleResult, error) {
 	klog.V(1).Infof("executing rule %q for results newer than %s", t.ID, logu.STime(sp.NewerThan))
 	rcs := []*hubbub.Conversation{}
+	var warnings []hubbub.Warning
 	oldest := time.Now()
 
 	if s != nil {
@@ -142,23 +149,29 @@ func (p *Party) ExecuteRule(ctx context.Context, sp provider.SearchParams, t Rul
 
 		var ts time.Time
 		var cs []*hubbub.Conversation
+		var ws []hubbub.Warning
 
 		sp.Repo = r
 		sp.Filters = t.Filters
 
 		switch t.Type {
 		case hubbub.Issue:
-			cs, ts, err = p.engine.SearchIssues(ctx, sp)
+			cs, ts, ws, err = p.engine.SearchIssues(ctx, sp)
 		case hubbub.PullRequest:
-			cs, ts, err = p.engine.SearchPullRequests(ctx, sp)
+			cs, ts, ws, err = p.engine.SearchPullRequests(ctx, sp)
 		default:
-			cs, ts, err = p.engine.SearchAny(ctx, sp)
+			cs, ts, ws, err = p.engine.SearchAny(ctx, sp)
 		}
 
 		if err != nil {
 			return nil, err
 		}
 
+		if len(ws) > 0 && t.FailClosed {
+			return nil, fmt.Errorf("fail-closed rule has incomplete data: %s", ws[0])
+		}
+		warnings = hubbub.AppendWarnings(warnings, ws...)
+
 		rcs = append(rcs, cs...)
 		if ts.Before(oldest) {
 			oldest = ts
@@ -168,6 +181,7 @@ func (p *Party) ExecuteRule(ctx context.Context, sp provider.SearchParams, t Rul
 	klog.V(1).Infof("rule %q matched %d items", t.ID, len(rcs))
 	rr := SummarizeRuleResult(t, rcs, seen)
 	rr.OldestInput = oldest
+	rr.Warnings = warnings
 	return rr, nil
 }
 
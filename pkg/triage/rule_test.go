@@ -15,9 +15,17 @@
 package triage
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/provider"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseRepo(t *testing.T) {
@@ -47,3 +55,71 @@ func TestParseRepo(t *testing.T) {
 	assert.Equal(t, repo, r.Project)
 	assert.Equal(t, group, r.Group)
 }
+
+func TestRuleWarnings(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer api.Close()
+
+	c, err := persist.NewMemory(persist.Config{})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+
+	p, err := New(Config{Cache: c, GitHubToken: "unused", GitHubAPIURL: api.URL + "/"})
+	require.NoError(t, err)
+	require.NoError(t, p.Load(strings.NewReader(`
+settings:
+  repos:
+    - https://github.com/org/project
+collections:
+  - id: c
+    name: C
+    rules:
+      - open
+      - strict
+rules:
+  bugs:
+    name: Bugs
+    type: issue
+    filters:
+      - label: bug
+  open:
+    name: Open
+    type: issue
+    filters:
+      - label: bug
+  strict:
+    name: Strict
+    type: issue
+    fail-closed: true
+    filters:
+      - label: bug
+`)))
+
+	r, err := p.LookupRule("open")
+	require.NoError(t, err)
+	rr, err := p.ExecuteRule(context.Background(), provider.SearchParams{}, r, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, rr.Items)
+	require.Len(t, rr.Warnings, 1)
+	assert.Equal(t, "org/project", rr.Warnings[0].Repo)
+	assert.Equal(t, "open issues", rr.Warnings[0].Phase)
+
+	r, err = p.LookupRule("strict")
+	require.NoError(t, err)
+	_, err = p.ExecuteRule(context.Background(), provider.SearchParams{}, r, nil, nil)
+	assert.Error(t, err)
+
+	// A fail-closed rule fails its collection, so the last complete results stay visible
+	s, err := p.LookupCollection("c")
+	require.NoError(t, err)
+	_, err = p.ExecuteCollection(context.Background(), s, time.Time{})
+	assert.Error(t, err)
+
+	// Rules which search the same repository share its warnings
+	s.RuleIDs = []string{"open", "bugs"}
+	cr, err := p.ExecuteCollection(context.Background(), s, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, cr.Warnings, 1)
+}
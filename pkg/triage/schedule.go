@@ -0,0 +1,389 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleConfig is the on-disk form of a refresh schedule
+type scheduleConfig struct {
+	// Every is an interval, such as "6h"
+	Every string `yaml:"every,omitempty"`
+	// Cron is a standard 5-field cron expression, such as "0 6 * * mon"
+	Cron string `yaml:"cron,omitempty"`
+	// Hours restricts refreshes to a daily window, such as "09:00-17:00"
+	Hours string `yaml:"hours,omitempty"`
+	// Days restricts refreshes to days of the week, such as "mon-fri"
+	Days string `yaml:"days,omitempty"`
+	// Timezone is the IANA time zone the schedule is evaluated in (default: local time)
+	Timezone string `yaml:"timezone,omitempty"`
+}
+
+// Schedule is when a collection is refreshed in the background
+type Schedule struct {
+	raw scheduleConfig
+
+	every    time.Duration
+	cron     *cronSpec
+	loc      *time.Location
+	days     []bool
+	startMin int
+	endMin   int
+}
+
+// ParseSchedule parses a refresh schedule which is either an interval or a cron expression
+func ParseSchedule(s string) (*Schedule, error) {
+	if _, err := time.ParseDuration(s); err == nil {
+		return newSchedule(scheduleConfig{Every: s})
+	}
+	return newSchedule(scheduleConfig{Cron: s})
+}
+
+func newSchedule(raw scheduleConfig) (*Schedule, error) {
+	s := &Schedule{raw: raw, loc: time.Local, startMin: 0, endMin: 24 * 60}
+
+	switch {
+	case raw.Every != "" && raw.Cron != "":
+		return nil, fmt.Errorf("only one of 'every' and 'cron' may be set")
+	case raw.Every != "":
+		d, err := time.ParseDuration(raw.Every)
+		if err != nil {
+			return nil, fmt.Errorf("every: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("every: %s is not a positive interval", d)
+		}
+		s.every = d
+	case raw.Cron != "":
+		c, err := parseCron(raw.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("cron: %w", err)
+		}
+		s.cron = c
+	default:
+		return nil, fmt.Errorf("one of 'every' or 'cron' must be set")
+	}
+
+	if raw.Timezone != "" {
+		loc, err := time.LoadLocation(raw.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone: %w", err)
+		}
+		s.loc = loc
+	}
+
+	if raw.Days != "" {
+		days, err := parseField(raw.Days, 0, 7, weekdays)
+		if err != nil {
+			return nil, fmt.Errorf("days: %w", err)
+		}
+		// Both 0 and 7 are Sunday
+		days[0] = days[0] || days[7]
+		s.days = days[:7]
+	}
+
+	if raw.Hours != "" {
+		parts := strings.Split(raw.Hours, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("hours: %q is not a range such as 09:00-17:00", raw.Hours)
+		}
+
+		var err error
+		if s.startMin, err = parseClock(parts[0]); err != nil {
+			return nil, fmt.Errorf("hours: %w", err)
+		}
+		if s.endMin, err = parseClock(parts[1]); err != nil {
+			return nil, fmt.Errorf("hours: %w", err)
+		}
+		if s.endMin <= s.startMin {
+			return nil, fmt.Errorf("hours: %q ends before it starts", raw.Hours)
+		}
+	}
+
+	return s, nil
+}
+
+// parseClock parses a time of day such as "09:30", returning minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		if strings.TrimSpace(s) == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("%q is not a time such as 17:00", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// UnmarshalYAML accepts either a string (interval or cron expression) or a mapping
+func (s *Schedule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		parsed, err := ParseSchedule(str)
+		if err != nil {
+			return fmt.Errorf("refresh %q: %w", str, err)
+		}
+		*s = *parsed
+		return nil
+	}
+
+	var raw scheduleConfig
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	parsed, err := newSchedule(raw)
+	if err != nil {
+		return fmt.Errorf("refresh: %w", err)
+	}
+	*s = *parsed
+	return nil
+}
+
+// MarshalYAML returns the schedule as it was configured
+func (s *Schedule) MarshalYAML() (interface{}, error) {
+	return s.raw, nil
+}
+
+// GobEncode encodes the schedule as it was configured, so that results referring to it can be shared
+func (s *Schedule) GobEncode() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(s.raw); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// GobDecode parses a schedule encoded by GobEncode
+func (s *Schedule) GobDecode(data []byte) error {
+	var raw scheduleConfig
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&raw); err != nil {
+		return err
+	}
+
+	parsed, err := newSchedule(raw)
+	if err != nil {
+		return fmt.Errorf("refresh: %w", err)
+	}
+	*s = *parsed
+	return nil
+}
+
+func (s *Schedule) String() string {
+	var parts []string
+	if s.every > 0 {
+		parts = append(parts, "every "+s.every.String())
+	} else {
+		parts = append(parts, "cron "+s.raw.Cron)
+	}
+	if s.raw.Days != "" {
+		parts = append(parts, "on "+s.raw.Days)
+	}
+	if s.raw.Hours != "" {
+		parts = append(parts, "during "+s.raw.Hours)
+	}
+	if s.raw.Timezone != "" {
+		parts = append(parts, s.raw.Timezone)
+	}
+	return strings.Join(parts, " ")
+}
+
+// InWindow returns whether background refreshes are allowed at a point in time
+func (s *Schedule) InWindow(t time.Time) bool {
+	t = t.In(s.loc)
+	if s.days != nil && !s.days[t.Weekday()] {
+		return false
+	}
+
+	m := t.Hour()*60 + t.Minute()
+	return m >= s.startMin && m < s.endMin
+}
+
+// Next returns when a result created at the given time is next due for refresh, ignoring the window
+func (s *Schedule) Next(last time.Time) time.Time {
+	if s.every > 0 {
+		return last.Add(s.every)
+	}
+	return s.cron.next(last.In(s.loc))
+}
+
+// Due returns whether a result created at last should be refreshed at now
+func (s *Schedule) Due(last time.Time, now time.Time) bool {
+	if !s.InWindow(now) {
+		return false
+	}
+
+	next := s.Next(last)
+	return !next.IsZero() && !now.Before(next)
+}
+
+var weekdays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+var months = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// cronSpec is a parsed 5-field cron expression: minute, hour, day of month, month and day of week
+type cronSpec struct {
+	minute []bool
+	hour   []bool
+	dom    []bool
+	month  []bool
+	dow    []bool
+
+	// whether day of month or day of week were restricted, which changes how they combine
+	domStar bool
+	dowStar bool
+}
+
+func parseCron(expr string) (*cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q has %d fields, expected 5", expr, len(fields))
+	}
+
+	c := &cronSpec{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+
+	var err error
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12, months); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseField(fields[4], 0, 7, weekdays); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	c.dow[0] = c.dow[0] || c.dow[7]
+
+	return c, nil
+}
+
+// parseField parses a comma-separated list of values, ranges and steps, such as "1-5" or "*/15"
+func parseField(field string, min int, max int, names map[string]int) ([]bool, error) {
+	set := make([]bool, max+1)
+
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", s)
+		}
+		if n < min || n > max {
+			return 0, fmt.Errorf("%d is outside of %d-%d", n, min, max)
+		}
+		return n, nil
+	}
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%q has an invalid step", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = value(bounds[0]); err != nil {
+				return nil, err
+			}
+			if hi, err = value(bounds[1]); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("%q is a backwards range", part)
+			}
+		default:
+			n, err := value(part)
+			if err != nil {
+				return nil, err
+			}
+			lo = n
+			// "5/10" means every 10 starting at 5
+			if step == 1 {
+				hi = n
+			}
+		}
+
+		for i := lo; i <= hi; i += step {
+			set[i] = true
+		}
+	}
+
+	return set, nil
+}
+
+// dayMatches follows cron semantics: if both day fields are restricted, either may match
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom[t.Day()]
+	dow := c.dow[t.Weekday()]
+
+	if !c.domStar && !c.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// next returns the first matching minute after t, or the zero time if there is none within 5 years
+func (c *cronSpec) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !c.month[t.Month()] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
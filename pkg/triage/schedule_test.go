@@ -0,0 +1,143 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package triage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2020, 6, 3, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2020, 6, 3, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 6, 3, 10, 45, 0, 0, time.UTC)},
+		{"0 6 * * *", time.Date(2020, 6, 4, 6, 0, 0, 0, time.UTC)},
+		{"0 6 * * mon", time.Date(2020, 6, 8, 6, 0, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2020, 6, 3, 11, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2020, 7, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// When both day fields are restricted, either may match
+		{"0 0 13 * fri", time.Date(2020, 6, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2020, 6, 7, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tests {
+		c, err := parseCron(tc.expr)
+		require.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.want, c.next(from), tc.expr)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, s := range []string{"", "-1h", "* * * *", "60 * * * *", "* * * * funday", "5-1 * * * *", "*/0 * * * *"} {
+		_, err := ParseSchedule(s)
+		assert.Error(t, err, s)
+	}
+
+	bad := []scheduleConfig{
+		{Every: "1h", Cron: "* * * * *"},
+		{Every: "1h", Hours: "17:00-09:00"},
+		{Every: "1h", Hours: "9"},
+		{Every: "1h", Days: "someday"},
+		{Every: "1h", Timezone: "Mars/Olympus_Mons"},
+	}
+	for _, raw := range bad {
+		_, err := newSchedule(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestScheduleDue(t *testing.T) {
+	s, err := newSchedule(scheduleConfig{Every: "2h", Days: "mon-fri", Hours: "09:00-17:00", Timezone: "America/New_York"})
+	require.NoError(t, err)
+
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// Wednesday
+	last := time.Date(2020, 6, 3, 9, 0, 0, 0, ny)
+	assert.False(t, s.Due(last, last.Add(time.Hour)))
+	assert.True(t, s.Due(last, last.Add(2*time.Hour)))
+	// After hours
+	assert.False(t, s.Due(last, time.Date(2020, 6, 3, 17, 0, 0, 0, ny)))
+	// Saturday
+	assert.False(t, s.Due(last, time.Date(2020, 6, 6, 12, 0, 0, 0, ny)))
+	// The window is evaluated in the schedule's time zone
+	assert.True(t, s.Due(last, time.Date(2020, 6, 3, 20, 0, 0, 0, time.UTC)))
+
+	c, err := ParseSchedule("0 6 * * mon")
+	require.NoError(t, err)
+	assert.False(t, c.Due(last, last.Add(24*time.Hour)))
+	assert.True(t, c.Due(last, time.Date(2020, 6, 8, 6, 0, 0, 0, time.Local)))
+}
+
+func TestScheduleYAML(t *testing.T) {
+	in := `
+- id: live
+  name: Live
+- id: weekly
+  name: Weekly
+  refresh: "0 6 * * mon"
+- id: hourly
+  name: Hourly
+  refresh: 1h
+- id: office
+  name: Office
+  refresh:
+    every: 30m
+    days: mon-fri
+    hours: 09:00-17:00
+`
+	var cs []Collection
+	require.NoError(t, yaml.Unmarshal([]byte(in), &cs))
+	require.Len(t, cs, 4)
+
+	assert.Nil(t, cs[0].Refresh)
+	assert.Equal(t, "cron 0 6 * * mon", cs[1].Refresh.String())
+	assert.Equal(t, "every 1h0m0s", cs[2].Refresh.String())
+	assert.Equal(t, "every 30m0s on mon-fri during 09:00-17:00", cs[3].Refresh.String())
+
+	out, err := yaml.Marshal(cs[3])
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "hours: 09:00-17:00")
+
+	var bad []Collection
+	assert.Error(t, yaml.Unmarshal([]byte("- id: x\n  refresh: sometimes\n"), &bad))
+}
+
+func TestScheduleGob(t *testing.T) {
+	s, err := newSchedule(scheduleConfig{Every: "30m", Days: "mon-fri", Hours: "09:00-17:00"})
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&b).Encode(Collection{ID: "office", Refresh: s}))
+
+	var c Collection
+	require.NoError(t, gob.NewDecoder(&b).Decode(&c))
+	assert.Equal(t, s.String(), c.Refresh.String())
+	assert.Equal(t, s.InWindow(time.Now()), c.Refresh.InWindow(time.Now()))
+}
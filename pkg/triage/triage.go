@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/google/triage-party/pkg/provider"
@@ -38,6 +40,9 @@ type Config struct {
 	GitHubAPIURL string
 	GitHubToken  string
 	GitLabToken  string
+
+	// AnalyzerWorkers is the most items analyzed at once, across all rules
+	AnalyzerWorkers int
 }
 
 type Party struct {
@@ -48,6 +53,8 @@ type Party struct {
 	rules         map[string]Rule
 	reposOverride []string
 	debug         map[int]bool
+	botPatterns   []*regexp.Regexp
+	workers       int
 
 	github provider.Provider
 	gitlab provider.Provider
@@ -58,6 +65,7 @@ func New(cfg Config) (*Party, error) {
 		cache:         cfg.Cache,
 		reposOverride: cfg.Repos,
 		debug:         map[int]bool{},
+		workers:       cfg.AnalyzerWorkers,
 	}
 
 	var err error
@@ -89,11 +97,15 @@ func New(cfg Config) (*Party, error) {
 }
 
 type Settings struct {
-	Name          string   `yaml:"name"`
-	Repos         []string `yaml:"repos"`
-	MinSimilarity float64  `yaml:"min_similarity"`
-	MemberRoles   []string `yaml:"member-roles"`
-	Members       []string `yaml:"members"`
+	Name             string   `yaml:"name"`
+	Repos            []string `yaml:"repos"`
+	MinSimilarity    float64  `yaml:"min_similarity"`
+	SimilarityMethod string   `yaml:"similarity-method"`
+	SimilarityScope  string   `yaml:"similarity-scope"`
+	MemberRoles      []string `yaml:"member-roles"`
+	Members          []string `yaml:"members"`
+	Bots             []string `yaml:"bots"`
+	BotPatterns      []string `yaml:"bot-patterns"`
 }
 
 // diskConfig is the on-disk configuration
@@ -130,8 +142,13 @@ func (p *Party) newEngine() *hubbub.Engine {
 		DebugNumbers:       p.debug,
 		MaxClosedUpdateAge: maxClosedUpdateAge,
 		MinSimilarity:      p.settings.MinSimilarity,
+		SimilarityMethod:   p.settings.SimilarityMethod,
+		SimilarityScope:    p.settings.SimilarityScope,
 		MemberRoles:        roles,
 		Members:            p.settings.Members,
+		Bots:               p.settings.Bots,
+		BotPatterns:        p.botPatterns,
+		AnalyzerWorkers:    p.workers,
 
 		GitLab: p.gitlab,
 		GitHub: p.github,
@@ -168,9 +185,19 @@ func (p *Party) Load(r io.Reader) error {
 		return fmt.Errorf("rule processing: %w", err)
 	}
 
+	botPatterns := []*regexp.Regexp{}
+	for _, bp := range dc.Settings.BotPatterns {
+		re, err := regexp.Compile(bp)
+		if err != nil {
+			return fmt.Errorf("bot pattern %q: %w", bp, err)
+		}
+		botPatterns = append(botPatterns, re)
+	}
+
 	p.collections = dc.RawCollections
 	p.rules = rules
 	p.settings = dc.Settings
+	p.botPatterns = botPatterns
 
 	p.logLoaded()
 	if err := p.validateLoadedConfig(); err != nil {
@@ -248,6 +275,18 @@ func (p *Party) validateLoadedConfig() error {
 		return fmt.Errorf("No 'filters' found in the configuration")
 	}
 
+	switch p.settings.SimilarityMethod {
+	case "", hubbub.TitleSimilarity, hubbub.TFIDFSimilarity:
+	default:
+		return fmt.Errorf("unknown similarity-method: %q", p.settings.SimilarityMethod)
+	}
+
+	switch p.settings.SimilarityScope {
+	case "", hubbub.GlobalScope, hubbub.RepoScope:
+	default:
+		return fmt.Errorf("unknown similarity-scope: %q", p.settings.SimilarityScope)
+	}
+
 	// validate that requested repos map to known providers
 	repos := p.settings.Repos
 	if len(p.reposOverride) > 0 {
@@ -315,6 +354,12 @@ func processRules(raw map[string]Rule) (map[string]Rule, error) {
 				}
 			}
 
+			if f.AuthorIsBot != "" {
+				if _, err := strconv.ParseBool(f.AuthorIsBot); err != nil {
+					return rules, fmt.Errorf("%q author-is-bot: %w", id, err)
+				}
+			}
+
 			if f.RawMilestone != "" {
 				err := f.LoadMilestoneRegex()
 				if err != nil {
@@ -332,6 +377,7 @@ func processRules(raw map[string]Rule) (map[string]Rule, error) {
 			Repos:      t.Repos,
 			Type:       t.Type,
 			Filters:    newfs,
+			FailClosed: t.FailClosed,
 		}
 	}
 
@@ -347,3 +393,23 @@ func (p *Party) ConversationsTotal() int {
 func (p *Party) Name() string {
 	return p.settings.Name
 }
+
+// Clusters returns groups of similar conversations, highest total demand first
+func (p *Party) Clusters() []*hubbub.Cluster {
+	return p.engine.Clusters()
+}
+
+// Quota returns the most constrained API rate limit seen which has not yet reset
+func (p *Party) Quota() (provider.Rate, bool) {
+	return p.engine.Quota()
+}
+
+// AnalyzerMetrics returns a snapshot of the pool which analyzes items for every rule
+func (p *Party) AnalyzerMetrics() hubbub.AnalyzerMetrics {
+	return p.engine.AnalyzerMetrics()
+}
+
+// Conserve sets whether optional API calls are skipped to conserve quota
+func (p *Party) Conserve(on bool) {
+	p.engine.Conserve(on)
+}
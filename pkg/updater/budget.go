@@ -0,0 +1,123 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/triage-party/pkg/logu"
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/google/triage-party/pkg/triage"
+	"k8s.io/klog/v2"
+)
+
+// Fractions of the API quota below which background refreshes are cut back
+const (
+	conserveQuota = 0.20
+	pauseQuota    = 0.02
+)
+
+// budgetMode is how much of the API quota a cycle may spend
+type budgetMode string
+
+const (
+	// normalBudget refreshes collections as usual
+	normalBudget budgetMode = ""
+	// conserveBudget defers low-priority collections and skips timelines for hidden collections
+	conserveBudget budgetMode = "conserving API quota"
+	// pauseBudget defers all background refreshes until the quota resets
+	pauseBudget budgetMode = "API quota exhausted"
+)
+
+// budget is the plan for an update cycle, based on the remaining API quota
+type budget struct {
+	mode     budgetMode
+	rate     provider.Rate
+	deferred []string
+}
+
+func (b budget) String() string {
+	if b.mode == normalBudget {
+		return ""
+	}
+
+	s := fmt.Sprintf("%s: %d of %d remaining until %s", b.mode, b.rate.Remaining, b.rate.Limit, logu.STime(b.rate.Reset.Time))
+	if len(b.deferred) > 0 {
+		s += fmt.Sprintf(", deferring %s", strings.Join(b.deferred, ", "))
+	}
+	return s
+}
+
+// budgetFor returns how much of the quota to spend, given the most constrained rate limit
+func budgetFor(r provider.Rate, ok bool) budgetMode {
+	if !ok || r.Limit <= 0 {
+		return normalBudget
+	}
+
+	left := float64(r.Remaining) / float64(r.Limit)
+	switch {
+	case left < pauseQuota:
+		return pauseBudget
+	case left < conserveQuota:
+		return conserveBudget
+	default:
+		return normalBudget
+	}
+}
+
+// lowPriority returns whether a collection can wait when the quota is low. u.mu must be held.
+func (u *Updater) lowPriority(s triage.Collection) bool {
+	return s.UsedForStats || s.Hidden || u.lastRequested(s.ID).IsZero()
+}
+
+// plan decides which collections to refresh this cycle, returning those to run
+func (u *Updater) plan(sts []triage.Collection) []triage.Collection {
+	r, ok := u.party.Quota()
+	run, mode := u.planFor(sts, r, ok)
+	u.party.Conserve(mode != normalBudget)
+	return run
+}
+
+// planFor decides which collections to refresh given the most constrained rate limit
+func (u *Updater) planFor(sts []triage.Collection, r provider.Rate, ok bool) ([]triage.Collection, budgetMode) {
+	b := budget{mode: budgetFor(r, ok), rate: r}
+
+	run := []triage.Collection{}
+	u.mu.Lock()
+	for _, s := range sts {
+		switch {
+		case b.mode == pauseBudget:
+			b.deferred = append(b.deferred, s.ID)
+		// Collections without any results are never deferred: an empty page is worse than a slow one
+		case b.mode == conserveBudget && u.lowPriority(s) && u.results.result(s.ID) != nil:
+			b.deferred = append(b.deferred, s.ID)
+		default:
+			run = append(run, s)
+		}
+	}
+
+	if b.mode != u.budget.mode {
+		if b.mode == normalBudget {
+			klog.Infof("API quota has recovered (%d of %d remaining): resuming normal refreshes", r.Remaining, r.Limit)
+		} else {
+			klog.Warningf("%s", b)
+		}
+	}
+	u.budget = b
+	u.mu.Unlock()
+
+	return run, b.mode
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/triage-party/pkg/persist"
+	"k8s.io/klog/v2"
+)
+
+// leaseName is the lease held by the replica which refreshes collections
+const leaseName = "updater"
+
+var (
+	// leaseTTL is how long a leader may go without renewing its lease before another replica takes over
+	leaseTTL = 30 * time.Second
+
+	// followEvery is how often followers load collection results published by the leader
+	followEvery = 15 * time.Second
+)
+
+// DefaultReplica identifies this process in leader elections: its hostname, which is the pod name within Kubernetes
+func DefaultReplica() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// elector tracks whether this replica is the leader, renewing its lease in the background
+type elector struct {
+	leaser  persist.Leaser
+	replica string
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	leader  string
+	leading bool
+	renewed time.Time
+	// lost is closed when this replica stops leading
+	lost chan struct{}
+}
+
+func newElector(l persist.Leaser, replica string) *elector {
+	if replica == "" {
+		replica = DefaultReplica()
+	}
+	return &elector{leaser: l, replica: replica, ttl: leaseTTL}
+}
+
+// run renews or takes the lease every ttl/3 until the context is cancelled, then releases it.
+//
+// Renewing on its own schedule keeps the lease while a refresh cycle takes longer than the lease.
+func (e *elector) run(ctx context.Context) {
+	defer e.release()
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.elect()
+	}
+}
+
+// elect takes or renews the lease, returning whether this replica leads
+func (e *elector) elect() bool {
+	holder, err := e.leaser.AcquireLease(leaseName, e.replica, e.ttl)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if err != nil {
+		klog.Errorf("lease %q: %v", leaseName, err)
+		// Step down before the lease could expire, as another replica may then take it
+		if e.leading && now.Sub(e.renewed) >= e.ttl*2/3 {
+			klog.Warningf("%s is unable to renew its lease: no longer refreshing collections", e.replica)
+			e.stepDownLocked()
+		}
+		return e.leading
+	}
+
+	leading := holder == e.replica
+	if leading {
+		e.renewed = now
+	}
+
+	if leading != e.leading || holder != e.leader {
+		if leading {
+			klog.Infof("%s is now the leader: refreshing collections", e.replica)
+		} else {
+			klog.Infof("%s is following %s: loading collection results from the shared cache", e.replica, holder)
+		}
+	}
+
+	if leading && !e.leading {
+		e.lost = make(chan struct{})
+	}
+	if !leading && e.leading {
+		e.stepDownLocked()
+	}
+
+	e.leader = holder
+	e.leading = leading
+	return leading
+}
+
+// stepDownLocked stops leading, cancelling any work which relied on it. e.mu must be held.
+func (e *elector) stepDownLocked() {
+	if e.leading {
+		close(e.lost)
+	}
+	e.leading = false
+}
+
+// isLeading returns whether this replica still leads, without renewing.
+// A lease which has gone unrenewed for most of its TTL no longer counts, as it may be about to expire.
+func (e *elector) isLeading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading && time.Since(e.renewed) < e.ttl*2/3
+}
+
+// term returns a context for work which only the leader may do, which is cancelled should this replica
+// stop leading. Returns false if this replica does not lead.
+func (e *elector) term(ctx context.Context) (context.Context, context.CancelFunc, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.leading {
+		return ctx, func() {}, false
+	}
+
+	tctx, cancel := context.WithCancel(ctx)
+	go func(lost chan struct{}) {
+		select {
+		case <-lost:
+			cancel()
+		case <-tctx.Done():
+		}
+	}(e.lost)
+	return tctx, cancel, true
+}
+
+// following returns the leader this replica follows
+func (e *elector) following() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// release gives up the lease, so that another replica can take over without waiting for it to expire
+func (e *elector) release() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.leading {
+		return
+	}
+
+	if err := e.leaser.ReleaseLease(leaseName, e.replica); err != nil {
+		klog.Errorf("release %q: %v", leaseName, err)
+	}
+	e.stepDownLocked()
+}
+
+// elect starts renewing the lease in the background, after a first election. The returned function
+// stops renewing and releases the lease.
+func (u *Updater) elect(ctx context.Context) func() {
+	u.elector.elect()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		u.elector.run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// follow loads collection results published by the leader, if it is time to
+func (u *Updater) follow() error {
+	u.mu.Lock()
+	due := time.Since(u.lastFollow) >= followEvery
+	if due {
+		u.lastFollow = time.Now()
+	}
+	u.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+
+	sts, err := u.party.ListCollections()
+	if err != nil {
+		return err
+	}
+
+	loaded := 0
+	for _, s := range sts {
+		if u.adopt(s.ID) {
+			loaded++
+		}
+	}
+
+	klog.V(1).Infof("loaded %d of %d collections from the shared cache", loaded, len(sts))
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/google/triage-party/pkg/logu"
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/triage"
+	"k8s.io/klog/v2"
+)
+
+// resultKey is the cache key a collection result is persisted under
+func resultKey(id string) string {
+	return fmt.Sprintf("%s-collection", id)
+}
+
+// saveResult persists a collection result, so that it survives restarts and can be shared with other replicas.
+//
+// Results are already held by the store, so they are written to the persistent layer alone, and not at all
+// by backends which only keep entries in memory.
+func (u *Updater) saveResult(id string, r *triage.CollectionResult) error {
+	st, ok := u.cache.(persist.Storer)
+	if !ok {
+		return nil
+	}
+
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(r); err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	return st.Store(resultKey(id), &persist.Blob{Created: r.Created, Data: b.Bytes()})
+}
+
+// loadResult returns a persisted collection result, if one was created after newerThan
+func (u *Updater) loadResult(id string, newerThan time.Time) (*triage.CollectionResult, error) {
+	bl := u.cache.Get(resultKey(id), newerThan)
+	if bl == nil || len(bl.Data) == 0 {
+		return nil, nil
+	}
+
+	r := &triage.CollectionResult{}
+	if err := gob.NewDecoder(bytes.NewReader(bl.Data)).Decode(r); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return r, nil
+}
+
+// adopt stores a persisted collection result if it is newer than ours, returning whether it was
+func (u *Updater) adopt(id string) bool {
+	if u.cache == nil {
+		return false
+	}
+
+	var newerThan time.Time
+	if cur := u.results.result(id); cur != nil {
+		newerThan = cur.Created.Add(time.Nanosecond)
+	}
+
+	r, err := u.loadResult(id, newerThan)
+	if err != nil {
+		klog.Errorf("persisted result for %q: %v", id, err)
+		return false
+	}
+	if r == nil {
+		return false
+	}
+
+	sn := u.results.put(id, r)
+	klog.Infof("loaded %q v%d from the persistent cache (created %s)", id, sn.Version, logu.STime(r.Created))
+	return true
+}
+
+// Restore loads the collection results persisted before a restart, so that they can be served while the
+// first refresh runs. Restored results are marked as such until they are replaced. Returns the number restored.
+func (u *Updater) Restore() (int, error) {
+	if u.cache == nil {
+		return 0, nil
+	}
+
+	sts, err := u.party.ListCollections()
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for _, s := range sts {
+		s := s
+		r, err := u.loadResult(s.ID, time.Time{})
+		if err != nil {
+			klog.Errorf("unable to restore %q: %v", s.ID, err)
+			continue
+		}
+		if r == nil {
+			continue
+		}
+
+		// The configuration may have changed since the result was saved
+		r.Collection = &s
+		r.Restored = true
+
+		// Never replace results which were refreshed while restoring
+		if u.results.result(s.ID) != nil {
+			continue
+		}
+		u.results.put(s.ID, r)
+		restored++
+	}
+
+	klog.Infof("restored %d of %d collections from %s", restored, len(sts), u.cache)
+	return restored, nil
+}
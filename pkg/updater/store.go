@@ -0,0 +1,118 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/triage-party/pkg/triage"
+)
+
+// DefaultHistory is how many snapshots of each collection are retained if unset
+const DefaultHistory = 5
+
+// Snapshot is a result for a collection. Snapshots are never modified once stored.
+type Snapshot struct {
+	// Version increases by one each time the collection is stored
+	Version int64
+	Stored  time.Time
+	Result  *triage.CollectionResult
+}
+
+// store holds the latest snapshot of each collection, along with recent history.
+//
+// Reading the latest snapshot is lock-free: each store swaps in a new snapshot atomically.
+type store struct {
+	history int
+
+	// collection ID -> *slot
+	slots sync.Map
+}
+
+// slot holds the snapshots of a single collection
+type slot struct {
+	// current holds the latest *Snapshot
+	current atomic.Value
+
+	// mu serializes writers and guards snapshots
+	mu        sync.Mutex
+	snapshots []*Snapshot
+}
+
+func newStore(history int) *store {
+	if history < 1 {
+		history = DefaultHistory
+	}
+	return &store{history: history}
+}
+
+// latest returns the most recent snapshot of a collection, or nil if there is none
+func (s *store) latest(id string) *Snapshot {
+	x, ok := s.slots.Load(id)
+	if !ok {
+		return nil
+	}
+
+	sn, _ := x.(*slot).current.Load().(*Snapshot)
+	return sn
+}
+
+// result returns the most recent result for a collection, or nil if there is none
+func (s *store) result(id string) *triage.CollectionResult {
+	sn := s.latest(id)
+	if sn == nil {
+		return nil
+	}
+	return sn.Result
+}
+
+// put stores a new result for a collection, returning its snapshot
+func (s *store) put(id string, r *triage.CollectionResult) *Snapshot {
+	x, _ := s.slots.LoadOrStore(id, &slot{})
+	sl := x.(*slot)
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sn := &Snapshot{Version: 1, Stored: time.Now(), Result: r}
+	if n := len(sl.snapshots); n > 0 {
+		sn.Version = sl.snapshots[n-1].Version + 1
+	}
+
+	// Copy rather than append in place, so that slices handed out by snapshots are never modified
+	keep := sl.snapshots
+	if len(keep) >= s.history {
+		keep = keep[len(keep)-s.history+1:]
+	}
+	sl.snapshots = append(append([]*Snapshot{}, keep...), sn)
+
+	sl.current.Store(sn)
+	return sn
+}
+
+// snapshots returns the retained snapshots of a collection, oldest first
+func (s *store) snapshots(id string) []*Snapshot {
+	x, ok := s.slots.Load(id)
+	if !ok {
+		return nil
+	}
+
+	sl := x.(*slot)
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.snapshots
+}
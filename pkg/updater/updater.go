@@ -18,10 +18,14 @@ package updater
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/triage-party/pkg/hubbub"
 	"github.com/google/triage-party/pkg/logu"
+	"github.com/google/triage-party/pkg/persist"
 	"github.com/google/triage-party/pkg/triage"
 
 	"k8s.io/klog/v2"
@@ -30,44 +34,91 @@ import (
 // Minimum age to flush to avoid bad behavior
 const minFlushAge = 5 * time.Second
 
+// DefaultConcurrency is how many collections are refreshed at once if unset
+const DefaultConcurrency = 4
+
 type PFunc = func() error
 
 type Config struct {
 	Party      *triage.Party
 	MinRefresh time.Duration
 	MaxRefresh time.Duration
+	// Concurrency is the maximum number of collections refreshed at once by the update loop
+	Concurrency int
+	// History is the number of snapshots retained for each collection
+	History int
+
+	// Cache is where collection results are persisted across restarts and shared between replicas
+	Cache persist.Cacher
+	// Elect enables leader election through the cache, so that only one replica refreshes collections
+	Elect bool
+	// Replica identifies this process in leader elections (default: DefaultReplica)
+	Replica string
 }
 
 func New(cfg Config) *Updater {
-	return &Updater{
+	concurrency := cfg.Concurrency
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+
+	u := &Updater{
 		party:             cfg.Party,
 		maxRefresh:        cfg.MaxRefresh,
 		minRefresh:        cfg.MinRefresh,
+		concurrency:       concurrency,
 		idleDuration:      5 * time.Minute,
-		cache:             map[string]*triage.CollectionResult{},
+		results:           newStore(cfg.History),
+		inflight:          map[string]*refresh{},
 		lastRequest:       sync.Map{},
 		secondLastRequest: sync.Map{},
 		loopEvery:         250 * time.Millisecond,
-		mutex:             &sync.Mutex{},
 		startTime:         time.Time{},
+		cache:             cfg.Cache,
 	}
+
+	if cfg.Elect {
+		if l, ok := cfg.Cache.(persist.Leaser); ok {
+			u.elector = newElector(l, cfg.Replica)
+		} else {
+			klog.Warningf("%v does not support leader election: every replica will refresh collections", cfg.Cache)
+		}
+	}
+
+	return u
 }
 
 type Updater struct {
 	party             *triage.Party
 	maxRefresh        time.Duration
 	minRefresh        time.Duration
+	concurrency       int
 	idleDuration      time.Duration
-	cache             map[string]*triage.CollectionResult
 	lastRequest       sync.Map
 	secondLastRequest sync.Map
-	lastRun           time.Time
-	startTime         time.Time
 	loopEvery         time.Duration
-	mutex             *sync.Mutex
-	updateCycles      int
+	results           *store
+	cache             persist.Cacher
+
+	// elector decides which replica refreshes collections, if leader election is enabled
+	elector *elector
+
+	// mu guards the fields below
+	mu           sync.Mutex
+	inflight     map[string]*refresh
+	lastRun      time.Time
+	startTime    time.Time
+	updateCycles int
+	state        string
+	budget       budget
+	lastFollow   time.Time
+}
 
-	state string
+// refresh is an in-flight update of a collection, which concurrent callers may wait on
+type refresh struct {
+	newerThan time.Time
+	done      chan struct{}
+	err       error
 }
 
 // recordAccess records stats on collection accesses
@@ -81,25 +132,58 @@ func (u *Updater) recordAccess(id string) {
 
 // State returns a basic state
 func (u *Updater) Status() string {
-	return fmt.Sprintf("%s (%d cycles, %s uptime)", u.state, u.updateCycles, time.Since(u.startTime))
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	state := u.state
+	if len(u.inflight) > 0 {
+		ids := []string{}
+		for id := range u.inflight {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		state = fmt.Sprintf("updating %s", strings.Join(ids, ", "))
+	}
+	status := fmt.Sprintf("%s (%d cycles, %s uptime)", state, u.updateCycles, time.Since(u.startTime))
+	if b := u.budget.String(); b != "" {
+		status += " - " + b
+	}
+	return status
+}
+
+// setState sets the state shown when no collections are being updated
+func (u *Updater) setState(format string, args ...interface{}) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.state = fmt.Sprintf(format, args...)
+}
+
+// History returns the retained snapshots of a collection, oldest first
+func (u *Updater) History(id string) []*Snapshot {
+	return u.results.snapshots(id)
 }
 
 // Lookup results for a given metric
 func (u *Updater) Lookup(ctx context.Context, id string, blocking bool) *triage.CollectionResult {
 	defer u.recordAccess(id)
-	r := u.cache[id]
+	r := u.results.result(id)
+
+	// Followers may not have loaded the leader's results yet
+	if r == nil && u.elector != nil && u.adopt(id) {
+		return u.results.result(id)
+	}
+
 	if r == nil {
 		if blocking {
 			klog.Warningf("%s is not available in the cache, blocking page load!", id)
-			if _, err := u.RefreshCollection(ctx, id, time.Time{}, true); err != nil {
+			if _, err := u.RefreshCollection(hubbub.OnDemand(ctx), id, time.Time{}, true); err != nil {
 				klog.Errorf("unable to run %s: %v", id, err)
 			}
 		} else {
 			klog.Warningf("%s unavailable, but not blocking: happily returning nil", id)
 		}
 	}
-	r = u.cache[id]
-	return r
+	return u.results.result(id)
 }
 
 func (u *Updater) ForceRefresh(ctx context.Context, id string) *triage.CollectionResult {
@@ -117,30 +201,45 @@ func (u *Updater) ForceRefresh(ctx context.Context, id string) *triage.Collectio
 	newerThan := start.Add(-1 * time.Second)
 
 	klog.Infof("Forcing %s to refresh with data from %s or newer", id, newerThan)
-	if _, err := u.RefreshCollection(ctx, id, newerThan, true); err != nil {
+	if _, err := u.RefreshCollection(hubbub.OnDemand(ctx), id, newerThan, true); err != nil {
 		klog.Errorf("update failed: %v", err)
 	}
 	klog.Infof("refresh complete for %s after %s", id, time.Since(start))
-	return u.cache[id]
+	return u.results.result(id)
 }
 
-// shouldUpdate returns an error if a collection needs an update
-func (u *Updater) shouldUpdate(id string, usedForStats bool, force bool) error {
+// shouldUpdate returns an error if a collection needs an update. u.mu must be held.
+func (u *Updater) shouldUpdate(s triage.Collection, force bool) error {
+	id := s.ID
+
 	// The first cycle is based on a pared down set of results for faster initial load
 	if u.updateCycles < 2 {
 		return fmt.Errorf("cycle count is only %d", u.updateCycles)
 	}
 
-	result, ok := u.cache[id]
-	if !ok {
+	result := u.results.result(id)
+	if result == nil {
 		return fmt.Errorf("results are not cached")
 	}
 
+	if force {
+		return fmt.Errorf("force-mode enabled")
+	}
+
+	// Scheduled collections are refreshed on their own timetable, regardless of popularity
+	if s.Refresh != nil {
+		if s.Refresh.Due(result.Created, time.Now()) {
+			return fmt.Errorf("%s at %s is due per its refresh schedule (%s)", id, logu.STime(result.Created), s.Refresh)
+		}
+		klog.V(4).Infof("%q is not due until %s (%s)", id, logu.STime(s.Refresh.Next(result.Created)), s.Refresh)
+		return nil
+	}
+
 	resultAge := time.Since(result.Created)
 	maxRefresh := u.maxRefresh
 
 	// stats-based metrics can wait longer to refresh
-	if usedForStats {
+	if s.UsedForStats {
 		maxRefresh *= 3
 	}
 
@@ -148,10 +247,6 @@ func (u *Updater) shouldUpdate(id string, usedForStats bool, force bool) error {
 		return fmt.Errorf("%s at %s is older than max refresh age (%s), should update", id, logu.STime(result.Created), resultAge)
 	}
 
-	if force {
-		return fmt.Errorf("force-mode enabled")
-	}
-
 	// collection has never been requested.
 	if u.lastRequested(id).IsZero() {
 		klog.V(4).Infof("%q has never been requested", id)
@@ -167,7 +262,7 @@ func (u *Updater) shouldUpdate(id string, usedForStats bool, force bool) error {
 	requestAge := time.Since(u.lastRequested(id))
 	secondRequestDiff := u.lastRequested(id).Sub(u.secondLastRequested(id))
 	needAge := ((requestAge + secondRequestDiff) / 2) + u.minRefresh
-	if resultAge > needAge && !usedForStats {
+	if resultAge > needAge && !s.UsedForStats {
 		return fmt.Errorf("result age (%s) too old based on popularity", resultAge)
 	}
 
@@ -190,7 +285,7 @@ func (u *Updater) lastRequested(id string) time.Time {
 	return lr
 }
 
-// secondLastRequested is the second last time someone requested to view a collection
+// secondLastRequested is the second last time someone requested to view a collection. u.mu must be held.
 func (u *Updater) secondLastRequested(id string) time.Time {
 	x, ok := u.secondLastRequest.Load(id)
 	if !ok {
@@ -207,51 +302,86 @@ func (u *Updater) secondLastRequested(id string) time.Time {
 
 func (u *Updater) update(ctx context.Context, s triage.Collection, newerThan time.Time) error {
 	start := time.Now()
-	u.state = fmt.Sprintf("updating %s to %s", s.ID, logu.STime(newerThan))
 
 	klog.Infof(">>> updating %q with data newer than %s >>>", s.ID, logu.STime(newerThan))
 	r, err := u.party.ExecuteCollection(ctx, s, newerThan)
 	if err != nil {
 		return err
 	}
-	u.cache[s.ID] = r
-	klog.Infof("<<< updated %q to %s (oldest input: %s, duration: %s) <<<", s.ID, logu.STime(r.Created), logu.STime(r.OldestInput), time.Since(start))
+
+	sn := u.results.put(s.ID, r)
+	klog.Infof("<<< updated %q to v%d at %s (oldest input: %s, duration: %s) <<<", s.ID, sn.Version, logu.STime(r.Created), logu.STime(r.OldestInput), time.Since(start))
+
+	// Followers leave persisting results to the leader. Leadership is checked again here,
+	// as it may have been lost while the collection was being refreshed.
+	if u.cache != nil && (u.elector == nil || u.elector.isLeading()) {
+		if err := u.saveResult(s.ID, r); err != nil {
+			klog.Errorf("unable to persist %q: %v", s.ID, err)
+		}
+	}
 	return nil
 }
 
-// Run a single collection, optionally forcing an update
+// Run a single collection, optionally forcing an update.
+//
+// If the collection is already being refreshed, the caller waits for that refresh instead of starting another.
 func (u *Updater) RefreshCollection(ctx context.Context, id string, newerThan time.Time, force bool) (bool, error) {
-	klog.V(5).Infof("RefreshCollection: %s newer than %s, force=%v (locking mutex)", id, newerThan, force)
-	u.mutex.Lock()
-	defer u.mutex.Unlock()
+	klog.V(5).Infof("RefreshCollection: %s newer than %s, force=%v", id, newerThan, force)
 
 	s, err := u.party.LookupCollection(id)
 	if err != nil {
 		return false, err
 	}
 
-	err = u.shouldUpdate(s.ID, s.UsedForStats, force)
-	if err == nil {
-		return false, nil
-	}
+	for {
+		u.mu.Lock()
+		rf := u.inflight[id]
+		if rf != nil {
+			u.mu.Unlock()
+			klog.V(1).Infof("%q is already being refreshed with data newer than %s, waiting", id, logu.STime(rf.newerThan))
+
+			select {
+			case <-rf.done:
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
 
-	klog.Infof("reason for updating %q: %v", s.ID, err)
-	err = u.update(ctx, s, newerThan)
-	return true, err
+			if !rf.newerThan.Before(newerThan) {
+				return true, rf.err
+			}
+			// The refresh we waited for may have used data older than we need
+			continue
+		}
+
+		err = u.shouldUpdate(s, force)
+		if err == nil {
+			u.mu.Unlock()
+			return false, nil
+		}
+
+		rf = &refresh{newerThan: newerThan, done: make(chan struct{})}
+		u.inflight[id] = rf
+		u.mu.Unlock()
+
+		klog.Infof("reason for updating %q: %v", s.ID, err)
+		rf.err = u.update(ctx, s, newerThan)
+
+		u.mu.Lock()
+		delete(u.inflight, id)
+		u.mu.Unlock()
+		close(rf.done)
+
+		return true, rf.err
+	}
 }
 
-// Run once, optionally forcing an update
+// Run once, optionally forcing an update.
+//
+// Collections are refreshed concurrently, by up to the configured number of workers.
+// When the API quota runs low, low-priority collections are deferred until it resets.
 func (u *Updater) RunOnce(ctx context.Context, force bool) (bool, error) {
-	updated := false
 	start := time.Now()
 
-	defer func() {
-		if updated {
-			klog.Infof("update cycle #%d took %s", u.updateCycles, time.Since(start))
-			u.updateCycles++
-		}
-	}()
-
 	if force {
 		klog.Warning(">>> RunOnce has force enabled")
 	} else {
@@ -260,58 +390,138 @@ func (u *Updater) RunOnce(ctx context.Context, force bool) (bool, error) {
 
 	sts, err := u.party.ListCollections()
 	if err != nil {
-		updated = false
-		return updated, err
+		return false, err
 	}
 
+	u.mu.Lock()
 	if u.lastRun.IsZero() {
 		u.startTime = time.Now()
 		force = true
 	}
+	cycles := u.updateCycles
+	u.mu.Unlock()
 
 	newerThan := start.Add(-2 * minFlushAge)
-	if u.updateCycles == 0 {
+	if cycles == 0 {
 		klog.Info("have not yet completed a cycle - will accept stale results")
 		newerThan = time.Time{}
 	}
 
-	var failed []string
+	sts = u.plan(sts)
+
+	numWorkers := u.concurrency
+	if len(sts) < numWorkers {
+		numWorkers = len(sts)
+	}
+
+	jobs := make(chan string, len(sts))
 	for _, s := range sts {
-		// Run all collections with the same timestamp for maximum cache sharing
-		runUpdated, err := u.RefreshCollection(ctx, s.ID, newerThan, force)
-		if err != nil {
-			klog.Errorf("%s failed to update: %v", s.ID, err)
-			failed = append(failed, s.ID)
-			continue
-		}
-		if runUpdated {
-			updated = true
-		}
+		jobs <- s.ID
+	}
+	close(jobs)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var failed []string
+	updated := false
+
+	for w := 1; w <= numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				// Leave the remaining collections for the next cycle
+				if ctx.Err() != nil {
+					continue
+				}
+
+				// Run all collections with the same timestamp for maximum cache sharing
+				runUpdated, err := u.RefreshCollection(ctx, id, newerThan, force)
+
+				mu.Lock()
+				if err != nil {
+					klog.Errorf("%s failed to update: %v", id, err)
+					failed = append(failed, id)
+				}
+				if runUpdated {
+					updated = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if updated {
+		u.mu.Lock()
+		klog.Infof("update cycle #%d took %s (%d workers)", u.updateCycles, time.Since(start), numWorkers)
+		u.updateCycles++
+		u.mu.Unlock()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return updated, err
 	}
 
 	if len(failed) > 0 {
+		sort.Strings(failed)
 		return updated, fmt.Errorf("collections failed: %v", failed)
 	}
 
 	return updated, nil
 }
 
-// Update loop
+// Update loop, which runs until the context is cancelled.
+//
+// With leader election enabled, only the leader refreshes collections: other replicas load its results from the shared cache.
 func (u *Updater) Loop(ctx context.Context) error {
+	u.mu.Lock()
 	u.state = "starting loop"
+	if u.startTime.IsZero() {
+		u.startTime = time.Now()
+	}
+	u.mu.Unlock()
+
+	if u.elector != nil {
+		defer u.elect(ctx)()
+	}
 
 	// Loop if everything goes to plan
-	klog.Infof("Looping: data will be updated between %s and %s (loop every %s)", u.minRefresh, u.maxRefresh, u.loopEvery)
+	klog.Infof("Looping: data will be updated between %s and %s (loop every %s, %d at a time)", u.minRefresh, u.maxRefresh, u.loopEvery, u.concurrency)
 	ticker := time.NewTicker(u.loopEvery)
 	defer ticker.Stop()
-	for range ticker.C {
-		_, err := u.RunOnce(ctx, false)
-		if err != nil {
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Infof("update loop stopping: %v", ctx.Err())
+			u.setState("stopped")
+			return nil
+		case <-ticker.C:
+		}
+
+		// Each cycle is cancelled should this replica stop leading part way through
+		cctx, cancel := ctx, context.CancelFunc(func() {})
+		if u.elector != nil {
+			var leading bool
+			cctx, cancel, leading = u.elector.term(ctx)
+			if !leading {
+				if err := u.follow(); err != nil {
+					klog.Errorf("follow: %v", err)
+				}
+				u.setState("following %s", u.elector.following())
+				continue
+			}
+		}
+
+		_, err := u.RunOnce(cctx, false)
+		cancel()
+		if err != nil && cctx.Err() == nil {
 			klog.Errorf("err: %v", err)
 		}
 
+		u.mu.Lock()
 		u.state = fmt.Sprintf("idle, waiting %s", u.loopEvery)
 		u.lastRun = time.Now()
+		u.mu.Unlock()
 	}
-	return nil
 }
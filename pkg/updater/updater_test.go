@@ -0,0 +1,484 @@
+// Copyright 2020 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updater
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/triage-party/pkg/persist"
+	"github.com/google/triage-party/pkg/provider"
+	"github.com/google/triage-party/pkg/triage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfig defines collections without rules, so that refreshing them never reaches a provider
+var testConfig = `
+settings:
+  repos:
+    - https://github.com/org/project
+collections:
+  - id: a
+    name: A
+  - id: b
+    name: B
+  - id: hourly
+    name: Hourly
+    refresh: 1h
+  - id: unused
+    name: Unused
+    rules:
+      - r
+rules:
+  r:
+    name: R
+    filters:
+      - label: bug
+`
+
+func newTestUpdater(t *testing.T, history int) *Updater {
+	t.Helper()
+	return newTestUpdaterWithAPI(t, history, "http://127.0.0.1:1/")
+}
+
+// newTestUpdaterWithAPI returns an updater whose GitHub API calls are sent to url
+func newTestUpdaterWithAPI(t *testing.T, history int, url string) *Updater {
+	t.Helper()
+
+	c, err := persist.NewMemory(persist.Config{})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+
+	p, err := triage.New(triage.Config{Cache: c, GitHubToken: "unused", GitHubAPIURL: url})
+	require.NoError(t, err)
+	require.NoError(t, p.Load(strings.NewReader(testConfig)))
+
+	return New(Config{Party: p, History: history})
+}
+
+func TestStore(t *testing.T) {
+	s := newStore(3)
+	assert.Nil(t, s.latest("a"))
+	assert.Nil(t, s.result("a"))
+	assert.Empty(t, s.snapshots("a"))
+
+	var rs []*triage.CollectionResult
+	for i := 0; i < 5; i++ {
+		r := &triage.CollectionResult{Total: i}
+		rs = append(rs, r)
+		sn := s.put("a", r)
+		assert.Equal(t, int64(i+1), sn.Version)
+	}
+
+	assert.Equal(t, rs[4], s.result("a"))
+	assert.Equal(t, int64(5), s.latest("a").Version)
+
+	sns := s.snapshots("a")
+	require.Len(t, sns, 3)
+	for i, sn := range sns {
+		assert.Equal(t, int64(i+3), sn.Version)
+		assert.Equal(t, rs[i+2], sn.Result)
+	}
+
+	// Earlier history is unaffected by later writes
+	s.put("a", &triage.CollectionResult{})
+	assert.Equal(t, int64(3), sns[0].Version)
+	assert.Nil(t, s.result("b"))
+}
+
+// TestLookupRace hammers Lookup and ForceRefresh concurrently. Run it with the race detector:
+//
+//	go test -race ./pkg/updater/
+func TestLookupRace(t *testing.T) {
+	u := newTestUpdater(t, 2)
+	ctx := context.Background()
+	ids := []string{"a", "b"}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			seen := map[string]int64{}
+			for i := 0; i < 50; i++ {
+				id := ids[(w+i)%len(ids)]
+
+				var r *triage.CollectionResult
+				switch i % 4 {
+				case 0:
+					r = u.ForceRefresh(ctx, id)
+				case 1:
+					r = u.Lookup(ctx, id, true)
+				default:
+					r = u.Lookup(ctx, id, false)
+				}
+
+				if r == nil {
+					// Only a non-blocking lookup may race ahead of the first refresh
+					assert.NotEqual(t, 0, i%4, fmt.Sprintf("worker %d: %s", w, id))
+					assert.NotEqual(t, 1, i%4, fmt.Sprintf("worker %d: %s", w, id))
+					continue
+				}
+				assert.Equal(t, id, r.Collection.ID)
+
+				// Versions never go backwards
+				if sn := u.results.latest(id); sn != nil {
+					assert.GreaterOrEqual(t, sn.Version, seen[id])
+					seen[id] = sn.Version
+				}
+
+				_ = u.Status()
+				assert.LessOrEqual(t, len(u.History(id)), 2)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		sn := u.results.latest(id)
+		require.NotNil(t, sn, id)
+		assert.Greater(t, sn.Version, int64(1))
+		assert.Len(t, u.History(id), 2)
+	}
+	assert.Nil(t, u.results.latest("unused"))
+}
+
+func TestScheduledRefresh(t *testing.T) {
+	u := newTestUpdater(t, 0)
+	ctx := context.Background()
+
+	// Skip the initial cycles, which refresh everything
+	u.updateCycles = 2
+
+	updated, err := u.RefreshCollection(ctx, "hourly", time.Time{}, false)
+	require.NoError(t, err)
+	assert.True(t, updated, "uncached results should be refreshed")
+
+	// Results older than --max-refresh are left alone until the schedule says they are due
+	u.maxRefresh = time.Minute
+	u.results.put("hourly", &triage.CollectionResult{Created: time.Now().Add(-5 * time.Minute)})
+	updated, err = u.RefreshCollection(ctx, "hourly", time.Time{}, false)
+	require.NoError(t, err)
+	assert.False(t, updated)
+
+	u.results.put("hourly", &triage.CollectionResult{Created: time.Now().Add(-61 * time.Minute)})
+	updated, err = u.RefreshCollection(ctx, "hourly", time.Time{}, false)
+	require.NoError(t, err)
+	assert.True(t, updated, "overdue results should be refreshed")
+
+	// On-demand refreshes are always honored
+	updated, err = u.RefreshCollection(ctx, "hourly", time.Now(), true)
+	require.NoError(t, err)
+	assert.True(t, updated)
+}
+
+func TestBudgetFor(t *testing.T) {
+	tests := []struct {
+		remaining int
+		limit     int
+		ok        bool
+		want      budgetMode
+	}{
+		{0, 0, false, normalBudget},
+		{0, 0, true, normalBudget},
+		{4000, 5000, true, normalBudget},
+		{999, 5000, true, conserveBudget},
+		{99, 5000, true, pauseBudget},
+		{0, 5000, true, pauseBudget},
+	}
+
+	for _, tc := range tests {
+		r := provider.Rate{Remaining: tc.remaining, Limit: tc.limit}
+		assert.Equal(t, tc.want, budgetFor(r, tc.ok), "%d of %d", tc.remaining, tc.limit)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	u := newTestUpdater(t, 0)
+	sts, err := u.party.ListCollections()
+	require.NoError(t, err)
+
+	ids := func(sts []triage.Collection) []string {
+		var ids []string
+		for _, s := range sts {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+
+	reset := provider.Timestamp{Time: time.Now().Add(30 * time.Minute)}
+	low := provider.Rate{Remaining: 500, Limit: 5000, Reset: reset}
+
+	// Nothing is deferred until it has results to show
+	run, mode := u.planFor(sts, low, true)
+	assert.Equal(t, conserveBudget, mode)
+	assert.Equal(t, []string{"a", "b", "hourly", "unused"}, ids(run))
+
+	u.results.put("a", &triage.CollectionResult{})
+	u.results.put("b", &triage.CollectionResult{})
+	u.recordAccess("a")
+
+	run, _ = u.planFor(sts, low, true)
+	assert.Equal(t, []string{"a", "hourly", "unused"}, ids(run))
+	assert.Contains(t, u.Status(), "conserving API quota: 500 of 5000 remaining")
+	assert.Contains(t, u.Status(), "deferring b")
+
+	run, mode = u.planFor(sts, provider.Rate{Remaining: 10, Limit: 5000, Reset: reset}, true)
+	assert.Equal(t, pauseBudget, mode)
+	assert.Empty(t, run)
+	assert.Contains(t, u.Status(), "deferring a, b, hourly, unused")
+
+	// Once the quota resets, the engine no longer reports it
+	run, mode = u.planFor(sts, provider.Rate{}, false)
+	assert.Equal(t, normalBudget, mode)
+	assert.Len(t, run, 4)
+	assert.NotContains(t, u.Status(), "quota")
+}
+
+func TestCancelMidCollection(t *testing.T) {
+	// The API hangs until the client gives up
+	requested := make(chan struct{}, 16)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer api.Close()
+
+	u := newTestUpdaterWithAPI(t, 0, api.URL+"/")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := u.RefreshCollection(ctx, "unused", time.Time{}, true)
+		errc <- err
+	}()
+
+	<-requested
+	cancel()
+
+	select {
+	case err := <-errc:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(10 * time.Second):
+		t.Fatal("refresh was not cancelled")
+	}
+
+	// Cancelled refreshes leave no partial results behind
+	assert.Nil(t, u.results.latest("unused"))
+	assert.NotContains(t, u.Status(), "updating")
+}
+
+func TestLoopStops(t *testing.T) {
+	u := newTestUpdater(t, 0)
+	u.loopEvery = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- u.Loop(ctx)
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("loop did not stop")
+	}
+	assert.Contains(t, u.Status(), "stopped")
+
+	updated, err := u.RunOnce(ctx, true)
+	assert.False(t, updated)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// newReplica returns an updater which persists results to a SQLite cache shared with other replicas.
+// If replica is set, the updater also elects a leader through the cache.
+func newReplica(t *testing.T, path string, replica string) *Updater {
+	t.Helper()
+
+	c, err := persist.NewSQLite(persist.Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+	t.Cleanup(func() { c.Close() })
+
+	p, err := triage.New(triage.Config{Cache: c, GitHubToken: "unused", GitHubAPIURL: "http://127.0.0.1:1/"})
+	require.NoError(t, err)
+	require.NoError(t, p.Load(strings.NewReader(testConfig)))
+
+	return New(Config{Party: p, Cache: c, Elect: replica != "", Replica: replica})
+}
+
+func TestLeaderElection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	a := newReplica(t, path, "a")
+	b := newReplica(t, path, "b")
+
+	assert.True(t, a.elector.elect())
+	assert.False(t, b.elector.elect())
+	assert.Equal(t, "a", b.elector.following())
+
+	// Followers load results published by the leader
+	s, err := a.party.LookupCollection("hourly")
+	require.NoError(t, err)
+	r := &triage.CollectionResult{Collection: &s, Created: time.Now().Add(-time.Minute)}
+	require.NoError(t, a.saveResult("hourly", r))
+
+	got := b.Lookup(context.Background(), "hourly", false)
+	require.NotNil(t, got)
+	assert.True(t, r.Created.Equal(got.Created))
+	assert.Equal(t, "every 1h0m0s", got.Collection.Refresh.String())
+	assert.False(t, b.adopt("hourly"), "results which are not newer are not adopted again")
+
+	r = &triage.CollectionResult{Collection: &s, Created: time.Now()}
+	require.NoError(t, a.saveResult("hourly", r))
+	assert.True(t, b.adopt("hourly"))
+	assert.Len(t, b.History("hourly"), 2)
+
+	// A released lease is taken over at the next election
+	a.elector.release()
+	assert.True(t, b.elector.elect())
+	assert.False(t, a.elector.elect())
+}
+
+// flakyLeaser fails to renew leases while fail is set
+type flakyLeaser struct {
+	persist.Leaser
+	fail atomic.Bool
+}
+
+func (f *flakyLeaser) AcquireLease(name string, holder string, ttl time.Duration) (string, error) {
+	if f.fail.Load() {
+		return "", fmt.Errorf("unavailable")
+	}
+	return f.Leaser.AcquireLease(name, holder, ttl)
+}
+
+func TestLeaseOutlivesCycle(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	a := newReplica(t, path, "a")
+	b := newReplica(t, path, "b")
+
+	ttl := 90 * time.Millisecond
+	fl := &flakyLeaser{Leaser: a.elector.leaser}
+	a.elector.leaser = fl
+	a.elector.ttl = ttl
+	b.elector.ttl = ttl
+
+	stop := a.elect(ctx)
+	defer stop()
+
+	// A cycle which outlives the lease keeps it, as it is renewed in the background
+	cctx, cancel, leading := a.elector.term(ctx)
+	defer cancel()
+	require.True(t, leading)
+
+	time.Sleep(3 * ttl)
+	assert.NoError(t, cctx.Err())
+	assert.True(t, a.elector.isLeading())
+	assert.False(t, b.elector.elect())
+
+	// Once the lease can't be renewed, the cycle is cancelled and its results are not persisted
+	fl.fail.Store(true)
+	select {
+	case <-cctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("cycle was not cancelled")
+	}
+	assert.False(t, a.elector.isLeading())
+
+	s, err := a.party.LookupCollection("unused")
+	require.NoError(t, err)
+	require.NoError(t, a.update(ctx, s, time.Time{}))
+	assert.Nil(t, b.results.result("unused"))
+	assert.False(t, b.adopt("unused"))
+
+	_, _, leading = a.elector.term(ctx)
+	assert.False(t, leading)
+
+	// Another replica takes over once the lease expires
+	assert.Eventually(t, b.elector.elect, 10*time.Second, ttl/3)
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	u := newReplica(t, path, "")
+	s, err := u.party.LookupCollection("unused")
+	require.NoError(t, err)
+	require.NoError(t, u.update(ctx, s, time.Time{}))
+	saved := u.results.result("unused")
+	require.NotNil(t, saved)
+	assert.False(t, saved.Restored)
+
+	// After a restart, saved results are served until they are refreshed
+	u = newReplica(t, path, "")
+	n, err := u.Restore()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	r := u.Lookup(ctx, "unused", false)
+	require.NotNil(t, r)
+	assert.True(t, r.Restored)
+	assert.True(t, saved.Created.Equal(r.Created))
+	assert.Equal(t, "unused", r.Collection.ID)
+	assert.Len(t, r.RuleResults, 1)
+	assert.Nil(t, u.Lookup(ctx, "a", false))
+
+	require.NoError(t, u.update(ctx, s, time.Time{}))
+	assert.False(t, u.Lookup(ctx, "unused", false).Restored)
+}
+
+func TestSaveResult(t *testing.T) {
+	ctx := context.Background()
+	u := newReplica(t, filepath.Join(t.TempDir(), "cache.db"), "")
+	s, err := u.party.LookupCollection("unused")
+	require.NoError(t, err)
+	require.NoError(t, u.update(ctx, s, time.Time{}))
+
+	// Results are held by the store, so the cache only writes them to the persistent layer
+	require.NotNil(t, u.cache.Get(resultKey("unused"), time.Time{}))
+	m, err := persist.MetricsFor(u.cache)
+	require.NoError(t, err)
+	hits := map[persist.Layer]int64{}
+	for _, lc := range m.Counts {
+		if lc.Family == "collections" {
+			hits[lc.Layer] = lc.Hits
+		}
+	}
+	assert.Equal(t, map[persist.Layer]int64{persist.MemoryLayer: 0, persist.PersistentLayer: 1}, hits)
+
+	// Memory backends have nowhere to save results
+	c, err := persist.NewMemory(persist.Config{})
+	require.NoError(t, err)
+	require.NoError(t, c.Initialize())
+	u = New(Config{Party: u.party, Cache: c})
+	require.NoError(t, u.update(ctx, s, time.Time{}))
+	assert.NotNil(t, u.results.result("unused"))
+	assert.Nil(t, c.Get(resultKey("unused"), time.Time{}))
+}